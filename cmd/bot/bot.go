@@ -0,0 +1,425 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/login"
+	"github.com/x6Nenko/peril/internal/pubsub"
+	"github.com/x6Nenko/peril/internal/routing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	lobbyTimeout      = 5 * time.Second
+	heartbeatInterval = 5 * time.Second
+)
+
+// bot is a player driven by a strategy instead of a terminal. It logs in,
+// joins through the lobby and publishes like any client.
+type bot struct {
+	name     string
+	gs       *gamelogic.GameState
+	creds    login.Credentials
+	signer   pubsub.Publisher
+	strategy strategy
+	rng      *mathrand.Rand
+	verbose  bool
+	replies  chan routing.LobbyReply
+	events   chan event
+}
+
+func newBot(conn *amqp.Connection, name, password string, serverKey ed25519.PublicKey, s strategy, verbose bool) (*bot, error) {
+	publishCh, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("could not create publish channel: %v", err)
+	}
+	creds, err := login.Login(conn, publishCh, name, password, serverKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not log in: %v", err)
+	}
+	err = creds.FollowKeys(conn, routing.Key(routing.KeysPrefix, name))
+	if err != nil {
+		return nil, fmt.Errorf("could not subscribe to key announcements: %v", err)
+	}
+
+	b := &bot{
+		name:     name,
+		gs:       gamelogic.NewGameState(name),
+		creds:    creds,
+		signer:   creds.Signer,
+		strategy: s,
+		rng:      mathrand.New(mathrand.NewSource(time.Now().UnixNano())),
+		verbose:  verbose,
+		replies:  make(chan routing.LobbyReply, 10),
+		events:   make(chan event, 10),
+	}
+
+	replyKey := routing.Key(routing.LobbyPrefix, routing.ReplySlug, name)
+	err = pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		replyKey,
+		replyKey,
+		pubsub.Transient,
+		func(reply routing.LobbyReply) pubsub.AckType {
+			select {
+			case b.replies <- reply:
+			default:
+			}
+			return pubsub.Ack
+		},
+		pubsub.WithVerifier(creds.Servers),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach the lobby: %v", err)
+	}
+	return b, nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (b *bot) lobbyRequest(action routing.LobbyAction, gameID string) (routing.LobbyReply, error) {
+	req := routing.LobbyRequest{
+		RequestID: newRequestID(),
+		Action:    action,
+		GameID:    gameID,
+		Username:  b.name,
+	}
+	requestKey := routing.Key(routing.LobbyPrefix, routing.RequestSlug, b.name)
+	err := pubsub.PublishJSON(b.signer, routing.ExchangePerilTopic, requestKey, req)
+	if err != nil {
+		return routing.LobbyReply{}, err
+	}
+
+	timeout := time.After(lobbyTimeout)
+	for {
+		select {
+		case reply := <-b.replies:
+			if reply.RequestID != req.RequestID {
+				continue
+			}
+			if !reply.OK {
+				return reply, errors.New(reply.Error)
+			}
+			return reply, nil
+		case <-timeout:
+			return routing.LobbyReply{}, errors.New("no server answered, is the Peril server running?")
+		}
+	}
+}
+
+// join joins the game, creating it if it doesn't exist yet. Another bot may
+// create it between our join and create, so the join is tried again.
+func (b *bot) join(conn *amqp.Connection, gameID string) error {
+	var reply routing.LobbyReply
+	var err error
+	for _, action := range []routing.LobbyAction{routing.LobbyJoin, routing.LobbyCreate, routing.LobbyJoin} {
+		reply, err = b.lobbyRequest(action, gameID)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	game := reply.Games[0]
+	b.gs.JoinGame(game.ID, game.Paused)
+	return b.subscribe(conn, game.ID)
+}
+
+func (b *bot) leave() {
+	err := b.publishHeartbeat(true)
+	if err != nil {
+		log.Printf("%s could not say goodbye to the server: %v", b.name, err)
+	}
+	_, err = b.lobbyRequest(routing.LobbyLeave, b.gs.GetGameID())
+	if err != nil {
+		log.Printf("%s could not leave game %s: %v", b.name, b.gs.GetGameID(), err)
+	}
+}
+
+// subscribe listens to the same game events as a human player's client.
+func (b *bot) subscribe(conn *amqp.Connection, gameID string) error {
+	err := pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilDirect,
+		routing.Key(routing.PauseKey, gameID, b.name),
+		routing.Key(routing.PauseKey, gameID),
+		pubsub.Transient,
+		func(ps routing.PlayingState) pubsub.AckType {
+			b.gs.HandlePause(ps)
+			return pubsub.Ack
+		},
+		pubsub.WithVerifier(b.creds.Servers),
+	)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to pause messages: %v", err)
+	}
+
+	err = pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.Key(routing.ArmyMovesPrefix, gameID, b.name),
+		routing.Key(routing.ArmyMovesPrefix, gameID, "*"),
+		pubsub.Transient,
+		b.handlerMove,
+		pubsub.WithVerifier(b.creds.Players),
+	)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to army moves: %v", err)
+	}
+
+	err = pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.Key(routing.WarRecognitionsPrefix, gameID, b.name),
+		routing.Key(routing.WarRecognitionsPrefix, gameID, b.name, "*"),
+		pubsub.Transient,
+		b.handlerWar,
+		pubsub.WithVerifier(b.creds.Players),
+	)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to war messages: %v", err)
+	}
+
+	warResultsQueue := routing.Key(routing.WarResultsPrefix, gameID, b.name)
+	err = pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		warResultsQueue,
+		routing.Key(routing.WarResultsPrefix, gameID, b.name, "*"),
+		pubsub.Transient,
+		b.handlerWarResult,
+		pubsub.WithVerifier(b.creds.Players),
+	)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to war results: %v", err)
+	}
+	err = pubsub.Bind(conn, routing.ExchangePerilTopic, warResultsQueue, routing.Key(routing.WarAlliesPrefix, gameID, b.name, "*"))
+	if err != nil {
+		return fmt.Errorf("could not subscribe to allied war results: %v", err)
+	}
+
+	err = pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.Key(routing.PresencePrefix, gameID, b.name),
+		routing.Key(routing.PresencePrefix, gameID, "*"),
+		pubsub.Transient,
+		func(ev routing.PresenceEvent) pubsub.AckType {
+			b.gs.HandlePresence(ev)
+			return pubsub.Ack
+		},
+		pubsub.WithVerifier(b.creds.Servers),
+	)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to presence events: %v", err)
+	}
+
+	// The bots of a game share its handoff queue, which the servers keep
+	// while no bot is around
+	err = pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.Key(routing.BotHandoffQueue, gameID),
+		routing.Key(routing.BotHandoffPrefix, gameID, "*"),
+		pubsub.Durable,
+		b.handlerHandoff,
+		pubsub.WithVerifier(b.creds.Servers),
+	)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to bot handoffs: %v", err)
+	}
+	return b.publishHeartbeat(false)
+}
+
+func (b *bot) notify(ev event) {
+	select {
+	case b.events <- ev:
+	default:
+		// The bot is busy, it will see the state on its next turn anyway
+	}
+}
+
+func (b *bot) handlerMove(move gamelogic.ArmyMove) pubsub.AckType {
+	outcome := b.gs.HandleMove(move)
+	if move.Username != b.name && b.gs.IsVisible(move.ToLocation) {
+		b.notify(event{kind: enemySighted, player: move.Username, location: move.ToLocation})
+	}
+
+	switch outcome {
+	case gamelogic.MoveOutComeSafe:
+		return pubsub.Ack
+	case gamelogic.MoveOutcomeMakeWar:
+		warKey := routing.Key(routing.WarRecognitionsPrefix, b.gs.GetGameID(), move.Username, b.name)
+		rw := b.gs.RecognizeWar(move)
+		err := pubsub.PublishJSON(pubsub.Expiring(b.signer, rw.Timeout), routing.ExchangePerilTopic, warKey, rw)
+		if err != nil {
+			log.Printf("%s could not publish war: %v", b.name, err)
+			return pubsub.NackRequeue
+		}
+		return pubsub.Ack
+	default:
+		return pubsub.NackDiscard
+	}
+}
+
+func (b *bot) handlerWar(rw gamelogic.RecognitionOfWar) pubsub.AckType {
+	outcome, result := b.gs.HandleWar(rw)
+
+	switch outcome {
+	case gamelogic.WarOutcomeGathering:
+		return pubsub.Ack
+	case gamelogic.WarOutcomeYouWon, gamelogic.WarOutcomeOpponentWon, gamelogic.WarOutcomeDraw:
+	default:
+		return pubsub.NackDiscard
+	}
+
+	err := b.answerWar(gamelogic.WarAnswer{To: rw.Defender.Username, Result: result})
+	if err != nil {
+		log.Printf("%s could not publish war result: %v", b.name, err)
+		return pubsub.NackRequeue
+	}
+	return pubsub.Ack
+}
+
+// answerWar tells the bot's strategy how a war it attacked in went, and
+// sends the result to a defender.
+func (b *bot) answerWar(answer gamelogic.WarAnswer) error {
+	wr := answer.Result
+	ev := event{kind: warDrawn, player: wr.Defender.Username, location: wr.Location}
+	switch wr.Outcome {
+	case gamelogic.WarAttackerWon:
+		ev.kind = warWon
+	case gamelogic.WarDefenderWon:
+		ev.kind = warLost
+	}
+	if answer.To == wr.Defender.Username {
+		b.notify(ev)
+	}
+	return pubsub.PublishJSON(b.signer, routing.ExchangePerilTopic, answer.Key(), wr)
+}
+
+func (b *bot) handlerWarResult(wr gamelogic.WarResult) pubsub.AckType {
+	outcome, ok := b.gs.HandleWarResult(wr)
+	if !ok {
+		return pubsub.Ack
+	}
+	ev := event{kind: warDrawn, player: wr.Attacker.Username, location: wr.Location}
+	switch outcome {
+	case gamelogic.WarDefenderWon:
+		ev.kind = warWon
+	case gamelogic.WarAttackerWon:
+		ev.kind = warLost
+	}
+	b.notify(ev)
+	return pubsub.Ack
+}
+
+func (b *bot) publishHeartbeat(leaving bool) error {
+	hb := b.gs.NewHeartbeat(leaving)
+	heartbeatKey := routing.Key(routing.HeartbeatPrefix, hb.GameID, hb.Username)
+	err := pubsub.PublishJSON(b.signer, routing.ExchangePerilTopic, heartbeatKey, hb)
+	if err != nil || leaving {
+		return err
+	}
+	reportQueue := routing.Key(routing.ArmyReportsPrefix, b.creds.ServerID)
+	return pubsub.PublishJSON(b.signer, "", reportQueue, b.gs.NewArmyReport())
+}
+
+// handlerHandoff takes over the army of a player who timed out. Each army
+// is handed to one bot of the game.
+func (b *bot) handlerHandoff(handoff gamelogic.BotHandoff) pubsub.AckType {
+	if handoff.GameID != b.gs.GetGameID() {
+		return pubsub.NackDiscard
+	}
+	units := b.gs.AdoptUnits(handoff)
+	log.Printf("%s took over %d units from %s", b.name, len(units), handoff.Username)
+	return pubsub.Ack
+}
+
+// play runs the strategy every interval, and on every event, until stop is
+// closed.
+func (b *bot) play(interval time.Duration, stop <-chan struct{}) {
+	// Start at a random point so bots don't all move at once
+	select {
+	case <-time.After(time.Duration(b.rng.Int63n(int64(interval)))):
+	case <-stop:
+		return
+	}
+	turns := time.NewTicker(interval)
+	defer turns.Stop()
+	heartbeats := time.NewTicker(heartbeatInterval)
+	defer heartbeats.Stop()
+
+	for {
+		var words []string
+		select {
+		case <-stop:
+			return
+		case now := <-heartbeats.C:
+			err := b.publishHeartbeat(false)
+			if err != nil {
+				log.Printf("%s could not publish heartbeat: %v", b.name, err)
+			}
+			for _, answer := range b.gs.FightGatheredWars(now) {
+				err := b.answerWar(answer)
+				if err != nil {
+					log.Printf("%s could not publish war result: %v", b.name, err)
+				}
+			}
+			b.gs.ExpireWars(now)
+			continue
+		case <-turns.C:
+			words = b.strategy.turn(newView(b.gs, b.rng))
+		case ev := <-b.events:
+			if b.verbose {
+				log.Printf("%s: %s in %s", b.name, ev.kind, ev.location)
+			}
+			words = b.strategy.react(newView(b.gs, b.rng), ev)
+		}
+		if words == nil || b.gs.IsPaused() {
+			continue
+		}
+
+		err := b.execute(words)
+		if err != nil {
+			if b.verbose {
+				log.Printf("%s: %v: %v", b.name, words, err)
+			}
+			continue
+		}
+		log.Printf("%s: %v", b.name, words)
+	}
+}
+
+// execute runs a command the way the client does, publishing moves.
+func (b *bot) execute(words []string) error {
+	switch words[0] {
+	case "spawn":
+		return b.gs.CommandSpawn(words)
+	case "promote":
+		return b.gs.CommandPromote(words)
+	case "move":
+		move, err := b.gs.CommandMove(words)
+		if err != nil {
+			return err
+		}
+		moveKey := routing.Key(routing.ArmyMovesPrefix, b.gs.GetGameID(), b.name)
+		return pubsub.PublishJSON(b.signer, routing.ExchangePerilTopic, moveKey, move)
+	}
+	return fmt.Errorf("bots can not %s", words[0])
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/config"
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/login"
+)
+
+func main() {
+	gameID := flag.String("game", "", "game the bots join, created if it doesn't exist")
+	count := flag.Int("count", 1, "number of bots to run")
+	name := flag.String("name", "bot", "username of the bot, numbered from 1 when running several")
+	password := flag.String("password", os.Getenv("PERIL_BOT_PASSWORD"), "password of the bot accounts, registered on first use (defaults to $PERIL_BOT_PASSWORD)")
+	strategyName := flag.String("strategy", "random", fmt.Sprintf("how the bots play: %s, or mixed to cycle through them", strings.Join(strategyNames(), ", ")))
+	interval := flag.Duration("interval", 2*time.Second, "time between a bot's turns")
+	rulesPath := flag.String("rules", "", "path to a YAML or JSON rules file (defaults to the classic rules)")
+	verbose := flag.Bool("v", false, "show the game output and the commands that failed")
+	configLoader := config.Register(flag.CommandLine)
+	flag.Parse()
+	cfg, err := configLoader.Load()
+	if err != nil {
+		log.Fatalf("could not load config: %v", err)
+	}
+	cfg.Apply()
+
+	if *gameID == "" {
+		log.Fatalf("-game is required")
+	}
+	if *password == "" {
+		log.Fatalf("-password or $PERIL_BOT_PASSWORD is required")
+	}
+	if *count < 1 || *interval <= 0 {
+		log.Fatalf("-count and -interval must be positive")
+	}
+	if _, ok := strategies[*strategyName]; !ok && *strategyName != "mixed" {
+		log.Fatalf("unknown strategy %q", *strategyName)
+	}
+	if *rulesPath != "" {
+		rules, err := gamelogic.LoadRules(*rulesPath)
+		if err != nil {
+			log.Fatalf("could not load rules: %v", err)
+		}
+		gamelogic.UseRules(rules)
+	}
+	if !*verbose {
+		// The game prints for humans, which from a crowd of bots is noise
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			log.Fatalf("could not open %s: %v", os.DevNull, err)
+		}
+		os.Stdout = devNull
+	}
+
+	conn, err := cfg.Dial()
+	if err != nil {
+		log.Fatalf("could not connect to RabbitMQ: %v", err)
+	}
+	defer conn.Close()
+	log.Printf("Peril bots connected to RabbitMQ!")
+	serverKey, err := login.ServerKey(cfg.ServerKey)
+	if err != nil {
+		log.Fatalf("invalid server key: %v", err)
+	}
+
+	bots := []*bot{}
+	for i := 0; i < *count; i++ {
+		botName := *name
+		if *count > 1 {
+			botName = fmt.Sprintf("%s%d", *name, i+1)
+		}
+		botStrategy := *strategyName
+		if botStrategy == "mixed" {
+			names := strategyNames()
+			botStrategy = names[i%len(names)]
+		}
+		b, err := newBot(conn, botName, *password, serverKey, strategies[botStrategy](), *verbose)
+		if err != nil {
+			log.Fatalf("could not start %s: %v", botName, err)
+		}
+		// The other bots only trust the server the first one trusted
+		serverKey = ed25519.PublicKey(b.creds.Servers)
+		err = b.join(conn, *gameID)
+		if err != nil {
+			log.Fatalf("%s could not join game %s: %v", botName, *gameID, err)
+		}
+		log.Printf("%s joined game %s playing %s", botName, *gameID, botStrategy)
+		bots = append(bots, b)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, b := range bots {
+		wg.Add(1)
+		go func(b *bot) {
+			defer wg.Done()
+			b.play(*interval, stop)
+			b.leave()
+		}(b)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Printf("Stopping %d bots...", len(bots))
+	close(stop)
+	wg.Wait()
+}
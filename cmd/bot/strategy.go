@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+)
+
+type eventKind int
+
+const (
+	// enemySighted is another player's move into a location the bot can see
+	enemySighted eventKind = iota
+	warWon
+	warLost
+	warDrawn
+)
+
+func (k eventKind) String() string {
+	switch k {
+	case enemySighted:
+		return "enemy sighted"
+	case warWon:
+		return "war won"
+	case warLost:
+		return "war lost"
+	case warDrawn:
+		return "war drawn"
+	}
+	return fmt.Sprintf("event %d", int(k))
+}
+
+// event is something that happened to a bot between turns.
+type event struct {
+	kind     eventKind
+	player   string
+	location gamelogic.Location
+}
+
+// strategy decides what a bot does. Both methods return one client command,
+// e.g. []string{"spawn", "europe", "infantry"}, or nil to do nothing.
+type strategy interface {
+	// turn is called on every tick of the bot's schedule
+	turn(v view) []string
+	// react is called for every event, right away
+	react(v view, ev event) []string
+}
+
+var strategies = map[string]func() strategy{
+	"random":     func() strategy { return randomStrategy{} },
+	"aggressive": func() strategy { return aggressiveStrategy{} },
+	"defensive":  func() strategy { return &defensiveStrategy{} },
+	"greedy":     func() strategy { return greedyStrategy{} },
+}
+
+func strategyNames() []string {
+	names := []string{}
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// view is what a strategy knows about the game when it decides.
+type view struct {
+	units     []gamelogic.Unit
+	sightings map[string][]gamelogic.Unit
+	rules     *gamelogic.Rules
+	rng       *rand.Rand
+}
+
+func newView(gs *gamelogic.GameState, rng *rand.Rand) view {
+	units := []gamelogic.Unit{}
+	for _, unit := range gs.GetPlayerSnap().Units {
+		units = append(units, unit)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].ID < units[j].ID
+	})
+	return view{
+		units:     units,
+		sightings: gs.GetSightingsSnap(),
+		rules:     gamelogic.CurrentRules(),
+		rng:       rng,
+	}
+}
+
+func (v view) locations() []gamelogic.Location {
+	locations := []gamelogic.Location{}
+	for _, territory := range v.rules.Map.Territories {
+		locations = append(locations, territory.Name)
+	}
+	return locations
+}
+
+func (v view) adjacent(loc gamelogic.Location) []gamelogic.Location {
+	for _, territory := range v.rules.Map.Territories {
+		if territory.Name == loc {
+			return territory.Adjacent
+		}
+	}
+	return nil
+}
+
+func (v view) isAdjacent(from, to gamelogic.Location) bool {
+	for _, loc := range v.adjacent(from) {
+		if loc == to {
+			return true
+		}
+	}
+	return false
+}
+
+// stacks groups the bot's units by location.
+func (v view) stacks() map[gamelogic.Location][]gamelogic.Unit {
+	stacks := map[gamelogic.Location][]gamelogic.Unit{}
+	for _, unit := range v.units {
+		stacks[unit.Location] = append(stacks[unit.Location], unit)
+	}
+	return stacks
+}
+
+// stackLocations lists the locations of the bot's units in a stable order.
+func (v view) stackLocations() []gamelogic.Location {
+	locations := []gamelogic.Location{}
+	for loc := range v.stacks() {
+		locations = append(locations, loc)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		return locations[i] < locations[j]
+	})
+	return locations
+}
+
+// enemyLocations lists where the bot has seen other players' units.
+func (v view) enemyLocations() []gamelogic.Location {
+	seen := map[gamelogic.Location]struct{}{}
+	for _, units := range v.sightings {
+		for _, unit := range units {
+			seen[unit.Location] = struct{}{}
+		}
+	}
+	locations := []gamelogic.Location{}
+	for loc := range seen {
+		locations = append(locations, loc)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		return locations[i] < locations[j]
+	})
+	return locations
+}
+
+// power is a rough estimate of units' strength in a war: the rank's power
+// scaled by health.
+func (v view) power(units []gamelogic.Unit) float64 {
+	total := 0.0
+	for _, unit := range units {
+		unitType, _ := v.rules.UnitType(unit.Rank)
+		total += unitType.Power * float64(unit.Health) / gamelogic.MaxUnitHealth
+	}
+	return total
+}
+
+func (v view) enemyPower(loc gamelogic.Location) float64 {
+	units := []gamelogic.Unit{}
+	for _, sighted := range v.sightings {
+		for _, unit := range sighted {
+			if unit.Location == loc {
+				units = append(units, unit)
+			}
+		}
+	}
+	return v.power(units)
+}
+
+func (v view) randomLocation() gamelogic.Location {
+	locations := v.locations()
+	return locations[v.rng.Intn(len(locations))]
+}
+
+func (v view) randomRank() gamelogic.UnitRank {
+	return v.rules.Units[v.rng.Intn(len(v.rules.Units))].Rank
+}
+
+// cheapestRank is the rank the bot can field the most of.
+func (v view) cheapestRank() gamelogic.UnitRank {
+	best := v.rules.Units[0]
+	for _, unitType := range v.rules.Units[1:] {
+		if unitType.Cost < best.Cost {
+			best = unitType
+		}
+	}
+	return best.Rank
+}
+
+// bestValueRank is the rank with the most power for its cost.
+func (v view) bestValueRank() gamelogic.UnitRank {
+	value := func(ut gamelogic.UnitType) float64 {
+		return ut.Power / float64(max(ut.Cost, 1))
+	}
+	best := v.rules.Units[0]
+	for _, unitType := range v.rules.Units[1:] {
+		if value(unitType) > value(best) {
+			best = unitType
+		}
+	}
+	return best.Rank
+}
+
+// promotable returns a unit with enough experience for a promotion.
+func (v view) promotable() (gamelogic.Unit, bool) {
+	for _, unit := range v.units {
+		unitType, _ := v.rules.UnitType(unit.Rank)
+		if unitType.PromotesTo != "" && unit.Experience >= gamelogic.PromotionCost {
+			return unit, true
+		}
+	}
+	return gamelogic.Unit{}, false
+}
+
+func spawn(loc gamelogic.Location, rank gamelogic.UnitRank) []string {
+	return []string{"spawn", string(loc), string(rank)}
+}
+
+func promote(unit gamelogic.Unit) []string {
+	return []string{"promote", strconv.Itoa(unit.ID)}
+}
+
+func move(to gamelogic.Location, units []gamelogic.Unit) []string {
+	words := []string{"move", string(to)}
+	for _, unit := range units {
+		words = append(words, strconv.Itoa(unit.ID))
+	}
+	return words
+}
+
+// attack moves a stack into an adjacent location with enemies in it, if the
+// stack has at least ratio times their power.
+func (v view) attack(ratio float64) []string {
+	stacks := v.stacks()
+	for _, target := range v.enemyLocations() {
+		for _, loc := range v.stackLocations() {
+			stack := stacks[loc]
+			if !v.isAdjacent(loc, target) {
+				continue
+			}
+			if v.power(stack) >= ratio*v.enemyPower(target) {
+				return move(target, stack)
+			}
+		}
+	}
+	return nil
+}
+
+// randomStrategy spawns and wanders at random.
+type randomStrategy struct{}
+
+func (randomStrategy) turn(v view) []string {
+	if len(v.units) == 0 || v.rng.Float64() < 0.3 {
+		return spawn(v.randomLocation(), v.randomRank())
+	}
+	unit := v.units[v.rng.Intn(len(v.units))]
+	adjacent := v.adjacent(unit.Location)
+	if len(adjacent) == 0 {
+		return nil
+	}
+	return move(adjacent[v.rng.Intn(len(adjacent))], []gamelogic.Unit{unit})
+}
+
+func (randomStrategy) react(v view, ev event) []string {
+	return nil
+}
+
+// aggressiveStrategy floods the map with cheap units and throws them at
+// every enemy it sees, whatever the odds.
+type aggressiveStrategy struct{}
+
+func (aggressiveStrategy) turn(v view) []string {
+	if len(v.units) < 3 || v.rng.Float64() < 0.25 {
+		return spawn(v.randomLocation(), v.cheapestRank())
+	}
+	if words := v.attack(0); words != nil {
+		return words
+	}
+	// Nobody in reach, go looking
+	locations := v.stackLocations()
+	loc := locations[v.rng.Intn(len(locations))]
+	adjacent := v.adjacent(loc)
+	if len(adjacent) == 0 {
+		return nil
+	}
+	return move(adjacent[v.rng.Intn(len(adjacent))], v.stacks()[loc])
+}
+
+func (aggressiveStrategy) react(v view, ev event) []string {
+	if ev.kind != enemySighted {
+		return nil
+	}
+	stacks := v.stacks()
+	for _, loc := range v.stackLocations() {
+		if v.isAdjacent(loc, ev.location) {
+			return move(ev.location, stacks[loc])
+		}
+	}
+	return nil
+}
+
+// defensiveStrategy holds one home territory, never attacks, and
+// reinforces home when threatened.
+type defensiveStrategy struct {
+	home gamelogic.Location
+}
+
+func (s *defensiveStrategy) homeFor(v view) gamelogic.Location {
+	if s.home == "" {
+		if len(v.units) > 0 {
+			s.home = v.units[0].Location
+		} else {
+			s.home = v.randomLocation()
+		}
+	}
+	return s.home
+}
+
+func (s *defensiveStrategy) turn(v view) []string {
+	home := s.homeFor(v)
+	if unit, ok := v.promotable(); ok {
+		return promote(unit)
+	}
+	// Bring strays back home
+	for _, unit := range v.units {
+		if unit.Location != home && v.isAdjacent(unit.Location, home) {
+			return move(home, []gamelogic.Unit{unit})
+		}
+	}
+	if v.rng.Float64() < 0.5 {
+		return spawn(home, s.defender(v))
+	}
+	return nil
+}
+
+// defender prefers units that fortify, which fight better at home.
+func (s *defensiveStrategy) defender(v view) gamelogic.UnitRank {
+	for _, unitType := range v.rules.Units {
+		if unitType.HasAbility(gamelogic.AbilityFortify) {
+			return unitType.Rank
+		}
+	}
+	return v.bestValueRank()
+}
+
+func (s *defensiveStrategy) react(v view, ev event) []string {
+	home := s.homeFor(v)
+	switch ev.kind {
+	case enemySighted:
+		if ev.location == home || v.isAdjacent(home, ev.location) {
+			return spawn(home, s.defender(v))
+		}
+	case warLost:
+		return spawn(home, s.defender(v))
+	}
+	return nil
+}
+
+// greedyStrategy maximizes power: it spawns the best value units, promotes
+// whenever it can, and only picks fights it expects to win.
+type greedyStrategy struct{}
+
+func (greedyStrategy) turn(v view) []string {
+	if unit, ok := v.promotable(); ok {
+		return promote(unit)
+	}
+	if words := v.attack(1.5); words != nil {
+		return words
+	}
+	// Grow the biggest stack
+	loc := v.randomLocation()
+	biggest := 0
+	stacks := v.stacks()
+	for _, l := range v.stackLocations() {
+		if len(stacks[l]) > biggest {
+			loc, biggest = l, len(stacks[l])
+		}
+	}
+	return spawn(loc, v.bestValueRank())
+}
+
+func (greedyStrategy) react(v view, ev event) []string {
+	switch ev.kind {
+	case enemySighted, warWon:
+		return v.attack(1.5)
+	}
+	return nil
+}
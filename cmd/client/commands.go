@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/pubsub"
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+// client runs the in-game commands of a player, for both the REPL and the
+// terminal UI.
+type client struct {
+	gs       *gamelogic.GameState
+	signer   pubsub.Publisher
+	gameID   string
+	username string
+	lobby    *lobbyClient
+}
+
+// commandSource feeds commands to the lobby and the game: the terminal or a
+// script.
+type commandSource interface {
+	// next returns the next command, or false once there are none left
+	next() ([]string, bool)
+	// report shows why a command failed and tells whether to carry on
+	report(err error) bool
+}
+
+// terminal reads commands from stdin.
+type terminal struct{}
+
+func (terminal) next() ([]string, bool) {
+	return gamelogic.ReadInput()
+}
+
+func (terminal) report(err error) bool {
+	fmt.Println(err)
+	return true
+}
+
+// run executes commands until the player quits or the source runs dry,
+// which quits too.
+func (c *client) run(src commandSource) {
+	for {
+		words, ok := src.next()
+		if !ok {
+			c.execute([]string{"quit"})
+			return
+		}
+		quit, err := c.execute(words)
+		if err != nil && !src.report(err) {
+			c.execute([]string{"quit"})
+			return
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// execute runs one command and reports whether the player quit, or why the
+// command failed.
+func (c *client) execute(words []string) (bool, error) {
+	if len(words) == 0 {
+		return false, nil
+	}
+	var err error
+	switch words[0] {
+	case "move":
+		move, err := c.gs.CommandMove(words)
+		if err != nil {
+			return false, err
+		}
+
+		// Publish the move to army_moves.username routing key
+		moveKey := routing.Key(routing.ArmyMovesPrefix, c.gameID, c.username)
+		err = pubsub.PublishJSON(c.signer, routing.ExchangePerilTopic, moveKey, move)
+		if err != nil {
+			return false, fmt.Errorf("error: failed to publish move: %v", err)
+		}
+		fmt.Printf("Move published successfully to %s\n", moveKey)
+	case "spawn":
+		err = c.gs.CommandSpawn(words)
+		if err != nil {
+			return false, err
+		}
+	case "promote":
+		err = c.gs.CommandPromote(words)
+		if err != nil {
+			return false, err
+		}
+	case "retreat":
+		move, err := c.gs.CommandRetreat(words)
+		if err != nil {
+			return false, err
+		}
+
+		// Other players see a retreat like any other move
+		moveKey := routing.Key(routing.ArmyMovesPrefix, c.gameID, c.username)
+		err = pubsub.PublishJSON(c.signer, routing.ExchangePerilTopic, moveKey, move)
+		if err != nil {
+			return false, fmt.Errorf("error: failed to publish retreat: %v", err)
+		}
+		fmt.Printf("Retreat published successfully to %s\n", moveKey)
+	case "propose", "accept", "break":
+		var dm gamelogic.DiplomacyMessage
+		switch words[0] {
+		case "propose":
+			dm, err = c.gs.CommandPropose(words)
+		case "accept":
+			dm, err = c.gs.CommandAccept(words)
+		case "break":
+			dm, err = c.gs.CommandBreak(words)
+		}
+		if err != nil {
+			return false, err
+		}
+		err = publishDiplomacy(c.signer, c.gameID, dm)
+		if err != nil {
+			return false, fmt.Errorf("error: failed to publish diplomacy message: %v", err)
+		}
+	case "say", "whisper", "ally":
+		messages, err := c.gs.CommandChat(words)
+		if err != nil {
+			return false, err
+		}
+		for _, msg := range messages {
+			err = pubsub.PublishJSON(c.signer, routing.ExchangePerilTopic, chatKey(c.gameID, msg), msg)
+			if err != nil {
+				return false, fmt.Errorf("error: failed to publish chat message: %v", err)
+			}
+		}
+	case "status":
+		c.gs.CommandStatus()
+	case "rules":
+		gamelogic.PrintRules()
+	case "leaderboard":
+		err = c.lobby.leaderboard(words)
+		if err != nil {
+			return false, err
+		}
+	case "help":
+		gamelogic.PrintClientHelp()
+	case "spam":
+		if len(words) < 2 {
+			return false, errors.New("error: spam command requires a number argument (e.g., 'spam 10')")
+		}
+
+		n, err := strconv.Atoi(words[1])
+		if err != nil {
+			return false, fmt.Errorf("error: invalid number '%s': %v", words[1], err)
+		}
+
+		for i := 0; i < n; i++ {
+			maliciousLog := gamelogic.GetMaliciousLog()
+
+			gameLog := routing.GameLog{
+				CurrentTime: time.Now(),
+				Message:     maliciousLog,
+				Username:    c.username,
+				GameID:      c.gameID,
+			}
+
+			routingKey := routing.Key(routing.GameLogSlug, c.gameID, c.username)
+			err := pubsub.PublishGob(c.signer, routing.ExchangePerilTopic, routingKey, gameLog)
+			if err != nil {
+				fmt.Printf("error: failed to publish game log: %v\n", err)
+				continue
+			}
+		}
+		fmt.Printf("Sent %d malicious logs!\n", n)
+	case "quit":
+		err = publishHeartbeat(c.signer, c.gs, true)
+		if err != nil {
+			log.Printf("could not say goodbye to the server: %v", err)
+		}
+		gamelogic.PrintQuit()
+		return true, nil
+	default:
+		return false, errors.New("unknown command")
+	}
+	return false, nil
+}
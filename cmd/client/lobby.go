@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/pubsub"
+	"github.com/x6Nenko/peril/internal/routing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const lobbyTimeout = 5 * time.Second
+
+type lobbyClient struct {
+	publishCh pubsub.Publisher
+	username  string
+	replies   chan routing.LobbyReply
+}
+
+// newLobbyClient only takes replies the servers signed.
+func newLobbyClient(conn *amqp.Connection, publishCh pubsub.Publisher, servers pubsub.Verifier, username string) (*lobbyClient, error) {
+	lc := &lobbyClient{
+		publishCh: publishCh,
+		username:  username,
+		replies:   make(chan routing.LobbyReply, 10),
+	}
+
+	replyKey := routing.Key(routing.LobbyPrefix, routing.ReplySlug, username)
+	err := pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		replyKey,
+		replyKey,
+		pubsub.Transient,
+		func(reply routing.LobbyReply) pubsub.AckType {
+			select {
+			case lc.replies <- reply:
+			default:
+			}
+			return pubsub.Ack
+		},
+		pubsub.WithVerifier(servers),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return lc, nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// request sends a lobby request and waits for its reply. Late replies to
+// earlier requests are skipped.
+func (lc *lobbyClient) request(action routing.LobbyAction, gameID string) (routing.LobbyReply, error) {
+	return lc.send(routing.LobbyRequest{
+		RequestID: newRequestID(),
+		Action:    action,
+		GameID:    gameID,
+		Username:  lc.username,
+	})
+}
+
+func (lc *lobbyClient) send(req routing.LobbyRequest) (routing.LobbyReply, error) {
+	requestKey := routing.Key(routing.LobbyPrefix, routing.RequestSlug, lc.username)
+	err := pubsub.PublishJSON(lc.publishCh, routing.ExchangePerilTopic, requestKey, req)
+	if err != nil {
+		return routing.LobbyReply{}, err
+	}
+
+	timeout := time.After(lobbyTimeout)
+	for {
+		select {
+		case reply := <-lc.replies:
+			if reply.RequestID != req.RequestID {
+				continue
+			}
+			if !reply.OK {
+				return reply, errors.New(reply.Error)
+			}
+			return reply, nil
+		case <-timeout:
+			return routing.LobbyReply{}, errors.New("no server answered, is the Peril server running?")
+		}
+	}
+}
+
+// run is the lobby REPL. It returns the joined game, or false if the
+// player quit.
+func (lc *lobbyClient) run(src commandSource) (routing.GameInfo, bool) {
+	for {
+		words, ok := src.next()
+		if !ok {
+			return routing.GameInfo{}, false
+		}
+		if len(words) == 0 {
+			continue
+		}
+		game, joined, err := lc.execute(words)
+		if err != nil {
+			if !src.report(err) {
+				return routing.GameInfo{}, false
+			}
+			continue
+		}
+		if joined || words[0] == "quit" {
+			return game, joined
+		}
+	}
+}
+
+// execute runs one lobby command and returns the game it joined, if any.
+func (lc *lobbyClient) execute(words []string) (routing.GameInfo, bool, error) {
+	switch words[0] {
+	case "list":
+		reply, err := lc.request(routing.LobbyList, "")
+		if err != nil {
+			return routing.GameInfo{}, false, fmt.Errorf("error: %v", err)
+		}
+		if len(reply.Games) == 0 {
+			fmt.Println("No games yet. Create one with 'create <game>'.")
+		}
+		for _, game := range reply.Games {
+			state := "running"
+			if game.Paused {
+				state = "paused"
+			}
+			fmt.Printf("* %s (%s): %v\n", game.ID, state, game.Players)
+		}
+	case "create", "join":
+		if len(words) < 2 {
+			return routing.GameInfo{}, false, fmt.Errorf("usage: %s <game>", words[0])
+		}
+		action := routing.LobbyJoin
+		if words[0] == "create" {
+			action = routing.LobbyCreate
+		}
+		reply, err := lc.request(action, words[1])
+		if err != nil {
+			return routing.GameInfo{}, false, fmt.Errorf("error: %v", err)
+		}
+		return reply.Games[0], true, nil
+	case "leaderboard":
+		err := lc.leaderboard(words)
+		if err != nil {
+			return routing.GameInfo{}, false, err
+		}
+	case "help":
+		gamelogic.PrintLobbyHelp()
+	case "quit":
+	default:
+		return routing.GameInfo{}, false, errors.New("unknown command")
+	}
+	return routing.GameInfo{}, false, nil
+}
+
+func (lc *lobbyClient) leave(gameID string) {
+	_, err := lc.request(routing.LobbyLeave, gameID)
+	if err != nil {
+		log.Printf("could not leave game %s: %v", gameID, err)
+	}
+}
+
+// leaderboard shows the best rated players, asking for as many as the
+// command names or the server's default.
+func (lc *lobbyClient) leaderboard(words []string) error {
+	limit := 0
+	if len(words) > 1 {
+		n, err := strconv.Atoi(words[1])
+		if err != nil || n < 1 {
+			return errors.New("usage: leaderboard [n]")
+		}
+		limit = n
+	}
+	reply, err := lc.send(routing.LobbyRequest{
+		RequestID: newRequestID(),
+		Action:    routing.LobbyLeaderboard,
+		Username:  lc.username,
+		Limit:     limit,
+	})
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+	gamelogic.PrintLeaderboard(reply.Leaderboard)
+	return nil
+}
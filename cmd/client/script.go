@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expectGrace is how long expect gives a command's output to show up.
+const expectGrace = 2 * time.Second
+
+// outputLog tees stdout to the terminal and keeps the lines so a script can
+// look for them.
+type outputLog struct {
+	tty   *os.File
+	w     *os.File
+	done  chan struct{}
+	mu    sync.Mutex
+	lines []string
+	// grew is closed, and replaced, whenever a line is added
+	grew chan struct{}
+}
+
+func captureOutput() (*outputLog, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	out := &outputLog{
+		tty:  os.Stdout,
+		w:    w,
+		done: make(chan struct{}),
+		grew: make(chan struct{}),
+	}
+	os.Stdout = w
+	go out.read(r)
+	return out, nil
+}
+
+func (out *outputLog) read(r io.Reader) {
+	defer close(out.done)
+	scanner := bufio.NewScanner(io.TeeReader(r, out.tty))
+	for scanner.Scan() {
+		out.mu.Lock()
+		out.lines = append(out.lines, scanner.Text())
+		close(out.grew)
+		out.grew = make(chan struct{})
+		out.mu.Unlock()
+	}
+}
+
+// stop puts stdout back once everything written so far has been shown.
+func (out *outputLog) stop() {
+	os.Stdout = out.tty
+	out.w.Close()
+	<-out.done
+}
+
+func (out *outputLog) len() int {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	return len(out.lines)
+}
+
+// waitFor waits until a line from index from on contains text.
+func (out *outputLog) waitFor(text string, from int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		out.mu.Lock()
+		for _, line := range out.lines[from:] {
+			if strings.Contains(line, text) {
+				out.mu.Unlock()
+				return true
+			}
+		}
+		from = len(out.lines)
+		grew := out.grew
+		out.mu.Unlock()
+
+		select {
+		case <-grew:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// script feeds commands from a file. Besides lobby and game commands it
+// understands a few directives:
+//
+//	sleep <duration>  pause, e.g. sleep 500ms
+//	wait <text>       wait for output containing text, like another
+//	                  player's move, since the last command
+//	expect <text>     fail unless the last command printed text
+//
+// Blank lines and lines starting with # are skipped. The first failing
+// command or directive stops the script.
+type script struct {
+	path    string
+	scanner *bufio.Scanner
+	line    int
+	timeout time.Duration
+	out     *outputLog
+	// mark is where the output of the last command starts
+	mark   int
+	failed bool
+}
+
+func openScript(path string, timeout time.Duration, out *outputLog) (*script, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &script{
+		path:    path,
+		scanner: bufio.NewScanner(f),
+		timeout: timeout,
+		out:     out,
+	}, f, nil
+}
+
+func (s *script) next() ([]string, bool) {
+	for !s.failed && s.scanner.Scan() {
+		s.line++
+		words := strings.Fields(s.scanner.Text())
+		if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		}
+		text := strings.Join(words[1:], " ")
+		switch words[0] {
+		case "sleep":
+			if len(words) != 2 {
+				s.report(errors.New("usage: sleep <duration>"))
+				continue
+			}
+			d, err := time.ParseDuration(words[1])
+			if err != nil {
+				s.report(fmt.Errorf("invalid duration %q", words[1]))
+				continue
+			}
+			time.Sleep(d)
+		case "wait":
+			if !s.out.waitFor(text, s.mark, s.timeout) {
+				s.report(fmt.Errorf("nothing matching %q within %s", text, s.timeout))
+			}
+		case "expect":
+			if !s.out.waitFor(text, s.mark, expectGrace) {
+				s.report(fmt.Errorf("expected %q", text))
+			}
+		default:
+			s.mark = s.out.len()
+			fmt.Printf("> %s\n", strings.Join(words, " "))
+			return words, true
+		}
+	}
+	if err := s.scanner.Err(); err != nil && !s.failed {
+		s.report(err)
+	}
+	return nil, false
+}
+
+func (s *script) report(err error) bool {
+	s.failed = true
+	fmt.Printf("%s:%d: %v\n", s.path, s.line, err)
+	return false
+}
+
+// exitStatus is non-zero once the script has failed. Without a script it is
+// always zero.
+func (s *script) exitStatus() int {
+	if s != nil && s.failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capture points stdout at an outputLog that tees to /dev/null instead of
+// the test output.
+func capture(t *testing.T) *outputLog {
+	t.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", os.DevNull, err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	out, err := captureOutput()
+	if err != nil {
+		os.Stdout = stdout
+		t.Fatalf("captureOutput: %v", err)
+	}
+	t.Cleanup(func() {
+		out.stop()
+		os.Stdout = stdout
+		devNull.Close()
+	})
+	return out
+}
+
+func writeScript(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.peril")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("could not write script: %v", err)
+	}
+	return path
+}
+
+func newTestScript(t *testing.T, timeout time.Duration, lines ...string) (*script, *outputLog) {
+	t.Helper()
+	out := capture(t)
+	s, f, err := openScript(writeScript(t, lines...), timeout, out)
+	if err != nil {
+		t.Fatalf("openScript: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return s, out
+}
+
+func TestOutputLogWaitFor(t *testing.T) {
+	out := capture(t)
+	fmt.Println("alice joined")
+	if !out.waitFor("alice", 0, time.Second) {
+		t.Fatalf("waitFor(alice) = false, want true")
+	}
+	if out.waitFor("alice", out.len(), 10*time.Millisecond) {
+		t.Errorf("waitFor(alice) found a line from before the mark")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		fmt.Println("bob moved to europe")
+	}()
+	if !out.waitFor("europe", out.len(), time.Second) {
+		t.Errorf("waitFor(europe) missed a line printed while waiting")
+	}
+	if out.waitFor("asia", 0, 10*time.Millisecond) {
+		t.Errorf("waitFor(asia) = true, want a timeout")
+	}
+}
+
+func TestScriptNext(t *testing.T) {
+	s, _ := newTestScript(t, time.Second,
+		"# join the first game",
+		"",
+		"list",
+		"expect g1",
+		"sleep 1ms",
+		"  join   g1  ",
+		"wait bob moved",
+	)
+
+	words, ok := s.next()
+	if !ok || !reflect.DeepEqual(words, []string{"list"}) {
+		t.Fatalf("next() = %v, %v, want [list]", words, ok)
+	}
+	fmt.Println("g1 [alice]")
+
+	words, ok = s.next()
+	if !ok || !reflect.DeepEqual(words, []string{"join", "g1"}) {
+		t.Fatalf("next() = %v, %v, want [join g1]", words, ok)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		fmt.Println("bob moved to europe")
+	}()
+
+	if words, ok = s.next(); ok {
+		t.Fatalf("next() at the end = %v, want no more commands", words)
+	}
+	if s.failed || s.exitStatus() != 0 {
+		t.Errorf("script failed, want it to succeed")
+	}
+	if s.line != 7 {
+		t.Errorf("line = %d, want 7", s.line)
+	}
+}
+
+func TestScriptFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr string
+	}{
+		{"sleep usage", []string{"sleep"}, "test.peril:1: usage: sleep <duration>"},
+		{"sleep duration", []string{"# wait a bit", "sleep soon"}, `test.peril:2: invalid duration "soon"`},
+		{"wait timeout", []string{"list", "wait bob"}, `test.peril:2: nothing matching "bob" within 10ms`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, out := newTestScript(t, 10*time.Millisecond, append(tt.lines, "quit")...)
+			for {
+				words, ok := s.next()
+				if !ok {
+					break
+				}
+				if words[0] == "quit" {
+					t.Fatalf("the script went on after failing")
+				}
+			}
+			if s.exitStatus() != 1 {
+				t.Errorf("exitStatus() = %d, want 1", s.exitStatus())
+			}
+			if !out.waitFor(tt.wantErr, 0, time.Second) {
+				t.Errorf("the failure was not reported as %q", tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScriptExpectOnlyLooksAtTheLastCommand(t *testing.T) {
+	s, out := newTestScript(t, time.Second, "list", "expect g1", "quit")
+	fmt.Println("g1 [alice]")
+	// Let the line reach the log before list marks where its output starts
+	if !out.waitFor("g1", 0, time.Second) {
+		t.Fatalf("the output never reached the log")
+	}
+	if _, ok := s.next(); !ok {
+		t.Fatalf("next() = false, want list")
+	}
+	if words, ok := s.next(); ok {
+		t.Fatalf("next() = %v, want expect to fail on output from before list", words)
+	}
+	if s.exitStatus() != 1 {
+		t.Errorf("exitStatus() = %d, want 1", s.exitStatus())
+	}
+	if !out.waitFor(`test.peril:2: expected "g1"`, 0, time.Second) {
+		t.Errorf("the failed expect was not reported")
+	}
+}
+
+func TestExitStatusWithoutScript(t *testing.T) {
+	var s *script
+	if got := s.exitStatus(); got != 0 {
+		t.Errorf("exitStatus() without a script = %d, want 0", got)
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"golang.org/x/term"
+)
+
+const (
+	tuiMaxEvents   = 1000
+	tuiLogFile     = "peril-client.log"
+	tuiRefreshRate = time.Second
+)
+
+var tuiCommands = []string{
+	"move", "spawn", "promote", "retreat",
+	"propose", "accept", "break",
+	"say", "whisper", "ally",
+	"status", "rules", "leaderboard", "help", "spam", "quit",
+}
+
+// tui is a full-screen alternative to the REPL. Everything the game prints
+// to stdout is captured into the event log pane, so handler output never
+// tears through the command line.
+type tui struct {
+	c       *client
+	tty     *os.File
+	fd      int
+	events  []string
+	input   []rune
+	history []string
+	histPos int
+	hint    string
+	mu      sync.Mutex
+	redraw  chan struct{}
+}
+
+func runTUI(c *client) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("the terminal UI needs an interactive terminal")
+	}
+
+	t := &tui{
+		c:      c,
+		tty:    os.Stdout,
+		fd:     fd,
+		redraw: make(chan struct{}, 1),
+	}
+
+	// Capture game output into the event log, and keep log output off the
+	// screen entirely
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stdout = w
+	defer func() {
+		os.Stdout = t.tty
+		w.Close()
+	}()
+	logFile, err := os.OpenFile(tuiLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %v", tuiLogFile, err)
+	}
+	defer logFile.Close()
+	log.SetOutput(logFile)
+	defer log.SetOutput(os.Stderr)
+	go t.captureOutput(r)
+
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, oldState)
+	fmt.Fprint(t.tty, "\x1b[?1049h")
+	defer fmt.Fprint(t.tty, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan []byte)
+	go readKeys(keys)
+
+	refresh := time.NewTicker(tuiRefreshRate)
+	defer refresh.Stop()
+	t.draw()
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				c.execute([]string{"quit"})
+				return nil
+			}
+			if t.handleKeys(key) {
+				return nil
+			}
+		case <-t.redraw:
+		case <-refresh.C:
+		}
+		t.draw()
+	}
+}
+
+func (t *tui) captureOutput(r *os.File) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// The handlers print their own prompts, which the TUI doesn't need
+		line := scanner.Text()
+		for strings.HasPrefix(line, "> ") {
+			line = strings.TrimPrefix(line, "> ")
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		t.addEvent(line)
+	}
+}
+
+func (t *tui) addEvent(line string) {
+	t.mu.Lock()
+	t.events = append(t.events, line)
+	if len(t.events) > tuiMaxEvents {
+		t.events = t.events[len(t.events)-tuiMaxEvents:]
+	}
+	t.mu.Unlock()
+	select {
+	case t.redraw <- struct{}{}:
+	default:
+	}
+}
+
+func readKeys(keys chan<- []byte) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		key := make([]byte, n)
+		copy(key, buf[:n])
+		keys <- key
+	}
+}
+
+// handleKeys applies a chunk of keyboard input and reports whether the
+// player quit.
+func (t *tui) handleKeys(data []byte) bool {
+	for len(data) > 0 {
+		// Arrow keys arrive as escape sequences
+		if data[0] == 0x1b && len(data) >= 3 && data[1] == '[' {
+			switch data[2] {
+			case 'A':
+				t.browseHistory(-1)
+			case 'B':
+				t.browseHistory(1)
+			}
+			data = data[3:]
+			continue
+		}
+
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		switch r {
+		case '\r', '\n':
+			if t.submit() {
+				return true
+			}
+		case '\t':
+			t.complete()
+		case 127, '\b':
+			if len(t.input) > 0 {
+				t.input = t.input[:len(t.input)-1]
+			}
+		case 0x15: // Ctrl-U
+			t.input = nil
+		case 0x03, 0x04: // Ctrl-C, Ctrl-D
+			t.c.execute([]string{"quit"})
+			return true
+		default:
+			if r >= ' ' && r != utf8.RuneError {
+				t.input = append(t.input, r)
+			}
+		}
+	}
+	return false
+}
+
+func (t *tui) browseHistory(step int) {
+	pos := t.histPos + step
+	if pos < 0 || pos > len(t.history) {
+		return
+	}
+	t.histPos = pos
+	if pos == len(t.history) {
+		t.input = nil
+		return
+	}
+	t.input = []rune(t.history[pos])
+}
+
+func (t *tui) submit() bool {
+	line := strings.TrimSpace(string(t.input))
+	t.input = nil
+	t.hint = ""
+	if line == "" {
+		return false
+	}
+	if len(t.history) == 0 || t.history[len(t.history)-1] != line {
+		t.history = append(t.history, line)
+	}
+	t.histPos = len(t.history)
+	t.addEvent("> " + line)
+	quit, err := t.c.execute(strings.Fields(line))
+	if err != nil {
+		t.addEvent(err.Error())
+	}
+	return quit
+}
+
+// complete finishes the word being typed from the commands, locations,
+// ranks, unit IDs and players that fit at that position.
+func (t *tui) complete() {
+	line := string(t.input)
+	words := strings.Fields(line)
+	partial := ""
+	if len(words) > 0 && !strings.HasSuffix(line, " ") {
+		partial = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	matches := []string{}
+	for _, candidate := range t.candidates(words) {
+		if strings.HasPrefix(candidate, partial) {
+			matches = append(matches, candidate)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		t.hint = "no completions"
+		return
+	case 1:
+		t.hint = ""
+		t.input = append(t.input, []rune(strings.TrimPrefix(matches[0], partial)+" ")...)
+		return
+	}
+
+	prefix := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	t.input = append(t.input, []rune(strings.TrimPrefix(prefix, partial))...)
+	t.hint = strings.Join(matches, "  ")
+}
+
+func (t *tui) candidates(words []string) []string {
+	if len(words) == 0 {
+		return tuiCommands
+	}
+	rules := gamelogic.CurrentRules()
+	locations := []string{}
+	for _, territory := range rules.Map.Territories {
+		locations = append(locations, string(territory.Name))
+	}
+	ranks := []string{}
+	for _, unitType := range rules.Units {
+		ranks = append(ranks, string(unitType.Rank))
+	}
+	unitIDs := []string{}
+	for _, unit := range sortedUnits(t.c.gs) {
+		unitIDs = append(unitIDs, strconv.Itoa(unit.ID))
+	}
+	players := t.c.gs.GetKnownPlayers()
+	relations := []string{
+		string(gamelogic.RelationAlliance),
+		string(gamelogic.RelationTruce),
+		string(gamelogic.RelationPact),
+	}
+
+	pos := len(words)
+	switch words[0] {
+	case "move":
+		if pos == 1 {
+			return locations
+		}
+		return unitIDs
+	case "spawn":
+		if pos == 1 {
+			return locations
+		}
+		if pos == 2 {
+			return ranks
+		}
+	case "retreat":
+		if pos <= 2 {
+			return locations
+		}
+	case "promote":
+		if pos == 1 {
+			return unitIDs
+		}
+	case "propose":
+		if pos == 1 {
+			return players
+		}
+		if pos == 2 {
+			return relations
+		}
+	case "accept", "break", "whisper":
+		if pos == 1 {
+			return players
+		}
+	}
+	return nil
+}
+
+func sortedUnits(gs *gamelogic.GameState) []gamelogic.Unit {
+	units := []gamelogic.Unit{}
+	for _, unit := range gs.GetPlayerSnap().Units {
+		units = append(units, unit)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].ID < units[j].ID
+	})
+	return units
+}
+
+// fit pads or cuts s to exactly width columns.
+func fit(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return s + strings.Repeat(" ", width-len(runes))
+}
+
+func (t *tui) mapPane() []string {
+	gs := t.c.gs
+	mine := map[gamelogic.Location]int{}
+	for _, unit := range gs.GetPlayerSnap().Units {
+		mine[unit.Location]++
+	}
+	theirs := map[gamelogic.Location][]string{}
+	for username, units := range gs.GetSightingsSnap() {
+		counts := map[gamelogic.Location]int{}
+		for _, unit := range units {
+			counts[unit.Location]++
+		}
+		for loc, n := range counts {
+			theirs[loc] = append(theirs[loc], fmt.Sprintf("%s:%d", username, n))
+		}
+	}
+
+	lines := []string{"MAP"}
+	for _, territory := range gamelogic.CurrentRules().Map.Territories {
+		loc := territory.Name
+		if !gs.IsVisible(loc) {
+			lines = append(lines, fmt.Sprintf("  %-12s (fog)", loc))
+			continue
+		}
+		others := theirs[loc]
+		sort.Strings(others)
+		line := fmt.Sprintf("  %-12s you:%d", loc, mine[loc])
+		if len(others) > 0 {
+			line += "  " + strings.Join(others, " ")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (t *tui) unitsPane() []string {
+	units := sortedUnits(t.c.gs)
+	lines := []string{fmt.Sprintf("UNITS (%d)", len(units))}
+	for _, unit := range units {
+		lines = append(lines, fmt.Sprintf("  #%-3d %-10s %-12s HP %3d  XP %d", unit.ID, unit.Rank, unit.Location, unit.Health, unit.Experience))
+	}
+	return lines
+}
+
+func (t *tui) draw() {
+	width, height, err := term.GetSize(t.fd)
+	if err != nil || width < 20 || height < 10 {
+		return
+	}
+
+	rows := []string{}
+	status := fmt.Sprintf(" Peril | %s in %s", t.c.username, t.c.gameID)
+	if t.c.gs.IsPaused() {
+		status += " | PAUSED"
+	}
+	rows = append(rows, "\x1b[7m"+fit(status, width)+"\x1b[0m")
+
+	topHeight := (height - 4) / 2
+	leftWidth := width / 2
+	mapLines := t.mapPane()
+	unitLines := t.unitsPane()
+	for i := 0; i < topHeight; i++ {
+		left, right := "", ""
+		if i < len(mapLines) {
+			left = mapLines[i]
+		}
+		if i < len(unitLines) {
+			right = unitLines[i]
+		}
+		rows = append(rows, fit(left, leftWidth-1)+"│"+fit(right, width-leftWidth))
+	}
+
+	rows = append(rows, fit(strings.Repeat("─", 2)+" EVENTS "+strings.Repeat("─", width), width))
+	eventHeight := height - len(rows) - 2
+	t.mu.Lock()
+	events := t.events
+	if len(events) > eventHeight {
+		events = events[len(events)-eventHeight:]
+	}
+	for i := 0; i < eventHeight; i++ {
+		line := ""
+		if i < len(events) {
+			line = events[i]
+		}
+		rows = append(rows, fit(line, width))
+	}
+	t.mu.Unlock()
+
+	rows = append(rows, "\x1b[2m"+fit(t.hint, width)+"\x1b[0m")
+	prompt := "> " + string(t.input)
+	rows = append(rows, fit(prompt, width))
+
+	var b strings.Builder
+	b.WriteString("\x1b[?25l")
+	for i, row := range rows {
+		fmt.Fprintf(&b, "\x1b[%d;1H%s\x1b[K", i+1, row)
+	}
+	cursor := utf8.RuneCountInString(prompt) + 1
+	if cursor > width {
+		cursor = width
+	}
+	fmt.Fprintf(&b, "\x1b[%d;%dH\x1b[?25h", height, cursor)
+	t.tty.WriteString(b.String())
+}
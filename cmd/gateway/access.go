@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+// binding is a routing key pattern on an exchange. In the key, {game}
+// stands for a game the player joined and {you} for the player; * is any
+// one word. The exchange is a pointer, since the config can rename the
+// exchanges.
+type binding struct {
+	exchange *string
+	key      string
+}
+
+// subscribable are the bindings a browser may subscribe with: what happens
+// in its own games, and what is addressed to it. The * words may also be
+// the * wildcard, but never #.
+var subscribable = []binding{
+	{&routing.ExchangePerilTopic, "lobby.reply.{you}"},
+	{&routing.ExchangePerilDirect, "pause.{game}"},
+	{&routing.ExchangePerilTopic, "presence.{game}.*"},
+	{&routing.ExchangePerilTopic, "army_moves.{game}.*"},
+	{&routing.ExchangePerilTopic, "war.{game}.{you}.*"},
+	{&routing.ExchangePerilTopic, "war_results.{game}.{you}.*"},
+	{&routing.ExchangePerilTopic, "war_allies.{game}.{you}.*"},
+	{&routing.ExchangePerilTopic, "diplomacy.{game}.{you}.*"},
+	{&routing.ExchangePerilTopic, "chat.{game}.all.*"},
+	{&routing.ExchangePerilTopic, "chat.{game}.whisper.{you}.*"},
+	{&routing.ExchangePerilTopic, "chat.{game}.ally.{you}.*"},
+}
+
+// sendable are the keys a browser may publish on, the same ones the Go
+// client publishes on.
+var sendable = []binding{
+	{&routing.ExchangePerilTopic, "lobby.request.{you}"},
+	{&routing.ExchangePerilTopic, "heartbeat.{game}.{you}"},
+	{&routing.ExchangePerilTopic, "army_moves.{game}.{you}"},
+	{&routing.ExchangePerilTopic, "war.{game}.*.{you}"},
+	{&routing.ExchangePerilTopic, "war_results.{game}.*.{you}"},
+	{&routing.ExchangePerilTopic, "war_allies.{game}.*.{you}"},
+	{&routing.ExchangePerilTopic, "diplomacy.{game}.*.{you}"},
+	{&routing.ExchangePerilTopic, "chat.{game}.all.{you}"},
+	{&routing.ExchangePerilTopic, "chat.{game}.whisper.*.{you}"},
+	{&routing.ExchangePerilTopic, "chat.{game}.ally.*.{you}"},
+	{&routing.ExchangePerilTopic, "game_logs.{game}.{you}"},
+}
+
+// match reports whether key fits the pattern for the player. Wildcards are
+// only taken in place of a * word, and only when subscribing.
+func (b binding) match(exchange, key, username string, joined func(string) bool, subscribing bool) bool {
+	if *b.exchange != exchange {
+		return false
+	}
+	want := strings.Split(b.key, ".")
+	words := strings.Split(key, ".")
+	if len(want) != len(words) {
+		return false
+	}
+	for i, word := range words {
+		if word == "" || word == "#" {
+			return false
+		}
+		if word == "*" && (!subscribing || want[i] != "*") {
+			return false
+		}
+		switch want[i] {
+		case "*":
+		case "{you}":
+			if word != username {
+				return false
+			}
+		case "{game}":
+			if !joined(word) {
+				return false
+			}
+		default:
+			if word != want[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// allowed checks a destination against the bindings.
+func allowed(bindings []binding, exchange, key, username string, joined func(string) bool, subscribing bool) error {
+	for _, b := range bindings {
+		if b.match(exchange, key, username, joined, subscribing) {
+			return nil
+		}
+	}
+	if subscribing {
+		return fmt.Errorf("you can't subscribe to %s on %s, only to your own games and messages to you", key, exchange)
+	}
+	return fmt.Errorf("you can't publish on %s on %s, only on your own keys in games you joined", key, exchange)
+}
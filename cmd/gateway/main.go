@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/x6Nenko/peril/internal/config"
+	"github.com/x6Nenko/peril/internal/login"
+)
+
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{"v12.stomp"},
+	// Browser games are usually served from another origin than the gateway
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:15680", "address to accept STOMP over WebSocket connections on")
+	configLoader := config.Register(flag.CommandLine)
+	flag.Parse()
+	cfg, err := configLoader.Load()
+	if err != nil {
+		log.Fatalf("could not load config: %v", err)
+	}
+	cfg.Apply()
+
+	conn, err := cfg.Dial()
+	if err != nil {
+		log.Fatalf("could not connect to RabbitMQ: %v", err)
+	}
+	defer conn.Close()
+	fmt.Println("Peril gateway connected to RabbitMQ!")
+
+	// Browsers can't check the servers themselves, so the gateway must
+	// know the server key up front
+	serverKey, err := login.ServerKey(cfg.ServerKey)
+	if err != nil {
+		log.Fatalf("invalid server key: %v", err)
+	}
+	if serverKey == nil {
+		log.Fatal("the gateway needs the server key, set server_key to the key the servers print")
+	}
+
+	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("could not upgrade connection: %v", err)
+			return
+		}
+		go newSession(conn, ws, serverKey).run()
+	})
+
+	fmt.Printf("Accepting STOMP clients on ws://%s/ws\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
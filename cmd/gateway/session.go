@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/login"
+	"github.com/x6Nenko/peril/internal/pubsub"
+	"github.com/x6Nenko/peril/internal/routing"
+	"github.com/x6Nenko/peril/internal/stomp"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const destinationPrefix = "/exchange/"
+
+// signedPrefixes are the routing keys players publish on. Messages on them
+// must carry a valid signature before they are passed to the browser.
+var signedPrefixes = map[string]struct{}{
+	routing.ArmyMovesPrefix:       {},
+	routing.WarRecognitionsPrefix: {},
+	routing.WarResultsPrefix:      {},
+	routing.WarAlliesPrefix:       {},
+	routing.DiplomacyPrefix:       {},
+	routing.ChatPrefix:            {},
+	routing.GameLogSlug:           {},
+	routing.HeartbeatPrefix:       {},
+}
+
+// serverPrefixes are the routing keys only the servers publish on, signed
+// with the server key. Lobby replies are told apart from requests by
+// serverSigned.
+var serverPrefixes = map[string]struct{}{
+	routing.PauseKey:         {},
+	routing.PresencePrefix:   {},
+	routing.KeysPrefix:       {},
+	routing.BotHandoffPrefix: {},
+}
+
+func serverSigned(key string) bool {
+	if _, ok := serverPrefixes[prefixOf(key)]; ok {
+		return true
+	}
+	return strings.HasPrefix(key, routing.Key(routing.LobbyPrefix, routing.ReplySlug)+".")
+}
+
+// parseDestination splits /exchange/<exchange>/<routing key>.
+func parseDestination(destination string) (string, string, error) {
+	rest, ok := strings.CutPrefix(destination, destinationPrefix)
+	if !ok {
+		return "", "", fmt.Errorf("destination %q must look like %s<exchange>/<routing key>", destination, destinationPrefix)
+	}
+	exchange, key, ok := strings.Cut(rest, "/")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("destination %q has no routing key", destination)
+	}
+	if exchange != routing.ExchangePerilTopic && exchange != routing.ExchangePerilDirect {
+		return "", "", fmt.Errorf("unknown exchange %q", exchange)
+	}
+	return exchange, key, nil
+}
+
+func prefixOf(key string) string {
+	prefix, _, _ := strings.Cut(key, ".")
+	return prefix
+}
+
+func newQueueSuffix() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// session is one browser connected over STOMP. It logs in as a player and
+// publishes and subscribes on the player's behalf.
+type session struct {
+	conn      *amqp.Connection
+	ws        *websocket.Conn
+	serverKey ed25519.PublicKey
+	writeMu   sync.Mutex
+	publishCh *amqp.Channel
+	creds     *login.Credentials
+	done      chan struct{}
+	subs      map[string]*amqp.Channel
+	subsMu    sync.Mutex
+	messages  int
+
+	// games are the games the player joined, learned from the lobby
+	// replies to the requests in lobbyRequests
+	gamesMu       sync.Mutex
+	games         map[string]bool
+	lobbyRequests map[string]routing.LobbyRequest
+}
+
+// maxLobbyRequests bounds the lobby requests waiting for a reply.
+const maxLobbyRequests = 100
+
+func newSession(conn *amqp.Connection, ws *websocket.Conn, serverKey ed25519.PublicKey) *session {
+	return &session{
+		conn:      conn,
+		ws:        ws,
+		serverKey: serverKey,
+		done:      make(chan struct{}),
+		subs:      map[string]*amqp.Channel{},
+
+		games:         map[string]bool{},
+		lobbyRequests: map[string]routing.LobbyRequest{},
+	}
+}
+
+func (s *session) write(frame stomp.Frame) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.ws.WriteMessage(websocket.TextMessage, frame.Bytes())
+}
+
+// fail sends an ERROR frame. The connection is closed afterwards, as STOMP
+// requires.
+func (s *session) fail(err error, cause stomp.Frame) {
+	headers := map[string]string{"message": err.Error()}
+	if receipt, ok := cause.Headers["receipt"]; ok {
+		headers["receipt-id"] = receipt
+	}
+	_ = s.write(stomp.NewFrame(stomp.Error, headers, nil))
+}
+
+func (s *session) close() {
+	close(s.done)
+	s.subsMu.Lock()
+	for id, ch := range s.subs {
+		ch.Close()
+		delete(s.subs, id)
+	}
+	s.subsMu.Unlock()
+	if s.publishCh != nil {
+		s.publishCh.Close()
+	}
+	s.ws.Close()
+}
+
+func (s *session) run() {
+	defer s.close()
+	for {
+		_, data, err := s.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		frames, err := stomp.Parse(data)
+		if err != nil {
+			s.fail(err, stomp.Frame{})
+			return
+		}
+		for _, frame := range frames {
+			if frame.Command == stomp.Disconnect {
+				s.receipt(frame)
+				return
+			}
+			err = s.handle(frame)
+			if err != nil {
+				s.fail(err, frame)
+				return
+			}
+			s.receipt(frame)
+		}
+	}
+}
+
+func (s *session) receipt(frame stomp.Frame) {
+	if receipt, ok := frame.Headers["receipt"]; ok {
+		_ = s.write(stomp.NewFrame(stomp.Receipt, map[string]string{"receipt-id": receipt}, nil))
+	}
+}
+
+func (s *session) handle(frame stomp.Frame) error {
+	if s.creds == nil && frame.Command != stomp.Connect && frame.Command != stomp.Stomp {
+		return errors.New("not connected, send CONNECT first")
+	}
+	switch frame.Command {
+	case stomp.Connect, stomp.Stomp:
+		return s.connect(frame)
+	case stomp.Send:
+		return s.send(frame)
+	case stomp.Subscribe:
+		return s.subscribe(frame)
+	case stomp.Unsubscribe:
+		return s.unsubscribe(frame)
+	case stomp.Ack, stomp.Nack:
+		// Messages are acknowledged as soon as they are passed on
+		return nil
+	default:
+		return fmt.Errorf("unsupported frame %s", frame.Command)
+	}
+}
+
+// connect logs in with the login and passcode headers, registering the
+// username if it is new, like the Go client does.
+func (s *session) connect(frame stomp.Frame) error {
+	if s.creds != nil {
+		return errors.New("already connected")
+	}
+	if versions, ok := frame.Headers["accept-version"]; ok && !strings.Contains(versions, "1.2") {
+		return fmt.Errorf("only STOMP 1.2 is supported, not %s", versions)
+	}
+	username := frame.Headers["login"]
+	if username == "" {
+		return errors.New("the login header is required")
+	}
+
+	publishCh, err := s.conn.Channel()
+	if err != nil {
+		return err
+	}
+	s.publishCh = publishCh
+	creds, err := login.Login(s.conn, publishCh, username, frame.Headers["passcode"], s.serverKey)
+	if err != nil {
+		return fmt.Errorf("could not log in: %v", err)
+	}
+	keysQueue := routing.Key("gateway", routing.KeysPrefix, username, newQueueSuffix())
+	err = creds.FollowKeys(s.conn, keysQueue, pubsub.WithDone(s.done))
+	if err != nil {
+		return err
+	}
+	s.creds = &creds
+	log.Printf("%s connected", username)
+
+	return s.write(stomp.NewFrame(stomp.Connected, map[string]string{
+		"version":    "1.2",
+		"heart-beat": "0,0",
+		"server":     "peril-gateway",
+		"user-name":  username,
+	}, nil))
+}
+
+func (s *session) joined(gameID string) bool {
+	s.gamesMu.Lock()
+	defer s.gamesMu.Unlock()
+	return s.games[gameID]
+}
+
+// trackLobbyRequest remembers a lobby request, so its reply tells which
+// games the player is in.
+func (s *session) trackLobbyRequest(body []byte) error {
+	var req routing.LobbyRequest
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		return fmt.Errorf("invalid lobby request: %v", err)
+	}
+	if req.Username != s.creds.Username {
+		return fmt.Errorf("lobby requests must be made as %s", s.creds.Username)
+	}
+	s.gamesMu.Lock()
+	defer s.gamesMu.Unlock()
+	if len(s.lobbyRequests) >= maxLobbyRequests {
+		return errors.New("too many lobby requests waiting for a reply")
+	}
+	s.lobbyRequests[req.RequestID] = req
+	return nil
+}
+
+// lobbyReply applies a lobby reply the servers signed to the games the
+// player is in.
+func (s *session) lobbyReply(body []byte) {
+	var reply routing.LobbyReply
+	if json.Unmarshal(body, &reply) != nil {
+		return
+	}
+	s.gamesMu.Lock()
+	defer s.gamesMu.Unlock()
+	req, ok := s.lobbyRequests[reply.RequestID]
+	if !ok {
+		return
+	}
+	delete(s.lobbyRequests, reply.RequestID)
+	if !reply.OK {
+		return
+	}
+	switch req.Action {
+	case routing.LobbyCreate, routing.LobbyJoin:
+		s.games[req.GameID] = true
+	case routing.LobbyLeave:
+		delete(s.games, req.GameID)
+	}
+}
+
+// send publishes a JSON message as the player. Only the player's own keys
+// in games they joined are allowed, since those are the only ones
+// receivers will accept the signature on.
+func (s *session) send(frame stomp.Frame) error {
+	exchange, key, err := parseDestination(frame.Headers["destination"])
+	if err != nil {
+		return err
+	}
+	err = allowed(sendable, exchange, key, s.creds.Username, s.joined, false)
+	if err != nil {
+		return err
+	}
+	if prefixOf(key) == routing.LobbyPrefix {
+		err = s.trackLobbyRequest(frame.Body)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Game logs travel as gob, everything else as JSON
+	if prefixOf(key) == routing.GameLogSlug {
+		var gameLog routing.GameLog
+		err = json.Unmarshal(frame.Body, &gameLog)
+		if err != nil {
+			return fmt.Errorf("invalid game log: %v", err)
+		}
+		return pubsub.PublishGob(s.creds.Signer, exchange, key, gameLog)
+	}
+
+	body := frame.Body
+	if prefixOf(key) == routing.HeartbeatPrefix {
+		body, err = s.reportArmy(body)
+		if err != nil {
+			return err
+		}
+	}
+	var ch pubsub.Publisher = s.creds.Signer
+	if prefixOf(key) == routing.WarRecognitionsPrefix {
+		// The recognition expires when the defender stops waiting
+		var rw gamelogic.RecognitionOfWar
+		err = json.Unmarshal(body, &rw)
+		if err != nil || rw.Timeout <= 0 {
+			return errors.New("a war recognition needs a Timeout")
+		}
+		ch = pubsub.Expiring(ch, rw.Timeout)
+	}
+	return ch.PublishWithContext(
+		context.Background(),
+		exchange,
+		key,
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		},
+	)
+}
+
+// reportArmy takes the army out of a heartbeat, which anyone can read, and
+// sends it to the player's server alone, like the Go client does.
+func (s *session) reportArmy(heartbeat []byte) ([]byte, error) {
+	var msg map[string]json.RawMessage
+	err := json.Unmarshal(heartbeat, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("message body must be a JSON object: %v", err)
+	}
+	if _, ok := msg["Units"]; !ok {
+		return heartbeat, nil
+	}
+	var report gamelogic.ArmyReport
+	err = json.Unmarshal(heartbeat, &report)
+	if err != nil {
+		return nil, fmt.Errorf("invalid army: %v", err)
+	}
+	reportQueue := routing.Key(routing.ArmyReportsPrefix, s.creds.ServerID)
+	err = pubsub.PublishJSON(s.creds.Signer, "", reportQueue, report)
+	if err != nil {
+		return nil, err
+	}
+	delete(msg, "Units")
+	return json.Marshal(msg)
+}
+
+func (s *session) subscribe(frame stomp.Frame) error {
+	id := frame.Headers["id"]
+	if id == "" {
+		return errors.New("the id header is required")
+	}
+	exchange, key, err := parseDestination(frame.Headers["destination"])
+	if err != nil {
+		return err
+	}
+	err = allowed(subscribable, exchange, key, s.creds.Username, s.joined, true)
+	if err != nil {
+		return err
+	}
+
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	if _, ok := s.subs[id]; ok {
+		return fmt.Errorf("subscription %s already exists", id)
+	}
+	queueName := routing.Key("gateway", s.creds.Username, id, newQueueSuffix())
+	ch, _, err := pubsub.DeclareAndBind(s.conn, exchange, queueName, key, pubsub.Transient)
+	if err != nil {
+		return err
+	}
+	deliveries, err := ch.Consume(queueName, "", false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+	s.subs[id] = ch
+	go s.forward(id, exchange, deliveries)
+	return nil
+}
+
+func (s *session) unsubscribe(frame stomp.Frame) error {
+	id := frame.Headers["id"]
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	ch, ok := s.subs[id]
+	if !ok {
+		return fmt.Errorf("no subscription %s", id)
+	}
+	delete(s.subs, id)
+	return ch.Close()
+}
+
+// forward passes deliveries to the browser as MESSAGE frames. Player and
+// server messages with a missing or bad signature are dead-lettered instead.
+func (s *session) forward(id, exchange string, deliveries <-chan amqp.Delivery) {
+	for delivery := range deliveries {
+		headers := map[string]string{
+			"subscription": id,
+			"destination":  destinationPrefix + exchange + "/" + delivery.RoutingKey,
+			"content-type": "application/json",
+		}
+		var verifier pubsub.Verifier
+		if _, ok := signedPrefixes[prefixOf(delivery.RoutingKey)]; ok {
+			verifier = s.creds.Players
+		} else if serverSigned(delivery.RoutingKey) {
+			verifier = s.creds.Servers
+		}
+		if verifier != nil {
+			sender, err := verifier.Verify(delivery.RoutingKey, delivery.Headers, delivery.Body)
+			if err != nil {
+				log.Printf("Rejecting message on %s: %v", delivery.RoutingKey, err)
+				delivery.Nack(false, false)
+				continue
+			}
+			headers["peril-sender"] = sender
+			// Attackers pass recognitions on with their results
+			if prefixOf(delivery.RoutingKey) == routing.WarRecognitionsPrefix {
+				timestamp, _ := delivery.Headers[pubsub.HeaderTimestamp].(string)
+				signature, _ := delivery.Headers[pubsub.HeaderSignature].([]byte)
+				headers["peril-timestamp"] = timestamp
+				headers["peril-signature"] = base64.StdEncoding.EncodeToString(signature)
+			}
+			if prefixOf(delivery.RoutingKey) == routing.LobbyPrefix {
+				s.lobbyReply(delivery.Body)
+			}
+		}
+
+		body := delivery.Body
+		if delivery.ContentType == "application/gob" {
+			var err error
+			body, err = gobToJSON(delivery.RoutingKey, body)
+			if err != nil {
+				log.Printf("Discarding malformed message on %s: %v", delivery.RoutingKey, err)
+				delivery.Nack(false, false)
+				continue
+			}
+		}
+
+		s.writeMu.Lock()
+		s.messages++
+		headers["message-id"] = strconv.Itoa(s.messages)
+		s.writeMu.Unlock()
+		err := s.write(stomp.NewFrame(stomp.Message, headers, body))
+		if err != nil {
+			delivery.Nack(false, true)
+			return
+		}
+		delivery.Ack(false)
+	}
+}
+
+func gobToJSON(key string, body []byte) ([]byte, error) {
+	if prefixOf(key) != routing.GameLogSlug {
+		return nil, fmt.Errorf("no JSON form for gob messages on %s", key)
+	}
+	var gameLog routing.GameLog
+	err := gob.NewDecoder(bytes.NewReader(body)).Decode(&gameLog)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(gameLog)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/x6Nenko/peril/internal/login"
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+func TestParseDestination(t *testing.T) {
+	tests := []struct {
+		destination  string
+		wantExchange string
+		wantKey      string
+		wantErr      string
+	}{
+		{"/exchange/peril_topic/army_moves.g1.alice", "peril_topic", "army_moves.g1.alice", ""},
+		{"/exchange/peril_direct/pause.g1", "peril_direct", "pause.g1", ""},
+		// Only the first slash splits, routing keys are taken as they are
+		{"/exchange/peril_topic/a/b", "peril_topic", "a/b", ""},
+		{"/queue/army_moves", "", "", "must look like"},
+		{"exchange/peril_topic/x", "", "", "must look like"},
+		{"/exchange/peril_topic", "", "", "no routing key"},
+		{"/exchange/peril_topic/", "", "", "no routing key"},
+		{"/exchange/amq.topic/x", "", "", "unknown exchange"},
+		{"/exchange/peril_dlx/x", "", "", "unknown exchange"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.destination, func(t *testing.T) {
+			exchange, key, err := parseDestination(tt.destination)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseDestination(%q) = %v, want error containing %q", tt.destination, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDestination(%q): %v", tt.destination, err)
+			}
+			if exchange != tt.wantExchange || key != tt.wantKey {
+				t.Errorf("parseDestination(%q) = %q, %q, want %q, %q", tt.destination, exchange, key, tt.wantExchange, tt.wantKey)
+			}
+		})
+	}
+}
+
+func joinedG1(gameID string) bool {
+	return gameID == "g1"
+}
+
+func TestAllowedSubscriptions(t *testing.T) {
+	tests := []struct {
+		exchange string
+		key      string
+		want     bool
+	}{
+		{"peril_topic", "army_moves.g1.*", true},
+		{"peril_topic", "army_moves.g1.bob", true},
+		{"peril_topic", "lobby.reply.alice", true},
+		{"peril_direct", "pause.g1", true},
+		{"peril_topic", "war.g1.alice.*", true},
+		{"peril_topic", "chat.g1.whisper.alice.*", true},
+		{"peril_topic", "presence.g1.*", true},
+		// Games the player didn't join
+		{"peril_topic", "army_moves.g2.*", false},
+		{"peril_topic", "army_moves.*.*", false},
+		{"peril_direct", "pause.g2", false},
+		// Messages to other players
+		{"peril_topic", "lobby.reply.bob", false},
+		{"peril_topic", "lobby.reply.*", false},
+		{"peril_topic", "war.g1.bob.*", false},
+		{"peril_topic", "war_results.g1.*.*", false},
+		{"peril_topic", "chat.g1.whisper.bob.*", false},
+		// Keys only the servers may read
+		{"peril_topic", "#", false},
+		{"peril_topic", "army_moves.g1.#", false},
+		{"peril_topic", "heartbeat.g1.*", false},
+		{"peril_topic", "game_logs.g1.*", false},
+		{"peril_topic", "lobby.request.alice", false},
+		{"peril_topic", "keys.*", false},
+		{"peril_topic", "auth.hello", false},
+		// The wrong exchange
+		{"peril_topic", "pause.g1", false},
+		{"peril_direct", "army_moves.g1.*", false},
+	}
+	for _, tt := range tests {
+		err := allowed(subscribable, tt.exchange, tt.key, "alice", joinedG1, true)
+		if got := err == nil; got != tt.want {
+			t.Errorf("subscribe to %s on %s allowed = %v, want %v", tt.key, tt.exchange, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedSends(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"lobby.request.alice", true},
+		{"heartbeat.g1.alice", true},
+		{"army_moves.g1.alice", true},
+		{"war.g1.bob.alice", true},
+		{"war_results.g1.bob.alice", true},
+		{"chat.g1.all.alice", true},
+		{"chat.g1.whisper.bob.alice", true},
+		{"game_logs.g1.alice", true},
+		{"lobby.request.bob", false},
+		{"army_moves.g1.bob", false},
+		{"army_moves.g2.alice", false},
+		{"war.g1.*.alice", false},
+		{"presence.g1.alice", false},
+		{"keys.alice", false},
+		{"army_moves.g1.alice.alice", false},
+	}
+	for _, tt := range tests {
+		err := allowed(sendable, "peril_topic", tt.key, "alice", joinedG1, false)
+		if got := err == nil; got != tt.want {
+			t.Errorf("send on %s allowed = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestLobbyRepliesTrackGames(t *testing.T) {
+	s := newSession(nil, nil, nil)
+	s.creds = &login.Credentials{Username: "alice"}
+
+	request := func(id string, action routing.LobbyAction, gameID string) {
+		t.Helper()
+		body, _ := json.Marshal(routing.LobbyRequest{RequestID: id, Action: action, GameID: gameID, Username: "alice"})
+		if err := s.trackLobbyRequest(body); err != nil {
+			t.Fatalf("trackLobbyRequest(%s): %v", id, err)
+		}
+	}
+	reply := func(id string, ok bool) {
+		body, _ := json.Marshal(routing.LobbyReply{RequestID: id, OK: ok})
+		s.lobbyReply(body)
+	}
+
+	request("1", routing.LobbyJoin, "g1")
+	if s.joined("g1") {
+		t.Fatalf("joined g1 before the server answered")
+	}
+	reply("1", true)
+	if !s.joined("g1") {
+		t.Fatalf("not in g1 after joining it")
+	}
+
+	request("2", routing.LobbyJoin, "g2")
+	reply("2", false)
+	if s.joined("g2") {
+		t.Errorf("in g2 after the server refused the join")
+	}
+	// A reply to a request never made changes nothing
+	reply("3", true)
+
+	request("4", routing.LobbyLeave, "g1")
+	reply("4", true)
+	if s.joined("g1") {
+		t.Errorf("still in g1 after leaving it")
+	}
+
+	body, _ := json.Marshal(routing.LobbyRequest{RequestID: "5", Action: routing.LobbyJoin, GameID: "g1", Username: "bob"})
+	if err := s.trackLobbyRequest(body); err == nil {
+		t.Errorf("trackLobbyRequest as bob = nil, want error")
+	}
+}
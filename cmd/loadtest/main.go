@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/config"
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/pubsub"
+	"github.com/x6Nenko/peril/internal/ratelimit"
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+const (
+	kindMoves = "moves"
+	kindLogs  = "game logs"
+)
+
+// player is a simulated client. It publishes signed messages like a real
+// one, but picks its commands at random.
+type player struct {
+	name   string
+	gs     *gamelogic.GameState
+	signer *pubsub.Signer
+	rng    *mathrand.Rand
+}
+
+// rateTicker ticks rate times a second, or never if rate isn't positive.
+func rateTicker(rate float64) (<-chan time.Time, func()) {
+	if rate <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	return t.C, t.Stop
+}
+
+// sentAt reads the send time the signer put on a message.
+func sentAt(m message) (time.Time, bool) {
+	timestamp, _ := m.headers[pubsub.HeaderTimestamp].(string)
+	nanos, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+func (p *player) spawn() error {
+	rules := gamelogic.CurrentRules()
+	territory := rules.Map.Territories[p.rng.Intn(len(rules.Map.Territories))]
+	unitType := rules.Units[p.rng.Intn(len(rules.Units))]
+	return p.gs.CommandSpawn([]string{"spawn", string(territory.Name), string(unitType.Rank)})
+}
+
+// move sends a random unit to a random neighbouring territory, spawning
+// one first if the player has none.
+func (p *player) move(gameID string) error {
+	units := p.gs.GetPlayerSnap().Units
+	if len(units) == 0 {
+		err := p.spawn()
+		if err != nil {
+			return err
+		}
+		units = p.gs.GetPlayerSnap().Units
+	}
+	var unit gamelogic.Unit
+	pick := p.rng.Intn(len(units))
+	for _, u := range units {
+		if pick == 0 {
+			unit = u
+			break
+		}
+		pick--
+	}
+	adjacent := []gamelogic.Location{}
+	for _, territory := range gamelogic.CurrentRules().Map.Territories {
+		if territory.Name == unit.Location {
+			adjacent = territory.Adjacent
+		}
+	}
+	if len(adjacent) == 0 {
+		return fmt.Errorf("%s has no neighbours", unit.Location)
+	}
+	to := adjacent[p.rng.Intn(len(adjacent))]
+	move, err := p.gs.CommandMove([]string{"move", string(to), strconv.Itoa(unit.ID)})
+	if err != nil {
+		return err
+	}
+	moveKey := routing.Key(routing.ArmyMovesPrefix, gameID, p.name)
+	return pubsub.PublishJSON(p.signer, loadTestExchange, moveKey, move)
+}
+
+func (p *player) publishLog(gameID string) error {
+	gameLog := routing.GameLog{
+		CurrentTime: time.Now(),
+		Message:     gamelogic.GetMaliciousLog(),
+		Username:    p.name,
+		GameID:      gameID,
+	}
+	logKey := routing.Key(routing.GameLogSlug, gameID, p.name)
+	return pubsub.PublishGob(p.signer, loadTestExchange, logKey, gameLog)
+}
+
+// handlerMove treats a move the way the client does, timing its delivery.
+func handlerMove(p *player, keys *pubsub.KeyRing, st *stats) func(message) pubsub.AckType {
+	return func(m message) pubsub.AckType {
+		sender, err := keys.Verify(m.key, m.headers, m.body)
+		sent, ok := sentAt(m)
+		var move gamelogic.ArmyMove
+		if err != nil || !ok || json.Unmarshal(m.body, &move) != nil || move.Username != sender {
+			st.rejected(kindMoves)
+			return pubsub.NackDiscard
+		}
+		ack := pubsub.Ack
+		if p.gs.HandleMove(move) == gamelogic.MoveOutcomeSamePlayer {
+			ack = pubsub.NackDiscard
+		}
+		st.delivered(kindMoves, time.Since(sent), ack)
+		return ack
+	}
+}
+
+// handlerLogs rate limits game logs the way the server does, without
+// writing them anywhere.
+func handlerLogs(keys *pubsub.KeyRing, limiter *ratelimit.Limiter, st *stats) func(message) pubsub.AckType {
+	return func(m message) pubsub.AckType {
+		sender, err := keys.Verify(m.key, m.headers, m.body)
+		sent, ok := sentAt(m)
+		var gameLog routing.GameLog
+		if err != nil || !ok || gob.NewDecoder(bytes.NewReader(m.body)).Decode(&gameLog) != nil || gameLog.Username != sender {
+			st.rejected(kindLogs)
+			return pubsub.NackDiscard
+		}
+		ack := pubsub.Ack
+		if !limiter.Allow(gameLog.Username) {
+			ack = pubsub.NackDiscard
+		}
+		st.delivered(kindLogs, time.Since(sent), ack)
+		return ack
+	}
+}
+
+func main() {
+	clients := flag.Int("clients", 10, "number of simulated clients")
+	duration := flag.Duration("duration", 30*time.Second, "how long the clients publish")
+	drain := flag.Duration("drain", 2*time.Second, "how long to wait for messages in flight afterwards")
+	moveRate := flag.Float64("move-rate", 1, "moves per second per client")
+	spawnRate := flag.Float64("spawn-rate", 0.5, "spawns per second per client")
+	logRate := flag.Float64("log-rate", 5, "game logs per second per client")
+	logBurst := flag.Int("log-burst", 20, "game logs a client may send at once before being rate limited, as on the server")
+	logInterval := flag.Duration("log-interval", time.Second, "time to earn back one game log, as on the server")
+	brokerName := flag.String("broker", "amqp", "amqp for the configured broker, or memory for an in-process one")
+	seed := flag.Int64("seed", 1, "seed for the clients' random choices, for reproducible runs")
+	configLoader := config.Register(flag.CommandLine)
+	flag.Parse()
+	cfg, err := configLoader.Load()
+	if err != nil {
+		log.Fatalf("could not load config: %v", err)
+	}
+	cfg.Apply()
+	if *clients < 1 {
+		log.Fatalf("-clients must be positive")
+	}
+
+	var t transport
+	switch *brokerName {
+	case "amqp":
+		conn, err := cfg.Dial()
+		if err != nil {
+			log.Fatalf("could not connect to RabbitMQ: %v", err)
+		}
+		t, err = newAMQPTransport(conn)
+		if err != nil {
+			log.Fatalf("could not declare the load test exchange: %v", err)
+		}
+	case "memory":
+		t = &memoryTransport{}
+	default:
+		log.Fatalf("unknown broker %q", *brokerName)
+	}
+	defer t.close()
+
+	// A game of its own keeps the runs apart
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	gameID := "loadtest-" + hex.EncodeToString(b)
+
+	// The game narrates every move for humans, which would drown the report
+	report := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		log.Fatalf("could not open %s: %v", os.DevNull, err)
+	}
+	os.Stdout = devNull
+
+	st := newStats(kindMoves, kindLogs)
+	keys := pubsub.NewKeyRing()
+	players := []*player{}
+	for i := 0; i < *clients; i++ {
+		name := fmt.Sprintf("load%d", i+1)
+		publicKey, privateKey, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			log.Fatalf("could not generate a key: %v", err)
+		}
+		err = keys.Add(name, publicKey)
+		if err != nil {
+			log.Fatalf("could not add key: %v", err)
+		}
+		publishCh, err := t.publisher()
+		if err != nil {
+			log.Fatalf("could not create publish channel: %v", err)
+		}
+		gs := gamelogic.NewGameState(name)
+		gs.JoinGame(gameID, false)
+		players = append(players, &player{
+			name:   name,
+			gs:     gs,
+			signer: pubsub.NewSigner(publishCh, name, privateKey),
+			rng:    mathrand.New(mathrand.NewSource(*seed + int64(i))),
+		})
+	}
+
+	for _, p := range players {
+		err = t.consume(
+			routing.Key(routing.ArmyMovesPrefix, gameID, p.name),
+			routing.Key(routing.ArmyMovesPrefix, gameID, "*"),
+			handlerMove(p, keys, st),
+		)
+		if err != nil {
+			log.Fatalf("could not subscribe to army moves: %v", err)
+		}
+	}
+	err = t.consume(
+		routing.Key(routing.GameLogSlug, gameID),
+		routing.Key(routing.GameLogSlug, gameID, "*"),
+		handlerLogs(keys, ratelimit.NewLimiter(*logBurst, *logInterval), st),
+	)
+	if err != nil {
+		log.Fatalf("could not subscribe to game logs: %v", err)
+	}
+
+	log.Printf("Running %d clients against the %s broker for %s in game %s...", *clients, *brokerName, *duration, gameID)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, p := range players {
+		wg.Add(1)
+		go func(p *player) {
+			defer wg.Done()
+			spawns, stopSpawns := rateTicker(*spawnRate)
+			defer stopSpawns()
+			moves, stopMoves := rateTicker(*moveRate)
+			defer stopMoves()
+			logs, stopLogs := rateTicker(*logRate)
+			defer stopLogs()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-spawns:
+					// Spawns stay on the client, they only feed the moves
+					_ = p.spawn()
+				case <-moves:
+					st.published(kindMoves, p.move(gameID))
+				case <-logs:
+					st.published(kindLogs, p.publishLog(gameID))
+				}
+			}
+		}(p)
+	}
+
+	start := time.Now()
+	time.Sleep(*duration)
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+	time.Sleep(*drain)
+	st.report(report, elapsed)
+}
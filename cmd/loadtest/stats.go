@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/pubsub"
+)
+
+// kindStats is what the load test measured for one kind of message.
+type kindStats struct {
+	published int
+	failed    int
+	// rejected counts deliveries that failed verification or decoding
+	rejected  int
+	acks      map[pubsub.AckType]int
+	latencies []time.Duration
+}
+
+type stats struct {
+	mu    sync.Mutex
+	kinds map[string]*kindStats
+	// order keeps the report in a stable order
+	order []string
+}
+
+func newStats(kinds ...string) *stats {
+	s := &stats{kinds: map[string]*kindStats{}, order: kinds}
+	for _, kind := range kinds {
+		s.kinds[kind] = &kindStats{acks: map[pubsub.AckType]int{}}
+	}
+	return s
+}
+
+func (s *stats) published(kind string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.kinds[kind].failed++
+		return
+	}
+	s.kinds[kind].published++
+}
+
+func (s *stats) rejected(kind string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kinds[kind].rejected++
+}
+
+func (s *stats) delivered(kind string, latency time.Duration, ack pubsub.AckType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.kinds[kind]
+	k.acks[ack]++
+	k.latencies = append(k.latencies, latency)
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
+
+func ratio(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+func (s *stats) report(w io.Writer, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "Ran for %s\n", elapsed.Round(time.Millisecond))
+	for _, kind := range s.order {
+		k := s.kinds[kind]
+		delivered := len(k.latencies)
+		fmt.Fprintf(w, "\n%s\n", kind)
+		fmt.Fprintf(w, "  published  %d (%.1f/s), %d failed\n", k.published, float64(k.published)/elapsed.Seconds(), k.failed)
+		fmt.Fprintf(w, "  delivered  %d (%.1f/s), %d rejected\n", delivered, float64(delivered)/elapsed.Seconds(), k.rejected)
+		fmt.Fprintf(w, "  acked      %d (%s)\n", k.acks[pubsub.Ack], ratio(k.acks[pubsub.Ack], delivered))
+		fmt.Fprintf(w, "  requeued   %d (%s)\n", k.acks[pubsub.NackRequeue], ratio(k.acks[pubsub.NackRequeue], delivered))
+		fmt.Fprintf(w, "  discarded  %d (%s)\n", k.acks[pubsub.NackDiscard], ratio(k.acks[pubsub.NackDiscard], delivered))
+		if delivered == 0 {
+			continue
+		}
+		sorted := append([]time.Duration{}, k.latencies...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i] < sorted[j]
+		})
+		fmt.Fprintf(w, "  latency    p50 %s  p90 %s  p99 %s  max %s\n",
+			percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99), sorted[len(sorted)-1])
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/x6Nenko/peril/internal/pubsub"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// message is a delivery as the load test sees it, from either broker.
+type message struct {
+	key     string
+	headers amqp.Table
+	body    []byte
+}
+
+// transport is the broker under test.
+type transport interface {
+	// publisher returns a publisher for one simulated client
+	publisher() (pubsub.Publisher, error)
+	// consume delivers the messages matching pattern to handle on a new
+	// transient queue. handle's result acks or nacks them.
+	consume(queue, pattern string, handle func(message) pubsub.AckType) error
+	close() error
+}
+
+// loadTestExchange is the topic exchange the load test publishes on. The
+// servers don't know the simulated players' keys, so their messages must
+// never reach the game's exchanges, where the servers would dead-letter
+// every one of them.
+const loadTestExchange = "peril_loadtest"
+
+// amqpTransport runs against a real broker.
+type amqpTransport struct {
+	conn *amqp.Connection
+}
+
+// newAMQPTransport declares the load test's exchange, which goes away with
+// the last load test queue.
+func newAMQPTransport(conn *amqp.Connection) (*amqpTransport, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+	err = ch.ExchangeDeclare(
+		loadTestExchange,
+		"topic",
+		false, // durable
+		true,  // autoDelete
+		false, // internal
+		false, // noWait
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &amqpTransport{conn: conn}, nil
+}
+
+func (t *amqpTransport) publisher() (pubsub.Publisher, error) {
+	return t.conn.Channel()
+}
+
+// consume declares its queue itself, without the game's dead letter
+// exchange: discarded load test messages are just dropped.
+func (t *amqpTransport) consume(queue, pattern string, handle func(message) pubsub.AckType) error {
+	ch, err := t.conn.Channel()
+	if err != nil {
+		return err
+	}
+	_, err = ch.QueueDeclare(
+		queue,
+		false, // durable
+		true,  // autoDelete
+		true,  // exclusive
+		false, // noWait
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	err = ch.QueueBind(queue, pattern, loadTestExchange, false, nil)
+	if err != nil {
+		return err
+	}
+	deliveries, err := ch.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for d := range deliveries {
+			switch handle(message{key: d.RoutingKey, headers: d.Headers, body: d.Body}) {
+			case pubsub.Ack:
+				d.Ack(false)
+			case pubsub.NackRequeue:
+				d.Nack(false, true)
+			case pubsub.NackDiscard:
+				d.Nack(false, false)
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *amqpTransport) close() error {
+	return t.conn.Close()
+}
+
+// memoryQueueSize bounds each in-process queue; publishers block when a
+// consumer falls this far behind.
+const memoryQueueSize = 10000
+
+// memoryTransport is an in-process topic exchange, to measure the game
+// code without a broker in the way.
+type memoryTransport struct {
+	mu       sync.RWMutex
+	bindings []memoryBinding
+}
+
+type memoryBinding struct {
+	pattern []string
+	queue   chan message
+}
+
+func (t *memoryTransport) publisher() (pubsub.Publisher, error) {
+	return t, nil
+}
+
+func (t *memoryTransport) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	m := message{key: key, headers: msg.Headers, body: msg.Body}
+	words := strings.Split(key, ".")
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, b := range t.bindings {
+		if !topicMatch(b.pattern, words) {
+			continue
+		}
+		select {
+		case b.queue <- m:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (t *memoryTransport) consume(queue, pattern string, handle func(message) pubsub.AckType) error {
+	b := memoryBinding{
+		pattern: strings.Split(pattern, "."),
+		queue:   make(chan message, memoryQueueSize),
+	}
+	t.mu.Lock()
+	t.bindings = append(t.bindings, b)
+	t.mu.Unlock()
+	go func() {
+		for m := range b.queue {
+			if handle(m) == pubsub.NackRequeue {
+				// Back of the queue, unless it is full
+				select {
+				case b.queue <- m:
+				default:
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *memoryTransport) close() error {
+	return nil
+}
+
+// topicMatch matches a routing key against a binding pattern the way a
+// topic exchange does: * is exactly one word, # is zero or more.
+func topicMatch(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatch(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatch(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatch(pattern[1:], words[1:])
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTopicMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"army_moves.g1.alice", "army_moves.g1.alice", true},
+		{"army_moves.g1.alice", "army_moves.g1.bob", false},
+		{"army_moves.g1.*", "army_moves.g1.bob", true},
+		{"army_moves.g1.*", "army_moves.g1", false},
+		{"army_moves.g1.*", "army_moves.g1.bob.extra", false},
+		{"army_moves.*.bob", "army_moves.g1.bob", true},
+		{"#", "army_moves.g1.bob", true},
+		{"#", "", true},
+		{"army_moves.#", "army_moves", true},
+		{"army_moves.#", "army_moves.g1.bob", true},
+		{"army_moves.#", "game_logs.g1.bob", false},
+		{"#.bob", "army_moves.g1.bob", true},
+		{"#.bob", "army_moves.g1.alice", false},
+		{"army_moves.#.bob", "army_moves.bob", true},
+		{"*.#", "army_moves", true},
+		{"*.#", "", false},
+		{"game_logs.g1.*", "game_logs.g2.alice", false},
+	}
+	for _, tt := range tests {
+		pattern := strings.Split(tt.pattern, ".")
+		words := strings.Split(tt.key, ".")
+		if tt.key == "" {
+			words = nil
+		}
+		if got := topicMatch(pattern, words); got != tt.want {
+			t.Errorf("topicMatch(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
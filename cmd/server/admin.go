@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/lobby"
+	"github.com/x6Nenko/peril/internal/logstore"
+	"github.com/x6Nenko/peril/internal/presence"
+	"github.com/x6Nenko/peril/internal/pubsub"
+	"github.com/x6Nenko/peril/internal/ratelimit"
+	"github.com/x6Nenko/peril/internal/routing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const defaultTailLines = 50
+
+// adminServer is the HTTP/JSON counterpart of the server REPL, for servers
+// running in the background.
+type adminServer struct {
+	token    string
+	conn     *amqp.Connection
+	signer   pubsub.Publisher
+	registry *lobby.Registry
+	table    *presence.Table
+	muter    *ratelimit.Muter
+	logs     logstore.Store
+	wars     *logstore.WarLog
+}
+
+type queueDepth struct {
+	Name      string
+	Messages  int
+	Consumers int
+	Error     string
+}
+
+func (as *adminServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /games", as.handleGames)
+	mux.HandleFunc("POST /pause", as.handlePause(true))
+	mux.HandleFunc("POST /resume", as.handlePause(false))
+	mux.HandleFunc("POST /games/{game}/pause", as.handlePause(true))
+	mux.HandleFunc("POST /games/{game}/resume", as.handlePause(false))
+	mux.HandleFunc("GET /players", as.handlePlayers)
+	mux.HandleFunc("GET /queues", as.handleQueues)
+	mux.HandleFunc("GET /logs", as.handleLogs)
+	mux.HandleFunc("GET /stats", as.handleStats)
+	mux.HandleFunc("GET /mutes", as.handleMutes)
+	mux.HandleFunc("DELETE /mutes/{username}", as.handleUnmute)
+	return as.authenticate(mux)
+}
+
+// authenticate requires an "Authorization: Bearer <token>" header.
+func (as *adminServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !validToken(token, as.token) {
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validToken compares in constant time, so the time a request takes
+// doesn't give away how much of the token it got right.
+func validToken(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, val any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(val)
+	if err != nil {
+		log.Printf("could not write admin response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"Error": msg})
+}
+
+func (as *adminServer) handleGames(w http.ResponseWriter, r *http.Request) {
+	games, err := as.registry.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, games)
+}
+
+// handlePause pauses or resumes the game in the path, or every game.
+func (as *adminServer) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameIDs := []string{}
+		if gameID := r.PathValue("game"); gameID != "" {
+			if _, err := as.registry.Get(gameID); err != nil {
+				writeError(w, http.StatusNotFound, "no such game: "+gameID)
+				return
+			}
+			gameIDs = append(gameIDs, gameID)
+		} else {
+			var err error
+			gameIDs, err = targetGames(as.registry, nil)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+
+		for _, gameID := range gameIDs {
+			err := publishPause(as.signer, as.registry, gameID, paused)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"Games": gameIDs, "Paused": paused})
+	}
+}
+
+func (as *adminServer) handlePlayers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, as.table.List())
+}
+
+// handleQueues reports the depth of the shared durable queues, including
+// the bot handoff queue of every game. Those only exist once a player of
+// the game was handed to a bot. Players' own queues, wars included, are
+// exclusive to their connections and can't be inspected.
+func (as *adminServer) handleQueues(w http.ResponseWriter, r *http.Request) {
+	names := []string{routing.GameLogSlug, routing.WarResultsQueue, routing.ChatArchiveQueue, routing.LobbyQueue}
+	games, err := as.registry.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, game := range games {
+		names = append(names, routing.Key(routing.BotHandoffQueue, game.ID))
+	}
+	depths := []queueDepth{}
+	for _, name := range names {
+		depths = append(depths, inspectQueue(as.conn, name))
+	}
+	writeJSON(w, http.StatusOK, depths)
+}
+
+// inspectQueue uses a channel of its own, since a failed passive declare
+// closes the channel.
+func inspectQueue(conn *amqp.Connection, name string) queueDepth {
+	depth := queueDepth{Name: name}
+	ch, err := conn.Channel()
+	if err != nil {
+		depth.Error = err.Error()
+		return depth
+	}
+	defer ch.Close()
+
+	queue, err := ch.QueueDeclarePassive(name, true, false, false, false, nil)
+	if err != nil {
+		depth.Error = err.Error()
+		return depth
+	}
+	depth.Messages = queue.Messages
+	depth.Consumers = queue.Consumers
+	return depth
+}
+
+// handleLogs returns the newest n game logs, optionally of one game or
+// user, containing some text, and within a time range.
+func (as *adminServer) handleLogs(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := logstore.Query{
+		GameID:   params.Get("game"),
+		Username: params.Get("user"),
+		Contains: params.Get("grep"),
+		Limit:    defaultTailLines,
+	}
+	if s := params.Get("n"); s != "" {
+		n, err := parseCount(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "n: "+err.Error())
+			return
+		}
+		q.Limit = n
+	}
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if s := params.Get(name); s != "" {
+			var err error
+			*t, err = parseTime(s)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, name+": "+err.Error())
+				return
+			}
+		}
+	}
+
+	logs, err := as.logs.Query(q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	lines := []string{}
+	for _, gl := range logs {
+		lines = append(lines, logstore.Format(gl))
+	}
+	writeJSON(w, http.StatusOK, lines)
+}
+
+// handleStats returns the wars won, lost and drawn per player, optionally
+// in one game.
+func (as *adminServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := as.wars.Stats(r.URL.Query().Get("game"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (as *adminServer) handleMutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, as.muter.List(time.Now()))
+}
+
+func (as *adminServer) handleUnmute(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	if !as.muter.Unmute(username) {
+		writeError(w, http.StatusNotFound, username+" is not muted")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"Unmuted": username})
+}
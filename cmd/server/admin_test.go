@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/logstore"
+	"github.com/x6Nenko/peril/internal/presence"
+	"github.com/x6Nenko/peril/internal/ratelimit"
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+const testAdminToken = "secret"
+
+// newTestAdmin serves game g1 with alice and bob in it. The queues need a
+// broker, so it has no connection.
+func newTestAdmin(t *testing.T) (*adminServer, *recorder) {
+	t.Helper()
+	ch := &recorder{}
+	return &adminServer{
+		token:    testAdminToken,
+		signer:   ch,
+		registry: newTestGame(t, []string{"alice", "bob"}).registry,
+		table:    presence.NewTable(time.Minute),
+		muter:    ratelimit.NewMuter(1, time.Hour, time.Hour),
+		logs:     &logStore{},
+		wars:     openTestWars(t),
+	}, ch
+}
+
+// request sends an admin request with the token and decodes the response
+// into v, if given.
+func request(t *testing.T, as *adminServer, method, path, auth string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	res := httptest.NewRecorder()
+	as.handler().ServeHTTP(res, req)
+	if v != nil && res.Code == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: could not decode response: %v", method, path, err)
+		}
+	}
+	return res.Code
+}
+
+func TestAdminToken(t *testing.T) {
+	as, _ := newTestAdmin(t)
+	tests := []struct {
+		name string
+		auth string
+		want int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong", "Bearer guess", http.StatusUnauthorized},
+		{"prefix of the token", "Bearer secre", http.StatusUnauthorized},
+		{"token with more", "Bearer secrets", http.StatusUnauthorized},
+		{"not a bearer", testAdminToken, http.StatusUnauthorized},
+		{"empty bearer", "Bearer ", http.StatusUnauthorized},
+		{"right", "Bearer " + testAdminToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/games", "/mutes"} {
+				if got := request(t, as, http.MethodGet, path, tt.auth, nil); got != tt.want {
+					t.Errorf("GET %s = %d, want %d", path, got, tt.want)
+				}
+			}
+		})
+	}
+
+	// A request that would change something is refused before it does
+	if got := request(t, as, http.MethodPost, "/pause", "Bearer guess", nil); got != http.StatusUnauthorized {
+		t.Fatalf("POST /pause = %d, want %d", got, http.StatusUnauthorized)
+	}
+	game, err := as.registry.Get("g1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if game.Paused {
+		t.Errorf("game paused without the token")
+	}
+}
+
+func TestAdminBadRequests(t *testing.T) {
+	as, _ := newTestAdmin(t)
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/logs?n=0", http.StatusBadRequest},
+		{http.MethodGet, "/logs?n=-5", http.StatusBadRequest},
+		{http.MethodGet, "/logs?n=ten", http.StatusBadRequest},
+		{http.MethodGet, "/logs?n=10001", http.StatusBadRequest},
+		{http.MethodGet, "/logs?since=-1h", http.StatusBadRequest},
+		{http.MethodGet, "/logs?since=yesterday", http.StatusBadRequest},
+		{http.MethodGet, "/logs?until=2024-13-01T00:00:00Z", http.StatusBadRequest},
+		{http.MethodPost, "/games/nope/pause", http.StatusNotFound},
+		{http.MethodDelete, "/mutes/alice", http.StatusNotFound},
+		{http.MethodGet, "/pause", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		if got := request(t, as, tt.method, tt.path, "Bearer "+testAdminToken, nil); got != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAdminGames(t *testing.T) {
+	as, _ := newTestAdmin(t)
+	var games []routing.GameInfo
+	if got := request(t, as, http.MethodGet, "/games", "Bearer "+testAdminToken, &games); got != http.StatusOK {
+		t.Fatalf("GET /games = %d, want %d", got, http.StatusOK)
+	}
+	if len(games) != 1 || games[0].ID != "g1" || !reflect.DeepEqual(games[0].Players, []string{"alice", "bob"}) {
+		t.Errorf("GET /games = %+v, want g1 with alice and bob", games)
+	}
+}
+
+func TestAdminPause(t *testing.T) {
+	as, ch := newTestAdmin(t)
+	if err := as.registry.Create("g2"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	steps := []struct {
+		path      string
+		wantGames []string
+		paused    bool
+	}{
+		{"/games/g1/pause", []string{"g1"}, true},
+		{"/pause", []string{"g1", "g2"}, true},
+		{"/games/g2/resume", []string{"g2"}, false},
+		{"/resume", []string{"g1", "g2"}, false},
+	}
+	for _, step := range steps {
+		ch.keys, ch.msgs = nil, nil
+		var reply struct {
+			Games  []string
+			Paused bool
+		}
+		if got := request(t, as, http.MethodPost, step.path, "Bearer "+testAdminToken, &reply); got != http.StatusOK {
+			t.Fatalf("POST %s = %d, want %d", step.path, got, http.StatusOK)
+		}
+		if !reflect.DeepEqual(reply.Games, step.wantGames) || reply.Paused != step.paused {
+			t.Errorf("POST %s replied %+v, want games %v paused %v", step.path, reply, step.wantGames, step.paused)
+		}
+
+		wantKeys := []string{}
+		for _, gameID := range step.wantGames {
+			wantKeys = append(wantKeys, routing.Key(routing.PauseKey, gameID))
+			game, err := as.registry.Get(gameID)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if game.Paused != step.paused {
+				t.Errorf("after POST %s, %s paused = %v, want %v", step.path, gameID, game.Paused, step.paused)
+			}
+		}
+		if !reflect.DeepEqual(ch.keys, wantKeys) {
+			t.Errorf("POST %s published on %v, want %v", step.path, ch.keys, wantKeys)
+		}
+		for _, msg := range ch.msgs {
+			var state routing.PlayingState
+			if err := json.Unmarshal(msg.Body, &state); err != nil {
+				t.Fatalf("could not decode playing state: %v", err)
+			}
+			if state.IsPaused != step.paused {
+				t.Errorf("POST %s published IsPaused = %v, want %v", step.path, state.IsPaused, step.paused)
+			}
+		}
+	}
+}
+
+func TestAdminPlayers(t *testing.T) {
+	as, _ := newTestAdmin(t)
+	as.table.Seen(gamelogic.Heartbeat{CurrentTime: time.Now(), GameID: "g1", Username: "alice"}, time.Now())
+
+	var players []presence.Entry
+	if got := request(t, as, http.MethodGet, "/players", "Bearer "+testAdminToken, &players); got != http.StatusOK {
+		t.Fatalf("GET /players = %d, want %d", got, http.StatusOK)
+	}
+	if len(players) != 1 || players[0].GameID != "g1" || players[0].Username != "alice" {
+		t.Errorf("GET /players = %+v, want alice in g1", players)
+	}
+}
+
+func TestAdminLogs(t *testing.T) {
+	as, _ := newTestAdmin(t)
+	store := as.logs.(*logStore)
+	gl := routing.GameLog{CurrentTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), GameID: "g1", Username: "alice", Message: "hello"}
+	store.logs = []routing.GameLog{gl}
+
+	var lines []string
+	path := "/logs?game=g1&user=alice&grep=hell&n=5&since=1h&until=2024-01-02T00:00:00Z"
+	before := time.Now()
+	if got := request(t, as, http.MethodGet, path, "Bearer "+testAdminToken, &lines); got != http.StatusOK {
+		t.Fatalf("GET /logs = %d, want %d", got, http.StatusOK)
+	}
+	if want := []string{logstore.Format(gl)}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("GET /logs = %q, want %q", lines, want)
+	}
+
+	q := store.query
+	if q.GameID != "g1" || q.Username != "alice" || q.Contains != "hell" || q.Limit != 5 {
+		t.Errorf("query = %+v, want g1, alice, hell and 5", q)
+	}
+	if since := before.Add(-time.Hour); q.Since.Before(since.Add(-time.Second)) || q.Since.After(since.Add(time.Second)) {
+		t.Errorf("query since %v, want about %v", q.Since, since)
+	}
+	if want := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC); !q.Until.Equal(want) {
+		t.Errorf("query until %v, want %v", q.Until, want)
+	}
+
+	// Without parameters it asks for the newest lines of every game
+	if got := request(t, as, http.MethodGet, "/logs", "Bearer "+testAdminToken, &lines); got != http.StatusOK {
+		t.Fatalf("GET /logs = %d, want %d", got, http.StatusOK)
+	}
+	if want := (logstore.Query{Limit: defaultTailLines}); store.query != want {
+		t.Errorf("query = %+v, want %+v", store.query, want)
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	as, _ := newTestAdmin(t)
+	g := newTestGame(t, []string{"alice", "bob"})
+	if _, err := as.wars.Record(g.war(t, testRecognition())); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	for _, path := range []string{"/stats", "/stats?game=g1"} {
+		var stats []logstore.PlayerStats
+		if got := request(t, as, http.MethodGet, path, "Bearer "+testAdminToken, &stats); got != http.StatusOK {
+			t.Fatalf("GET %s = %d, want %d", path, got, http.StatusOK)
+		}
+		want := map[string]logstore.PlayerStats{
+			"alice": {Username: "alice", Lost: 1},
+			"bob":   {Username: "bob", Won: 1},
+		}
+		if len(stats) != len(want) {
+			t.Fatalf("GET %s = %+v, want %d players", path, stats, len(want))
+		}
+		for _, s := range stats {
+			if s != want[s.Username] {
+				t.Errorf("GET %s: %s has %+v, want %+v", path, s.Username, s, want[s.Username])
+			}
+		}
+	}
+
+	var stats []logstore.PlayerStats
+	if got := request(t, as, http.MethodGet, "/stats?game=g2", "Bearer "+testAdminToken, &stats); got != http.StatusOK {
+		t.Fatalf("GET /stats?game=g2 = %d, want %d", got, http.StatusOK)
+	}
+	if len(stats) != 0 {
+		t.Errorf("GET /stats?game=g2 = %+v, want no players", stats)
+	}
+}
+
+func TestAdminMutes(t *testing.T) {
+	as, _ := newTestAdmin(t)
+	as.muter.Strike("alice", time.Now())
+
+	var mutes []ratelimit.Mute
+	if got := request(t, as, http.MethodGet, "/mutes", "Bearer "+testAdminToken, &mutes); got != http.StatusOK {
+		t.Fatalf("GET /mutes = %d, want %d", got, http.StatusOK)
+	}
+	if len(mutes) != 1 || mutes[0].Key != "alice" {
+		t.Errorf("GET /mutes = %+v, want alice", mutes)
+	}
+
+	var reply map[string]string
+	if got := request(t, as, http.MethodDelete, "/mutes/alice", "Bearer "+testAdminToken, &reply); got != http.StatusOK {
+		t.Fatalf("DELETE /mutes/alice = %d, want %d", got, http.StatusOK)
+	}
+	if reply["Unmuted"] != "alice" {
+		t.Errorf("DELETE /mutes/alice replied %v, want alice unmuted", reply)
+	}
+	if as.muter.Muted("alice", time.Now()) {
+		t.Errorf("alice still muted")
+	}
+	if got := request(t, as, http.MethodDelete, "/mutes/alice", "Bearer "+testAdminToken, nil); got != http.StatusNotFound {
+		t.Errorf("DELETE /mutes/alice again = %d, want %d", got, http.StatusNotFound)
+	}
+}
+
+// The error replies are JSON too, for scripts reading them.
+func TestAdminErrorReply(t *testing.T) {
+	as, _ := newTestAdmin(t)
+	req := httptest.NewRequest(http.MethodGet, "/logs?n=0", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	res := httptest.NewRecorder()
+	as.handler().ServeHTTP(res, req)
+
+	var reply map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&reply); err != nil {
+		t.Fatalf("could not decode error reply: %v", err)
+	}
+	if !strings.HasPrefix(reply["Error"], "n: ") {
+		t.Errorf("error reply = %v, want it to explain n", reply)
+	}
+	if got := res.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/logstore"
+)
+
+// maxQueryLimit caps how many logs one query returns, since the store
+// holds them all in memory to find the newest.
+const maxQueryLimit = 10000
+
+// parseTime reads an RFC 3339 time, or a duration meaning that long ago.
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("%q is a time in the future", s)
+		}
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration like 1h", s)
+	}
+	return t, nil
+}
+
+// parseLimit reads the optional number of logs at words[i].
+func parseLimit(words []string, i int) (int, error) {
+	if len(words) <= i {
+		return defaultTailLines, nil
+	}
+	return parseCount(words[i])
+}
+
+// parseCount reads a number of logs, from 1 to maxQueryLimit.
+func parseCount(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%q is not a positive number", s)
+	}
+	if n > maxQueryLimit {
+		return 0, fmt.Errorf("%d is more than the %d logs a query returns", n, maxQueryLimit)
+	}
+	return n, nil
+}
+
+// logsQuery turns the words after "logs" into a query of the log store.
+func logsQuery(words []string) (logstore.Query, error) {
+	usage := errors.New("usage: logs tail [n] | logs user <username> [n] | logs since <time> [n] | logs grep <text>")
+	if len(words) == 0 {
+		return logstore.Query{}, usage
+	}
+	q := logstore.Query{}
+	var err error
+	switch words[0] {
+	case "tail":
+		q.Limit, err = parseLimit(words, 1)
+	case "user":
+		if len(words) < 2 {
+			return q, usage
+		}
+		q.Username = words[1]
+		q.Limit, err = parseLimit(words, 2)
+	case "since":
+		if len(words) < 2 {
+			return q, usage
+		}
+		q.Since, err = parseTime(words[1])
+		if err == nil {
+			q.Limit, err = parseLimit(words, 2)
+		}
+	case "grep":
+		if len(words) < 2 {
+			return q, usage
+		}
+		q.Contains = strings.Join(words[1:], " ")
+		q.Limit = defaultTailLines
+	default:
+		return q, usage
+	}
+	return q, err
+}
+
+// runLogsCommand runs the server's logs command.
+func runLogsCommand(store logstore.Store, wars *logstore.WarLog, words []string) error {
+	if len(words) > 0 && words[0] == "stats" {
+		gameID := ""
+		if len(words) > 1 {
+			gameID = words[1]
+		}
+		stats, err := wars.Stats(gameID)
+		if err != nil {
+			return err
+		}
+		if len(stats) == 0 {
+			fmt.Println("No wars have been fought yet.")
+		}
+		for _, s := range stats {
+			fmt.Printf("* %s: %d won, %d lost, %d drawn\n", s.Username, s.Won, s.Lost, s.Drawn)
+		}
+		return nil
+	}
+
+	q, err := logsQuery(words)
+	if err != nil {
+		return err
+	}
+	logs, err := store.Query(q)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		fmt.Println("No matching logs.")
+	}
+	for _, gl := range logs {
+		fmt.Println(logstore.Format(gl))
+	}
+	return nil
+}
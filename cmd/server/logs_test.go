@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/logstore"
+)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in      string
+		ago     time.Duration
+		at      time.Time
+		wantErr bool
+	}{
+		{in: "30m", ago: 30 * time.Minute},
+		{in: "1h30m", ago: 90 * time.Minute},
+		{in: "0s", ago: 0},
+		{in: "2024-01-01T00:00:00Z", at: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "2024-01-01T02:00:00+02:00", at: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "-1h", wantErr: true},
+		{in: "2024-01-01", wantErr: true},
+		{in: "2024-01-01 00:00:00", wantErr: true},
+		{in: "yesterday", wantErr: true},
+		{in: "30", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		before := time.Now()
+		got, err := parseTime(tt.in)
+		after := time.Now()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTime(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTime(%q): %v", tt.in, err)
+			continue
+		}
+		if !tt.at.IsZero() {
+			if !got.Equal(tt.at) {
+				t.Errorf("parseTime(%q) = %v, want %v", tt.in, got, tt.at)
+			}
+			continue
+		}
+		if got.Before(before.Add(-tt.ago)) || got.After(after.Add(-tt.ago)) {
+			t.Errorf("parseTime(%q) = %v, want %v before now", tt.in, got, tt.ago)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		words   []string
+		want    int
+		wantErr bool
+	}{
+		{words: []string{"tail"}, want: defaultTailLines},
+		{words: []string{"tail", "1"}, want: 1},
+		{words: []string{"tail", "200"}, want: 200},
+		{words: []string{"tail", "10000"}, want: maxQueryLimit},
+		{words: []string{"tail", "10001"}, wantErr: true},
+		{words: []string{"tail", "99999999999999999999"}, wantErr: true},
+		{words: []string{"tail", "0"}, wantErr: true},
+		{words: []string{"tail", "-5"}, wantErr: true},
+		{words: []string{"tail", "5.5"}, wantErr: true},
+		{words: []string{"tail", "ten"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseLimit(tt.words, 1)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseLimit(%q) = %d, want an error", tt.words, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseLimit(%q): %v", tt.words, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseLimit(%q) = %d, want %d", tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestLogsQuery(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    logstore.Query
+		wantErr bool
+	}{
+		{line: "tail", want: logstore.Query{Limit: defaultTailLines}},
+		{line: "tail 5", want: logstore.Query{Limit: 5}},
+		{line: "user alice", want: logstore.Query{Username: "alice", Limit: defaultTailLines}},
+		{line: "user alice 3", want: logstore.Query{Username: "alice", Limit: 3}},
+		{line: "since 2024-01-01T00:00:00Z 7", want: logstore.Query{Since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Limit: 7}},
+		{line: "grep lost a war", want: logstore.Query{Contains: "lost a war", Limit: defaultTailLines}},
+		{line: "", wantErr: true},
+		{line: "head", wantErr: true},
+		{line: "game g1", wantErr: true},
+		{line: "TAIL", wantErr: true},
+		{line: "tail -1", wantErr: true},
+		{line: "tail 20000", wantErr: true},
+		{line: "user", wantErr: true},
+		{line: "user alice many", wantErr: true},
+		{line: "since", wantErr: true},
+		{line: "since -1h", wantErr: true},
+		{line: "since tuesday", wantErr: true},
+		{line: "since 1h 0", wantErr: true},
+		{line: "grep", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := logsQuery(strings.Fields(tt.line))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("logsQuery(%q) = %+v, want an error", tt.line, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("logsQuery(%q): %v", tt.line, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("logsQuery(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+
+	// A relative time counts back from now
+	before := time.Now()
+	q, err := logsQuery([]string{"since", "1h"})
+	if err != nil {
+		t.Fatalf("logsQuery(since 1h): %v", err)
+	}
+	if q.Since.Before(before.Add(-time.Hour)) || q.Since.After(time.Now().Add(-time.Hour)) || q.Limit != defaultTailLines {
+		t.Errorf("logsQuery(since 1h) = %+v, want an hour ago and %d lines", q, defaultTailLines)
+	}
+}
+
+func TestRunLogsCommand(t *testing.T) {
+	store := &logStore{}
+	wars := openTestWars(t)
+
+	tests := []struct {
+		line    string
+		want    logstore.Query
+		wantErr bool
+	}{
+		{line: "user bob 2", want: logstore.Query{Username: "bob", Limit: 2}},
+		{line: "grep hello", want: logstore.Query{Contains: "hello", Limit: defaultTailLines}},
+		{line: "stats"},
+		{line: "stats g1"},
+		{line: "", wantErr: true},
+		{line: "everything", wantErr: true},
+		{line: "tail none", wantErr: true},
+	}
+	for _, tt := range tests {
+		store.query = logstore.Query{}
+		err := runLogsCommand(store, wars, strings.Fields(tt.line))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("logs %s: want an error", tt.line)
+			}
+			if store.query != (logstore.Query{}) {
+				t.Errorf("logs %s queried the store with %+v", tt.line, store.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("logs %s: %v", tt.line, err)
+			continue
+		}
+		if store.query != tt.want {
+			t.Errorf("logs %s queried %+v, want %+v", tt.line, store.query, tt.want)
+		}
+	}
+}
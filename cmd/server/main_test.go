@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/x6Nenko/peril/internal/auth"
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/leaderboard"
+	"github.com/x6Nenko/peril/internal/lobby"
+	"github.com/x6Nenko/peril/internal/logstore"
+	"github.com/x6Nenko/peril/internal/presence"
+	"github.com/x6Nenko/peril/internal/pubsub"
+	"github.com/x6Nenko/peril/internal/ratelimit"
+	"github.com/x6Nenko/peril/internal/routing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// recorder keeps the messages published on it.
+type recorder struct {
+	mu   sync.Mutex
+	keys []string
+	msgs []amqp.Publishing
+}
+
+func (r *recorder) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, key)
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+// presenceStatuses decodes the presence events published.
+func (r *recorder) presenceStatuses(t *testing.T) []routing.PresenceStatus {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := []routing.PresenceStatus{}
+	for _, msg := range r.msgs {
+		var ev routing.PresenceEvent
+		if err := json.Unmarshal(msg.Body, &ev); err != nil {
+			t.Fatalf("could not decode presence event: %v", err)
+		}
+		statuses = append(statuses, ev.Status)
+	}
+	return statuses
+}
+
+func openTestRegistry(t *testing.T) *lobby.Registry {
+	t.Helper()
+	registry, err := lobby.Open(filepath.Join(t.TempDir(), "games.json"))
+	if err != nil {
+		t.Fatalf("lobby.Open: %v", err)
+	}
+	t.Cleanup(func() { registry.Close() })
+	return registry
+}
+
+func openTestBoard(t *testing.T) *leaderboard.Store {
+	t.Helper()
+	board, err := leaderboard.Open(filepath.Join(t.TempDir(), "stats.json"))
+	if err != nil {
+		t.Fatalf("leaderboard.Open: %v", err)
+	}
+	t.Cleanup(func() { board.Close() })
+	return board
+}
+
+func TestTimedOutPlayerComesBack(t *testing.T) {
+	table := presence.NewTable(15 * time.Second)
+	registry := openTestRegistry(t)
+	sessions := auth.NewSessions()
+	ch := &recorder{}
+	if err := sessions.Start("alice", []byte("key"), false, 0); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	heartbeat := handlerHeartbeat(table, registry, sessions, openTestBoard(t), ch)
+	hb := gamelogic.Heartbeat{GameID: "g1", Username: "alice"}
+
+	if ack := heartbeat(hb); ack != pubsub.Ack {
+		t.Fatalf("heartbeat = %v, want ack", ack)
+	}
+	// The client went quiet for a minute, e.g. while the laptop slept
+	timeOut(table, registry, ch, routing.TimeoutFreeze, nil, time.Now().Add(time.Minute))
+	if table.IsOnline("alice") {
+		t.Fatalf("alice is online after timing out")
+	}
+	if !sessions.Active("alice") {
+		t.Fatalf("timing out ended alice's session")
+	}
+
+	if ack := heartbeat(hb); ack != pubsub.Ack {
+		t.Fatalf("heartbeat after the timeout = %v, want ack", ack)
+	}
+	if !table.IsOnline("alice") {
+		t.Errorf("alice is not back online")
+	}
+	want := []routing.PresenceStatus{routing.PresenceJoined, routing.PresenceTimedOut, routing.PresenceJoined}
+	if got := ch.presenceStatuses(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("presence events = %v, want %v", got, want)
+	}
+}
+
+// logStore keeps the logs written to it, or fails to write them.
+type logStore struct {
+	mu   sync.Mutex
+	logs []routing.GameLog
+	err  error
+	// query is the last query asked
+	query logstore.Query
+}
+
+func (s *logStore) Write(logs []routing.GameLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.logs = append(s.logs, logs...)
+	return nil
+}
+
+func (s *logStore) Query(q logstore.Query) ([]routing.GameLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.query = q
+	return append([]routing.GameLog{}, s.logs...), nil
+}
+
+func (s *logStore) Close() error {
+	return nil
+}
+
+func TestWriteWithQuota(t *testing.T) {
+	store := &logStore{}
+	write := writeWithQuota(logstore.NewBatcher(store, 1, time.Millisecond), ratelimit.NewLimiter(2, time.Hour), ratelimit.NewMuter(10, time.Hour, time.Hour))
+	// The cut falls inside an é, so it keeps one byte less
+	long := "a" + strings.Repeat("é", maxLogLength)
+
+	steps := []struct {
+		name     string
+		storeErr error
+		message  string
+		want     pubsub.AckType
+	}{
+		{"store fails", errors.New("disk full"), "hello", pubsub.NackRequeue},
+		{"long message", nil, long, pubsub.Ack},
+		{"over the quota", nil, "hello", pubsub.NackDiscard},
+	}
+	for _, step := range steps {
+		store.mu.Lock()
+		store.err = step.storeErr
+		store.mu.Unlock()
+		got := write(routing.GameLog{CurrentTime: time.Now(), Username: "alice", Message: step.message})
+		if got != step.want {
+			t.Errorf("%s: got %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	logs, _ := store.Query(logstore.Query{})
+	if len(logs) != 1 {
+		t.Fatalf("got %d logs written, want 1", len(logs))
+	}
+	if got := logs[0].Message; len(got) != maxLogLength-1 || !utf8.ValidString(got) {
+		t.Errorf("got a long message clipped to %d bytes (valid UTF-8: %v), want %d", len(got), utf8.ValidString(got), maxLogLength-1)
+	}
+}
+
+func openTestWars(t *testing.T) *logstore.WarLog {
+	t.Helper()
+	wars, err := logstore.OpenWarLog(filepath.Join(t.TempDir(), "wars.jsonl"))
+	if err != nil {
+		t.Fatalf("OpenWarLog: %v", err)
+	}
+	t.Cleanup(func() { wars.Close() })
+	return wars
+}
+
+// testGame is game g1 on a registry, with its players' keys.
+type testGame struct {
+	registry *lobby.Registry
+	ring     *pubsub.KeyRing
+	keys     map[string]ed25519.PrivateKey
+}
+
+// newTestGame creates g1 with the players who join it. Outsiders only get
+// a key.
+func newTestGame(t *testing.T, players []string, outsiders ...string) *testGame {
+	t.Helper()
+	g := &testGame{registry: openTestRegistry(t), ring: pubsub.NewKeyRing(), keys: map[string]ed25519.PrivateKey{}}
+	if err := g.registry.Create("g1"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, username := range append(append([]string{}, players...), outsiders...) {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		g.ring.Add(username, public)
+		g.keys[username] = private
+	}
+	for _, username := range players {
+		if _, err := g.registry.Join("g1", username); err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+	}
+	return g
+}
+
+// sign signs a message the way the player would publish it on key.
+func (g *testGame) sign(t *testing.T, username, key string, v any) routing.SignedMessage {
+	t.Helper()
+	ch := &recorder{}
+	if err := pubsub.PublishJSON(pubsub.NewSigner(ch, username, g.keys[username]), routing.ExchangePerilTopic, key, v); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	msg := ch.msgs[0]
+	return routing.SignedMessage{
+		Key:       key,
+		Username:  msg.Headers[pubsub.HeaderUser].(string),
+		Timestamp: msg.Headers[pubsub.HeaderTimestamp].(string),
+		Signature: msg.Headers[pubsub.HeaderSignature].([]byte),
+		Body:      msg.Body,
+	}
+}
+
+func testUnit(id int, owner string, rank gamelogic.UnitRank) gamelogic.Unit {
+	return gamelogic.Unit{ID: id, Owner: owner, Rank: rank, Location: "europe", Health: gamelogic.MaxUnitHealth}
+}
+
+// testRecognition is bob's recognition of alice's attack in europe.
+func testRecognition() gamelogic.RecognitionOfWar {
+	return gamelogic.RecognitionOfWar{
+		GameID:   "g1",
+		WarID:    "w1",
+		Location: "europe",
+		Attacker: gamelogic.Player{Username: "alice", Units: map[int]gamelogic.Unit{1: testUnit(1, "alice", gamelogic.RankInfantry)}},
+		Defender: gamelogic.Player{Username: "bob", Units: map[int]gamelogic.Unit{1: testUnit(1, "bob", gamelogic.RankArtillery)}},
+	}
+}
+
+// war is the war of a recognition as alice reports it, having lost.
+func (g *testGame) war(t *testing.T, rw gamelogic.RecognitionOfWar) gamelogic.WarResult {
+	t.Helper()
+	return gamelogic.WarResult{
+		GameID:       rw.GameID,
+		WarID:        rw.WarID,
+		Location:     rw.Location,
+		Attacker:     gamelogic.WarSide{Username: rw.Attacker.Username, Units: []gamelogic.Unit{rw.Attacker.Units[1]}},
+		Defender:     gamelogic.WarSide{Username: rw.Defender.Username, Units: []gamelogic.Unit{rw.Defender.Units[1]}},
+		Outcome:      gamelogic.WarDefenderWon,
+		Recognitions: []routing.SignedMessage{g.sign(t, rw.Defender.Username, routing.Key(routing.WarRecognitionsPrefix, rw.GameID, rw.Attacker.Username, rw.Defender.Username), rw)},
+	}
+}
+
+func TestWarResultNeedsRecognition(t *testing.T) {
+	g := newTestGame(t, []string{"alice", "bob"}, "mallory")
+	logs := logstore.NewBatcher(&logStore{}, 1, time.Millisecond)
+	// alice claims a win with bob's units weakened
+	weakened := func(wr *gamelogic.WarResult) {
+		wr.Defender.Units[0].Health = 1
+		wr.Outcome = gamelogic.WarAttackerWon
+	}
+
+	tests := []struct {
+		name   string
+		rw     func(rw *gamelogic.RecognitionOfWar)
+		change func(wr *gamelogic.WarResult)
+		want   pubsub.AckType
+	}{
+		{"recognized", nil, nil, pubsub.Ack},
+		{"weakened defender", nil, weakened, pubsub.Ack},
+		{"no recognition", nil, func(wr *gamelogic.WarResult) { wr.Recognitions = nil }, pubsub.NackDiscard},
+		{"recognition signed by the attacker", nil, func(wr *gamelogic.WarResult) {
+			wr.Recognitions[0] = g.sign(t, "alice", routing.Key(routing.WarRecognitionsPrefix, "g1", "alice", "alice"), testRecognition())
+		}, pubsub.NackDiscard},
+		{"forged recognition", nil, func(wr *gamelogic.WarResult) { wr.Recognitions[0].Body = []byte(`{}`) }, pubsub.NackDiscard},
+		{"other war", nil, func(wr *gamelogic.WarResult) { wr.WarID = "w2" }, pubsub.NackDiscard},
+		{"unknown game", func(rw *gamelogic.RecognitionOfWar) { rw.GameID = "g2" }, nil, pubsub.NackDiscard},
+		{"defender outside the game", func(rw *gamelogic.RecognitionOfWar) {
+			rw.Defender.Username = "mallory"
+			rw.Defender.Units = map[int]gamelogic.Unit{1: testUnit(1, "mallory", gamelogic.RankInfantry)}
+		}, nil, pubsub.NackDiscard},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wars := openTestWars(t)
+			rw := testRecognition()
+			rw.WarID = fmt.Sprintf("w%d", i+10)
+			if tt.rw != nil {
+				tt.rw(&rw)
+			}
+			wr := g.war(t, rw)
+			if tt.change != nil {
+				tt.change(&wr)
+			}
+			if got := handlerWarResult(g.ring, g.registry, wars, logs, openTestBoard(t))(wr); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			stats, err := wars.Stats("")
+			if err != nil {
+				t.Fatalf("Stats: %v", err)
+			}
+			if tt.want != pubsub.Ack {
+				if len(stats) != 0 {
+					t.Errorf("got stats %+v, want no war recorded", stats)
+				}
+				return
+			}
+			// bob's recognized artillery beats alice's infantry
+			if len(stats) != 2 {
+				t.Fatalf("got stats %+v, want alice and bob", stats)
+			}
+			for _, p := range stats {
+				if won := p.Username == "bob"; (p.Won == 1) != won {
+					t.Errorf("got stats %+v, want a war bob won", p)
+				}
+			}
+		})
+	}
+}
+
+func TestWarResultStatsFailure(t *testing.T) {
+	g := newTestGame(t, []string{"alice", "bob"})
+	wr := g.war(t, testRecognition())
+	wars := openTestWars(t)
+	logs := logstore.NewBatcher(&logStore{}, 1, time.Millisecond)
+	path := filepath.Join(t.TempDir(), "stats.json")
+	board, err := leaderboard.Open(path)
+	if err != nil {
+		t.Fatalf("leaderboard.Open: %v", err)
+	}
+	board.Close()
+
+	// The closed leaderboard can't be written
+	if got := handlerWarResult(g.ring, g.registry, wars, logs, board)(wr); got != pubsub.NackRequeue {
+		t.Fatalf("got %v with the stats failing, want %v", got, pubsub.NackRequeue)
+	}
+	if recorded, _ := wars.Recorded("w1"); recorded {
+		t.Fatalf("war was recorded without its stats")
+	}
+
+	board, err = leaderboard.Open(path)
+	if err != nil {
+		t.Fatalf("leaderboard.Open: %v", err)
+	}
+	defer board.Close()
+	for i := 0; i < 2; i++ {
+		if got := handlerWarResult(g.ring, g.registry, wars, logs, board)(wr); got != pubsub.Ack {
+			t.Fatalf("delivery %d: got %v, want %v", i+1, got, pubsub.Ack)
+		}
+	}
+	if recorded, _ := wars.Recorded("w1"); !recorded {
+		t.Errorf("war wasn't recorded")
+	}
+	players, err := board.Leaderboard(0)
+	if err != nil {
+		t.Fatalf("Leaderboard: %v", err)
+	}
+	if len(players) != 2 {
+		t.Fatalf("got %d players on the leaderboard, want 2", len(players))
+	}
+	for _, p := range players {
+		if p.Wars != 1 {
+			t.Errorf("got %d wars for %s, want 1", p.Wars, p.Username)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/lobby"
+	"github.com/x6Nenko/peril/internal/pubsub"
+	"github.com/x6Nenko/peril/internal/ratelimit"
+	"github.com/x6Nenko/peril/internal/routing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+const (
+	spectatorBuffer       = 64
+	spectatorPingInterval = 30 * time.Second
+	spectatorWriteTimeout = 10 * time.Second
+)
+
+type territoryView struct {
+	Name      gamelogic.Location
+	Continent string
+	// Units counts the units of each player in the territory
+	Units map[string]int
+}
+
+// spectatorEvent is what the browser receives: the latest map of the game
+// and, unless it only updates the map, a line for the battle feed.
+type spectatorEvent struct {
+	GameID      string
+	Time        time.Time
+	Kind        string
+	Text        string
+	Territories []territoryView
+}
+
+type spectator struct {
+	gameID string
+	send   chan spectatorEvent
+}
+
+// spectatorHub tracks the armies in every game from army reports and moves,
+// and pushes every change to the spectators of that game.
+type spectatorHub struct {
+	armies     map[string]map[string]map[int]gamelogic.Unit
+	spectators map[*spectator]struct{}
+	mu         sync.Mutex
+}
+
+func newSpectatorHub() *spectatorHub {
+	return &spectatorHub{
+		armies:     map[string]map[string]map[int]gamelogic.Unit{},
+		spectators: map[*spectator]struct{}{},
+	}
+}
+
+func (h *spectatorHub) join(gameID string) *spectator {
+	s := &spectator{
+		gameID: gameID,
+		send:   make(chan spectatorEvent, spectatorBuffer),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.spectators[s] = struct{}{}
+	s.send <- spectatorEvent{
+		GameID:      gameID,
+		Time:        time.Now(),
+		Kind:        "map",
+		Territories: h.territories(gameID),
+	}
+	return s
+}
+
+func (h *spectatorHub) leave(s *spectator) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.spectators, s)
+}
+
+// army returns the units of a player in a game. The caller must hold mu.
+func (h *spectatorHub) army(gameID, username string) map[int]gamelogic.Unit {
+	game, ok := h.armies[gameID]
+	if !ok {
+		game = map[string]map[int]gamelogic.Unit{}
+		h.armies[gameID] = game
+	}
+	units, ok := game[username]
+	if !ok {
+		units = map[int]gamelogic.Unit{}
+		game[username] = units
+	}
+	return units
+}
+
+// territories renders the map of a game. The caller must hold mu.
+func (h *spectatorHub) territories(gameID string) []territoryView {
+	rules := gamelogic.CurrentRules()
+	continents := map[gamelogic.Location]string{}
+	for _, continent := range rules.Map.Continents {
+		for _, loc := range continent.Territories {
+			continents[loc] = continent.Name
+		}
+	}
+
+	views := []territoryView{}
+	index := map[gamelogic.Location]int{}
+	for _, t := range rules.Map.Territories {
+		index[t.Name] = len(views)
+		views = append(views, territoryView{
+			Name:      t.Name,
+			Continent: continents[t.Name],
+			Units:     map[string]int{},
+		})
+	}
+	for username, units := range h.armies[gameID] {
+		for _, unit := range units {
+			if i, ok := index[unit.Location]; ok {
+				views[i].Units[username]++
+			}
+		}
+	}
+	return views
+}
+
+// publish sends an event with the current map to the game's spectators.
+// Spectators too slow to keep up miss events rather than block the server.
+// The caller must hold mu.
+func (h *spectatorHub) publish(gameID, kind, text string) {
+	ev := spectatorEvent{
+		GameID:      gameID,
+		Time:        time.Now(),
+		Kind:        kind,
+		Text:        text,
+		Territories: h.territories(gameID),
+	}
+	for s := range h.spectators {
+		if s.gameID != gameID {
+			continue
+		}
+		select {
+		case s.send <- ev:
+		default:
+		}
+	}
+}
+
+// heartbeat removes the army of a player who left.
+func (h *spectatorHub) heartbeat(hb gamelogic.Heartbeat) {
+	if !hb.Leaving {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.armies[hb.GameID], hb.Username)
+	h.publish(hb.GameID, "log", fmt.Sprintf("%s left the game", hb.Username))
+}
+
+// armyReport replaces a player's army with the one they reported. Only
+// players who logged in with this server report to it.
+func (h *spectatorHub) armyReport(ar gamelogic.ArmyReport) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	units := h.army(ar.GameID, ar.Username)
+	for id := range units {
+		delete(units, id)
+	}
+	for _, unit := range ar.Units {
+		units[unit.ID] = unit
+	}
+	h.publish(ar.GameID, "map", "")
+}
+
+func (h *spectatorHub) move(move gamelogic.ArmyMove) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	units := h.army(move.GameID, move.Username)
+	for _, unit := range move.Units {
+		units[unit.ID] = unit
+	}
+	h.publish(move.GameID, "move", fmt.Sprintf("%s moved %d units to %s", move.Username, len(move.Units), move.ToLocation))
+}
+
+func (h *spectatorHub) war(rw gamelogic.RecognitionOfWar) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publish(rw.GameID, "war", fmt.Sprintf("%s attacked %s in %s", rw.Attacker.Username, rw.Defender.Username, rw.Location))
+}
+
+func (h *spectatorHub) warResult(wr gamelogic.WarResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publish(wr.GameID, "log", wr.Message())
+}
+
+func (h *spectatorHub) gameLog(gl routing.GameLog) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publish(gl.GameID, "log", fmt.Sprintf("%s: %s", gl.Username, gl.Message))
+}
+
+// subscribeSpectatorFeeds gives the server its own copy of the moves, wars,
+// war results, logs and heartbeats of every game. Logs over a player's quota are left
+// out, like they are from game.log.
+func subscribeSpectatorFeeds(conn *amqp.Connection, serverID string, hub *spectatorHub, ring *pubsub.KeyRing, limiter *ratelimit.Limiter, muter *ratelimit.Muter) error {
+	verify := pubsub.WithVerifier(ring)
+	err := pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.Key(routing.SpectatorPrefix, serverID, routing.ArmyMovesPrefix),
+		routing.ArmyMovesPrefix+".#",
+		pubsub.Transient,
+		func(move gamelogic.ArmyMove) pubsub.AckType {
+			hub.move(move)
+			return pubsub.Ack
+		},
+		verify,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.Key(routing.SpectatorPrefix, serverID, routing.WarRecognitionsPrefix),
+		routing.WarRecognitionsPrefix+".#",
+		pubsub.Transient,
+		func(rw gamelogic.RecognitionOfWar) pubsub.AckType {
+			hub.war(rw)
+			return pubsub.Ack
+		},
+		verify,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.Key(routing.SpectatorPrefix, serverID, routing.WarResultsPrefix),
+		routing.WarResultsPrefix+".#",
+		pubsub.Transient,
+		func(wr gamelogic.WarResult) pubsub.AckType {
+			hub.warResult(wr)
+			return pubsub.Ack
+		},
+		verify,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = pubsub.SubscribeGob(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.Key(routing.SpectatorPrefix, serverID, routing.GameLogSlug),
+		routing.GameLogSlug+".#",
+		pubsub.Transient,
+		func(gl routing.GameLog) pubsub.AckType {
+			if muter.Muted(gl.Username, time.Now()) || !limiter.Allow(gl.Username) {
+				return pubsub.NackDiscard
+			}
+			hub.gameLog(gl)
+			return pubsub.Ack
+		},
+		verify,
+	)
+	if err != nil {
+		return err
+	}
+
+	return pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.Key(routing.SpectatorPrefix, serverID, routing.HeartbeatPrefix),
+		routing.HeartbeatPrefix+".#",
+		pubsub.Transient,
+		func(hb gamelogic.Heartbeat) pubsub.AckType {
+			hub.heartbeat(hb)
+			return pubsub.Ack
+		},
+		verify,
+	)
+}
+
+var upgrader = websocket.Upgrader{}
+
+// spectatorHandler serves the dashboard, the list of games and the
+// WebSocket feed of a game at /ws?game=<game>.
+// spectatorHandler serves the page to anyone, since it holds no game data,
+// but the games and the live feed need the spectator token. The feed shows
+// every player's units, so a player who could watch it would see through
+// the fog of war.
+func spectatorHandler(hub *spectatorHub, registry *lobby.Registry, token string) http.Handler {
+	static, _ := fs.Sub(webFiles, "web")
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServer(http.FS(static)))
+	mux.Handle("GET /games", spectatorAuth(token, func(w http.ResponseWriter, r *http.Request) {
+		games, err := registry.List()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, games)
+	}))
+	mux.Handle("GET /ws", spectatorAuth(token, func(w http.ResponseWriter, r *http.Request) {
+		gameID := r.URL.Query().Get("game")
+		if _, err := registry.Get(gameID); err != nil {
+			writeError(w, http.StatusNotFound, "no such game: "+gameID)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("could not upgrade spectator connection: %v", err)
+			return
+		}
+		go serveSpectator(ws, hub, gameID)
+	}))
+	return mux
+}
+
+// spectatorAuth takes the token from a "token" query parameter, since
+// browsers can't set headers on a WebSocket, or from a bearer header.
+func spectatorAuth(token string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := r.URL.Query().Get("token")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			got = bearer
+		}
+		if !validToken(got, token) {
+			writeError(w, http.StatusUnauthorized, "invalid spectator token")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func serveSpectator(ws *websocket.Conn, hub *spectatorHub, gameID string) {
+	s := hub.join(gameID)
+	defer hub.leave(s)
+	defer ws.Close()
+
+	// Spectators only watch, but reading is how we notice they left
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(spectatorPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case ev := <-s.send:
+			ws.SetWriteDeadline(time.Now().Add(spectatorWriteTimeout))
+			err := ws.WriteJSON(ev)
+			if err != nil {
+				return
+			}
+		case <-ping.C:
+			err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(spectatorWriteTimeout))
+			if err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpectatorToken(t *testing.T) {
+	registry := openTestRegistry(t)
+	srv := httptest.NewServer(spectatorHandler(newSpectatorHub(), registry, "secret"))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"page", "/", "", http.StatusOK},
+		{"no token", "/games", "", http.StatusUnauthorized},
+		{"wrong token", "/games?token=guess", "", http.StatusUnauthorized},
+		{"query token", "/games?token=secret", "", http.StatusOK},
+		{"bearer token", "/games", "Bearer secret", http.StatusOK},
+		{"wrong bearer over a good query", "/games?token=secret", "Bearer guess", http.StatusUnauthorized},
+		{"feed without a token", "/ws?game=g1", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatalf("NewRequest: %v", err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("GET %s: %v", tt.path, err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, res.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/jsonfile"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnknownUser   = errors.New("unknown user")
+	ErrWrongPassword = errors.New("wrong password")
+	ErrAlreadyOnline = errors.New("this user is already logged in")
+)
+
+const minPasswordLength = 4
+
+// Store keeps bcrypt hashes of player passwords in a shared JSON file, so
+// servers sharing it see each other's registrations.
+type Store struct {
+	file *jsonfile.File
+}
+
+func Open(path string) (*Store, error) {
+	file, err := jsonfile.Open(path, "users")
+	if err != nil {
+		return nil, err
+	}
+	return &Store{file: file}, nil
+}
+
+func (s *Store) Close() error {
+	return s.file.Close()
+}
+
+// Register adds a user. Registering an existing user with the right
+// password succeeds, so a client can safely send the same request again.
+func (s *Store) Register(username, password string) error {
+	if username == "" || strings.ContainsAny(username, " \t\n.*#") {
+		return fmt.Errorf("username %q must be a single word without '.', '*' or '#'", username)
+	}
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters long", minPasswordLength)
+	}
+
+	users := map[string]string{}
+	return s.file.Update(&users, func() (bool, error) {
+		if hash, ok := users[username]; ok {
+			if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+				return false, fmt.Errorf("username %s is already taken", username)
+			}
+			return false, nil
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return false, err
+		}
+		users[username] = string(hash)
+		return true, nil
+	})
+}
+
+func (s *Store) Verify(username, password string) error {
+	users := map[string]string{}
+	err := s.file.Read(&users)
+	if err != nil {
+		return err
+	}
+	hash, ok := users[username]
+	if !ok {
+		return ErrUnknownUser
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+type session struct {
+	key       string
+	startedAt time.Time
+}
+
+// Sessions maps logged in users to the public key their client picked for
+// the login. The key ring only holds the latest key of each user, so
+// messages signed with the key of an earlier login are rejected anyway;
+// Sessions keeps a user from taking over a live login, and ends logins that
+// went quiet.
+type Sessions struct {
+	sessions map[string]session
+	mu       *sync.RWMutex
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{
+		sessions: map[string]session{},
+		mu:       &sync.RWMutex{},
+	}
+}
+
+// Start opens a session. It fails if the user has a session with another
+// key that is still live, either because the user is online or because
+// the session started less than grace ago.
+func (s *Sessions) Start(username string, key []byte, online bool, grace time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.sessions[username]; ok && current.key != string(key) {
+		if online || time.Since(current.startedAt) < grace {
+			return ErrAlreadyOnline
+		}
+	}
+	s.sessions[username] = session{
+		key:       string(key),
+		startedAt: time.Now(),
+	}
+	return nil
+}
+
+// Active reports whether the user has logged in and their session hasn't
+// ended since.
+func (s *Sessions) Active(username string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.sessions[username]
+	return ok
+}
+
+func (s *Sessions) End(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, username)
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openStore(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRegisterAndVerify(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "users.json"))
+	if err := s.Register("alice", "secret"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := s.Register("alice", "secret"); err != nil {
+		t.Errorf("registering again with the same password: %v", err)
+	}
+	if err := s.Register("alice", "other"); err == nil {
+		t.Errorf("registering a taken username succeeded")
+	}
+
+	tests := []struct {
+		username string
+		password string
+		want     error
+	}{
+		{"alice", "secret", nil},
+		{"alice", "wrong", ErrWrongPassword},
+		{"bob", "secret", ErrUnknownUser},
+	}
+	for _, tt := range tests {
+		if err := s.Verify(tt.username, tt.password); !errors.Is(err, tt.want) {
+			t.Errorf("Verify(%s, %s) = %v, want %v", tt.username, tt.password, err, tt.want)
+		}
+	}
+}
+
+func TestRegisterRejects(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "users.json"))
+	for _, username := range []string{"", "a.b", "a b", "a*", "a#"} {
+		if err := s.Register(username, "secret"); err == nil {
+			t.Errorf("Register(%q) succeeded", username)
+		}
+	}
+	if err := s.Register("alice", "abc"); err == nil {
+		t.Errorf("Register with a short password succeeded")
+	}
+}
+
+// Servers sharing the file must not lose each other's registrations.
+func TestStoresShareTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	stores := []*Store{openStore(t, path), openStore(t, path)}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- stores[i%2].Register(fmt.Sprintf("player%d", i), "secret")
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		if err := stores[0].Verify(fmt.Sprintf("player%d", i), "secret"); err != nil {
+			t.Errorf("player%d: %v", i, err)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := NewSessions()
+	first, second := []byte("first key"), []byte("second key")
+	if err := s.Start("alice", first, false, time.Minute); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if !s.Active("alice") || s.Active("bob") {
+		t.Fatalf("Active(alice), Active(bob) = %v, %v, want true, false", s.Active("alice"), s.Active("bob"))
+	}
+	if err := s.Start("alice", first, true, time.Minute); err != nil {
+		t.Errorf("starting the same session again: %v", err)
+	}
+	if err := s.Start("alice", second, false, time.Minute); err != ErrAlreadyOnline {
+		t.Errorf("Start within the grace period = %v, want %v", err, ErrAlreadyOnline)
+	}
+	if err := s.Start("alice", second, true, 0); err != ErrAlreadyOnline {
+		t.Errorf("Start while online = %v, want %v", err, ErrAlreadyOnline)
+	}
+	if err := s.Start("alice", second, false, 0); err != nil {
+		t.Errorf("Start after the grace period: %v", err)
+	}
+	s.End("alice")
+	if s.Active("alice") {
+		t.Errorf("alice is still active after End")
+	}
+}
+
+func TestLoadServerKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.key")
+	created, err := LoadServerKey(path)
+	if err != nil {
+		t.Fatalf("LoadServerKey: %v", err)
+	}
+	loaded, err := LoadServerKey(path)
+	if err != nil {
+		t.Fatalf("LoadServerKey again: %v", err)
+	}
+	if !created.Equal(loaded) {
+		t.Errorf("a second server got a different key")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		t.Errorf("key file mode = %v, want it private", perm)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.key")
+	os.WriteFile(bad, []byte("not a key\n"), 0600)
+	if _, err := LoadServerKey(bad); err == nil {
+		t.Errorf("LoadServerKey(%s) = nil, want error", bad)
+	}
+}
@@ -0,0 +1,84 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+const (
+	chatBurst    = 5
+	chatInterval = 2 * time.Second
+)
+
+// CommandChat turns a say, whisper or ally command into the chat messages
+// to publish, one per recipient.
+func (gs *GameState) CommandChat(words []string) ([]routing.ChatMessage, error) {
+	if len(words) == 0 {
+		return nil, errors.New("usage: say <message>")
+	}
+
+	var channel string
+	var recipients []string
+	var text []string
+	switch words[0] {
+	case "say":
+		if len(words) < 2 {
+			return nil, errors.New("usage: say <message>")
+		}
+		channel = routing.ChatBroadcast
+		recipients = []string{""}
+		text = words[1:]
+	case "whisper":
+		if len(words) < 3 {
+			return nil, errors.New("usage: whisper <username> <message>")
+		}
+		channel = routing.ChatWhisper
+		recipients = []string{words[1]}
+		text = words[2:]
+	case "ally":
+		if len(words) < 2 {
+			return nil, errors.New("usage: ally <message>")
+		}
+		channel = routing.ChatAlly
+		for username, rel := range gs.GetRelationsSnap() {
+			if rel == RelationAlliance {
+				recipients = append(recipients, username)
+			}
+		}
+		if len(recipients) == 0 {
+			return nil, errors.New("error: you have no allies to talk to")
+		}
+		text = words[1:]
+	default:
+		return nil, fmt.Errorf("error: %s is not a chat command", words[0])
+	}
+
+	if !gs.chatLimiter.Allow() {
+		return nil, errors.New("error: you are sending messages too fast, slow down")
+	}
+
+	messages := []routing.ChatMessage{}
+	for _, to := range recipients {
+		messages = append(messages, routing.ChatMessage{
+			CurrentTime: time.Now(),
+			GameID:      gs.GetGameID(),
+			Channel:     channel,
+			From:        gs.GetUsername(),
+			To:          to,
+			Message:     strings.Join(text, " "),
+		})
+	}
+	return messages, nil
+}
+
+func (gs *GameState) HandleChat(msg routing.ChatMessage) {
+	if msg.From == gs.GetUsername() {
+		return
+	}
+	fmt.Println()
+	fmt.Printf("[%s] %s: %s\n", msg.Channel, msg.From, msg.Message)
+}
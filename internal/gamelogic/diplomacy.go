@@ -0,0 +1,223 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Relation is an agreement between two players. Units of players in any
+// relation share territories without going to war. A truce ends by itself
+// after TruceDuration, a pact lasts until it is broken, and allies also
+// defend each other and share the ally chat channel.
+type Relation string
+
+const (
+	RelationNone     Relation = ""
+	RelationTruce    Relation = "truce"
+	RelationPact     Relation = "pact"
+	RelationAlliance Relation = "alliance"
+)
+
+func getAllRelations() map[Relation]struct{} {
+	return map[Relation]struct{}{
+		RelationTruce:    {},
+		RelationPact:     {},
+		RelationAlliance: {},
+	}
+}
+
+// TruceDuration is how long a truce lasts
+const TruceDuration = 10 * time.Minute
+
+type DiplomacyAction string
+
+const (
+	DiplomacyPropose DiplomacyAction = "propose"
+	DiplomacyAccept  DiplomacyAction = "accept"
+	DiplomacyBreak   DiplomacyAction = "break"
+)
+
+type DiplomacyMessage struct {
+	From     string
+	To       string
+	Action   DiplomacyAction
+	Relation Relation
+}
+
+func (dm DiplomacyMessage) Sender() string {
+	return dm.From
+}
+
+func (gs *GameState) GetRelation(username string) Relation {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.relation(username, time.Now())
+}
+
+func (gs *GameState) GetRelationsSnap() map[string]Relation {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	now := time.Now()
+	relations := map[string]Relation{}
+	for k := range gs.Relations {
+		if rel := gs.relation(k, now); rel != RelationNone {
+			relations[k] = rel
+		}
+	}
+	return relations
+}
+
+// relation must be called with the lock held. An expired truce is no
+// relation at all.
+func (gs *GameState) relation(username string, now time.Time) Relation {
+	rel := gs.Relations[username]
+	if rel == RelationTruce && now.After(gs.truceEnds[username]) {
+		return RelationNone
+	}
+	return rel
+}
+
+func (gs *GameState) setRelation(username string, rel Relation) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	delete(gs.truceEnds, username)
+	if rel == RelationNone {
+		delete(gs.Relations, username)
+		return
+	}
+	gs.Relations[username] = rel
+	if rel == RelationTruce {
+		gs.truceEnds[username] = time.Now().Add(TruceDuration)
+	}
+}
+
+// isAtPeace reports whether units of the two players may share a territory
+// without going to war.
+func (gs *GameState) isAtPeace(username string) bool {
+	return gs.GetRelation(username) != RelationNone
+}
+
+func (gs *GameState) CommandPropose(words []string) (DiplomacyMessage, error) {
+	if len(words) < 3 {
+		return DiplomacyMessage{}, errors.New("usage: propose <username> <alliance|truce|pact>")
+	}
+	to := words[1]
+	rel := Relation(words[2])
+	if _, ok := getAllRelations()[rel]; !ok {
+		return DiplomacyMessage{}, fmt.Errorf("error: %s is not a valid relation", rel)
+	}
+	if to == gs.GetUsername() {
+		return DiplomacyMessage{}, errors.New("error: you can not negotiate with yourself")
+	}
+
+	gs.mu.Lock()
+	gs.Offers[to] = rel
+	gs.mu.Unlock()
+
+	fmt.Printf("Proposed a %s to %s\n", rel, to)
+	return DiplomacyMessage{
+		From:     gs.GetUsername(),
+		To:       to,
+		Action:   DiplomacyPropose,
+		Relation: rel,
+	}, nil
+}
+
+func (gs *GameState) CommandAccept(words []string) (DiplomacyMessage, error) {
+	if len(words) < 2 {
+		return DiplomacyMessage{}, errors.New("usage: accept <username>")
+	}
+	from := words[1]
+
+	gs.mu.Lock()
+	rel, ok := gs.Proposals[from]
+	delete(gs.Proposals, from)
+	gs.mu.Unlock()
+	if !ok {
+		return DiplomacyMessage{}, fmt.Errorf("error: %s has not proposed anything", from)
+	}
+
+	gs.setRelation(from, rel)
+	fmt.Printf("You are now in a %s with %s\n", rel, from)
+	if rel == RelationTruce {
+		fmt.Printf("The truce ends in %v.\n", TruceDuration)
+	}
+	return DiplomacyMessage{
+		From:     gs.GetUsername(),
+		To:       from,
+		Action:   DiplomacyAccept,
+		Relation: rel,
+	}, nil
+}
+
+func (gs *GameState) CommandBreak(words []string) (DiplomacyMessage, error) {
+	if len(words) < 2 {
+		return DiplomacyMessage{}, errors.New("usage: break <username>")
+	}
+	to := words[1]
+	rel := gs.GetRelation(to)
+	if rel == RelationNone {
+		return DiplomacyMessage{}, fmt.Errorf("error: you have no agreement with %s", to)
+	}
+
+	gs.setRelation(to, RelationNone)
+	fmt.Printf("You broke your %s with %s\n", rel, to)
+	return DiplomacyMessage{
+		From:     gs.GetUsername(),
+		To:       to,
+		Action:   DiplomacyBreak,
+		Relation: rel,
+	}, nil
+}
+
+func (gs *GameState) HandleDiplomacy(dm DiplomacyMessage) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Diplomacy ====")
+	if dm.To != gs.GetUsername() {
+		fmt.Printf("Ignoring a message meant for %s.\n", dm.To)
+		return
+	}
+
+	switch dm.Action {
+	case DiplomacyPropose:
+		gs.mu.Lock()
+		gs.Proposals[dm.From] = dm.Relation
+		gs.mu.Unlock()
+		fmt.Printf("%s proposes a %s. Type 'accept %s' to agree.\n", dm.From, dm.Relation, dm.From)
+	case DiplomacyAccept:
+		gs.mu.Lock()
+		offered, ok := gs.Offers[dm.From]
+		delete(gs.Offers, dm.From)
+		gs.mu.Unlock()
+		if !ok || offered != dm.Relation {
+			fmt.Printf("%s accepted a %s you never offered.\n", dm.From, dm.Relation)
+			return
+		}
+		gs.setRelation(dm.From, dm.Relation)
+		fmt.Printf("%s accepted your %s!\n", dm.From, dm.Relation)
+		if dm.Relation == RelationTruce {
+			fmt.Printf("The truce ends in %v.\n", TruceDuration)
+		}
+	case DiplomacyBreak:
+		gs.setRelation(dm.From, RelationNone)
+		fmt.Printf("%s broke your %s!\n", dm.From, dm.Relation)
+	default:
+		fmt.Printf("Unknown diplomatic action %q from %s.\n", dm.Action, dm.From)
+	}
+}
+
+// getAllies returns the players we are in an alliance with, other than the
+// excluded player.
+func (gs *GameState) getAllies(exclude string) []string {
+	allies := []string{}
+	for username, rel := range gs.GetRelationsSnap() {
+		if username != exclude && rel == RelationAlliance {
+			allies = append(allies, username)
+		}
+	}
+	sort.Strings(allies)
+	return allies
+}
@@ -0,0 +1,147 @@
+package gamelogic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+func TestTruceEnds(t *testing.T) {
+	gs := NewGameState("alice")
+	gs.setRelation("bob", RelationTruce)
+	gs.setRelation("carol", RelationPact)
+	if got := gs.GetRelation("bob"); got != RelationTruce {
+		t.Fatalf("GetRelation(bob) = %q, want %q", got, RelationTruce)
+	}
+
+	gs.mu.Lock()
+	gs.truceEnds["bob"] = time.Now().Add(-time.Second)
+	gs.mu.Unlock()
+	if got := gs.GetRelation("bob"); got != RelationNone {
+		t.Errorf("GetRelation(bob) after the truce = %q, want none", got)
+	}
+	if gs.isAtPeace("bob") {
+		t.Errorf("still at peace with bob after the truce ended")
+	}
+	relations := gs.GetRelationsSnap()
+	if _, ok := relations["bob"]; ok || relations["carol"] != RelationPact {
+		t.Errorf("GetRelationsSnap() = %v, want only the pact with carol", relations)
+	}
+}
+
+func TestGetAllies(t *testing.T) {
+	gs := NewGameState("alice")
+	gs.setRelation("bob", RelationAlliance)
+	gs.setRelation("carol", RelationAlliance)
+	gs.setRelation("dave", RelationPact)
+	got := gs.getAllies("carol")
+	if len(got) != 1 || got[0] != "bob" {
+		t.Errorf("getAllies(carol) = %v, want [bob]", got)
+	}
+}
+
+// newTestPlayer joins a game with one full health unit of each rank given,
+// all in the location.
+func newTestPlayer(username string, loc Location, ranks ...UnitRank) *GameState {
+	gs := NewGameState(username)
+	gs.JoinGame("g1", false)
+	for i, rank := range ranks {
+		newTestUnit(gs, i+1, rank, loc)
+		gs.NextUnitID = i + 2
+	}
+	return gs
+}
+
+func moveOf(gs *GameState) ArmyMove {
+	units := []Unit{}
+	for _, unit := range gs.GetPlayerSnap().Units {
+		units = append(units, unit)
+	}
+	return ArmyMove{GameID: gs.GetGameID(), ToLocation: units[0].Location, Units: units, Username: gs.GetUsername()}
+}
+
+func TestAlliesDefendTogether(t *testing.T) {
+	attacker := newTestPlayer("xavier", "europe", RankCavalry)
+	defender := newTestPlayer("dana", "europe", RankInfantry, RankInfantry)
+	ally := newTestPlayer("abe", "europe", RankCavalry)
+	defender.setRelation("abe", RelationAlliance)
+	ally.setRelation("dana", RelationAlliance)
+
+	move := moveOf(attacker)
+	rwDefender := defender.RecognizeWar(move)
+	rwAlly := ally.RecognizeWar(move)
+
+	for _, rw := range []RecognitionOfWar{rwDefender, rwAlly} {
+		if outcome, _ := attacker.HandleWar(rw); outcome != WarOutcomeGathering {
+			t.Fatalf("HandleWar(%s) = %v, want gathering", rw.Defender.Username, outcome)
+		}
+	}
+	if answers := attacker.FightGatheredWars(time.Now()); len(answers) != 0 {
+		t.Fatalf("fought %d wars before the allies had time to join", len(answers))
+	}
+
+	answers := attacker.FightGatheredWars(time.Now().Add(allyGatherWindow))
+	if len(answers) != 2 {
+		t.Fatalf("got %d answers, want one for the defender and one for the ally", len(answers))
+	}
+	lead, joined := answers[0], answers[1]
+	if lead.To != "dana" || joined.To != "abe" {
+		t.Fatalf("answers go to %s and %s, want dana and abe", lead.To, joined.To)
+	}
+	if joined.Result.WarID != rwAlly.WarID || lead.Result.WarID != rwDefender.WarID {
+		t.Errorf("answers don't carry the WarIDs of the recognitions they answer")
+	}
+	if got, want := lead.Key(), routing.Key(routing.WarResultsPrefix, "g1", "dana", "xavier"); got != want {
+		t.Errorf("defender key = %q, want %q", got, want)
+	}
+	if got, want := joined.Key(), routing.Key(routing.WarAlliesPrefix, "g1", "abe", "xavier"); got != want {
+		t.Errorf("ally key = %q, want %q", got, want)
+	}
+	wr := lead.Result
+	if wr.Outcome != WarDefenderWon || len(wr.Defender.Allies) != 1 {
+		t.Fatalf("result = %+v, want the defender to win with one allied unit", wr)
+	}
+
+	for _, player := range []struct {
+		gs     *GameState
+		answer WarAnswer
+	}{{defender, lead}, {ally, joined}} {
+		outcome, ok := player.gs.HandleWarResult(player.answer.Result)
+		if !ok || outcome != WarDefenderWon {
+			t.Errorf("%s: HandleWarResult = %v, %v, want %v", player.answer.To, outcome, ok, WarDefenderWon)
+		}
+		for _, unit := range player.gs.GetPlayerSnap().Units {
+			if unit.Health != MaxUnitHealth-wr.Defender.Damage || unit.Experience != winExperience {
+				t.Errorf("%s: unit %+v did not take %v damage and win experience", player.answer.To, unit, wr.Defender.Damage)
+			}
+		}
+	}
+	if _, ok := ally.HandleWarResult(joined.Result); ok {
+		t.Errorf("ally applied the same result twice")
+	}
+}
+
+func TestAlliesMustNameEachOther(t *testing.T) {
+	attacker := newTestPlayer("xavier", "europe", RankCavalry)
+	defender := newTestPlayer("dana", "europe", RankInfantry)
+	other := newTestPlayer("abe", "europe", RankInfantry)
+	// dana claims abe as an ally, but abe never agreed
+	defender.setRelation("abe", RelationAlliance)
+
+	move := moveOf(attacker)
+	rwDefender := defender.RecognizeWar(move)
+	rwOther := other.RecognizeWar(move)
+	if outcome, _ := attacker.HandleWar(rwDefender); outcome != WarOutcomeGathering {
+		t.Fatalf("HandleWar(dana) = %v, want gathering", outcome)
+	}
+	outcome, result := attacker.HandleWar(rwOther)
+	if outcome == WarOutcomeGathering || len(result.Defender.Allies) != 0 {
+		t.Fatalf("abe joined a defense it never agreed to: %v, %+v", outcome, result)
+	}
+
+	answers := attacker.FightGatheredWars(time.Now().Add(allyGatherWindow))
+	if len(answers) != 1 || len(answers[0].Result.Defender.Allies) != 0 {
+		t.Fatalf("answers = %+v, want dana to fight alone", answers)
+	}
+}
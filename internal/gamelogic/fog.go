@@ -0,0 +1,83 @@
+package gamelogic
+
+// visibleLocations returns every location the player occupies plus every
+// location adjacent to one of them, or two steps away for scouts. Enemy
+// units anywhere else are hidden.
+func (gs *GameState) visibleLocations() map[Location]struct{} {
+	adjacent := getAdjacentLocations()
+	visible := map[Location]struct{}{}
+	for _, unit := range gs.getUnitsSnap() {
+		visible[unit.Location] = struct{}{}
+		unitType, _ := CurrentRules().UnitType(unit.Rank)
+		for _, loc := range adjacent[unit.Location] {
+			visible[loc] = struct{}{}
+			if !unitType.HasAbility(AbilityScout) {
+				continue
+			}
+			for _, far := range adjacent[loc] {
+				visible[far] = struct{}{}
+			}
+		}
+	}
+	return visible
+}
+
+func (gs *GameState) IsVisible(loc Location) bool {
+	_, ok := gs.visibleLocations()[loc]
+	return ok
+}
+
+func (gs *GameState) recordSighting(username string, units []Unit) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	sighted, ok := gs.Sightings[username]
+	if !ok {
+		sighted = map[int]Unit{}
+		gs.Sightings[username] = sighted
+	}
+	for _, unit := range units {
+		sighted[unit.ID] = unit
+	}
+}
+
+func (gs *GameState) forgetSighting(username string, units []Unit) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	sighted, ok := gs.Sightings[username]
+	if !ok {
+		return
+	}
+	for _, unit := range units {
+		delete(sighted, unit.ID)
+	}
+	if len(sighted) == 0 {
+		delete(gs.Sightings, username)
+	}
+}
+
+func (gs *GameState) forgetSightingsInLocation(username string, loc Location) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for k, v := range gs.Sightings[username] {
+		if v.Location == loc {
+			delete(gs.Sightings[username], k)
+		}
+	}
+}
+
+// GetSightingsSnap returns the enemy units the player can currently see,
+// keyed by username. Stale sightings outside the visible area are omitted.
+func (gs *GameState) GetSightingsSnap() map[string][]Unit {
+	visible := gs.visibleLocations()
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	sightings := map[string][]Unit{}
+	for username, units := range gs.Sightings {
+		for _, unit := range units {
+			if _, ok := visible[unit.Location]; ok {
+				sightings[username] = append(sightings[username], unit)
+			}
+		}
+	}
+	return sightings
+}
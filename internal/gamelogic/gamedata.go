@@ -1,5 +1,12 @@
 package gamelogic
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
 type Player struct {
 	Username string
 	Units    map[int]Unit
@@ -14,39 +21,149 @@ const (
 )
 
 type Unit struct {
-	ID       int
-	Rank     UnitRank
-	Location Location
+	ID         int
+	Owner      string
+	Rank       UnitRank
+	Location   Location
+	Health     int
+	Experience int
 }
 
+// GlobalID identifies the unit across all players, e.g. "alice:3".
+// ID alone is only unique within the owner's army.
+func (u Unit) GlobalID() string {
+	return fmt.Sprintf("%s:%d", u.Owner, u.ID)
+}
+
+// ArmyMove only carries the units that moved, never the mover's full army.
 type ArmyMove struct {
-	Player     Player
+	GameID     string
+	Username   string
 	Units      []Unit
 	ToLocation Location
 }
 
+func (am ArmyMove) Sender() string {
+	return am.Username
+}
+
+// RecognitionOfWar is sent by the defender to the attacker when armies
+// meet. Each side only reveals its units in the contested location. Allies
+// of the defender with units there send recognitions of their own, and
+// join the defense if they name each other in Allies. The attacker must
+// fight the war by the deadline, or not at all.
 type RecognitionOfWar struct {
+	GameID   string
+	WarID    string
+	Location Location
 	Attacker Player
 	Defender Player
+	Allies   []string
+	// Timeout is how long the defender waits for the result, from when it
+	// sent the recognition by its own clock. The recognition expires on
+	// the broker by then, so the attacker can't get it too late whatever
+	// its clock says.
+	Timeout time.Duration
+	// Signed is the recognition as the defender signed it, which the
+	// attacker passes on to the servers with the result
+	Signed routing.SignedMessage `json:"-"`
+}
+
+// Sender is the defender, who publishes the recognition.
+func (rw RecognitionOfWar) Sender() string {
+	return rw.Defender.Username
+}
+
+func (rw *RecognitionOfWar) SetSigned(msg routing.SignedMessage) {
+	rw.Signed = msg
+}
+
+// WarResultOutcome says which side of a war won.
+type WarResultOutcome string
+
+const (
+	WarAttackerWon WarResultOutcome = "attacker_won"
+	WarDefenderWon WarResultOutcome = "defender_won"
+	WarDraw        WarResultOutcome = "draw"
+)
+
+// WarSide is one side of a fought war.
+type WarSide struct {
+	Username string
+	// Units are the side's units in the location as the war started
+	Units []Unit
+	// Allies are the units the defender's allies revealed in their own
+	// recognitions, and that fought with the defender
+	Allies []Unit
+	Power  float64
+	// Damage is the health each of the side's units lost
+	Damage int
+	// Casualties are the units the damage killed
+	Casualties []Unit
+}
+
+// WarResult reports a fought war. The attacker resolves the war and
+// publishes the result to the defender and the servers.
+type WarResult struct {
+	GameID      string
+	WarID       string
+	CurrentTime time.Time
+	Location    Location
+	Attacker    WarSide
+	Defender    WarSide
+	Outcome     WarResultOutcome
+	// Recognitions are the recognitions of the defenders the war was
+	// fought on, the lead defender's first, as they signed them
+	Recognitions []routing.SignedMessage `json:",omitempty"`
+}
+
+// Sender is the attacker, who publishes the result.
+func (wr WarResult) Sender() string {
+	return wr.Attacker.Username
+}
+
+// Winner and loser of the war. A draw has neither, and returns the
+// attacker and the defender.
+func (wr WarResult) Winner() string {
+	if wr.Outcome == WarDefenderWon {
+		return wr.Defender.Username
+	}
+	return wr.Attacker.Username
+}
+
+func (wr WarResult) Loser() string {
+	if wr.Outcome == WarDefenderWon {
+		return wr.Attacker.Username
+	}
+	return wr.Defender.Username
+}
+
+// Message describes the result for humans, as it appears in the game logs.
+func (wr WarResult) Message() string {
+	if wr.Outcome == WarDraw {
+		return fmt.Sprintf("A war between %s and %s resulted in a draw", wr.Attacker.Username, wr.Defender.Username)
+	}
+	return fmt.Sprintf("%s won a war against %s", wr.Winner(), wr.Loser())
 }
 
 type Location string
 
 func getAllRanks() map[UnitRank]struct{} {
-	return map[UnitRank]struct{}{
-		RankInfantry:  {},
-		RankCavalry:   {},
-		RankArtillery: {},
+	ranks := map[UnitRank]struct{}{}
+	for _, ut := range CurrentRules().Units {
+		ranks[ut.Rank] = struct{}{}
 	}
+	return ranks
 }
 
 func getAllLocations() map[Location]struct{} {
-	return map[Location]struct{}{
-		"americas":   {},
-		"europe":     {},
-		"africa":     {},
-		"asia":       {},
-		"australia":  {},
-		"antarctica": {},
+	locations := map[Location]struct{}{}
+	for _, t := range CurrentRules().Map.Territories {
+		locations[t.Name] = struct{}{}
 	}
+	return locations
+}
+
+func getAdjacentLocations() map[Location][]Location {
+	return CurrentRules().adjacency()
 }
@@ -7,6 +7,9 @@ import (
 	"math/rand"
 	"os"
 	"strings"
+
+	"github.com/x6Nenko/peril/internal/routing"
+	"golang.org/x/term"
 )
 
 func PrintClientHelp() {
@@ -17,7 +20,25 @@ func PrintClientHelp() {
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
+	fmt.Println("* promote <unitID>")
+	fmt.Println("    example:")
+	fmt.Println("    promote 1")
+	fmt.Println("* retreat <from> <to>")
+	fmt.Println("    example:")
+	fmt.Println("    retreat asia europe")
+	fmt.Println("* propose <username> <alliance|truce|pact>")
+	fmt.Println("    example:")
+	fmt.Println("    propose bob alliance")
+	fmt.Println("* accept <username>")
+	fmt.Println("* break <username>")
+	fmt.Println("* say <message>")
+	fmt.Println("* whisper <username> <message>")
+	fmt.Println("* ally <message>")
+	fmt.Println("    example:")
+	fmt.Println("    whisper bob meet me in asia")
 	fmt.Println("* status")
+	fmt.Println("* rules")
+	fmt.Println("* leaderboard [n]")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -34,28 +55,81 @@ func ClientWelcome() (string, error) {
 	}
 	username := words[0]
 	fmt.Printf("Welcome, %s!\n", username)
-	PrintClientHelp()
 	return username, nil
 }
 
+func PrintLobbyHelp() {
+	fmt.Println("You are in the lobby. Possible commands:")
+	fmt.Println("* list")
+	fmt.Println("* create <game>")
+	fmt.Println("* join <game>")
+	fmt.Println("    example:")
+	fmt.Println("    join fridaynight")
+	fmt.Println("* leaderboard [n]")
+	fmt.Println("* quit")
+	fmt.Println("* help")
+}
+
 func PrintServerHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* pause")
-	fmt.Println("* resume")
+	fmt.Println("* games")
+	fmt.Println("* players")
+	fmt.Println("* pause [game]")
+	fmt.Println("* resume [game]")
+	fmt.Println("    pauses or resumes every game if none is given")
+	fmt.Println("* mutes")
+	fmt.Println("* unmute <username>")
+	fmt.Println("* logs tail [n]")
+	fmt.Println("* logs user <username> [n]")
+	fmt.Println("* logs since <time> [n]")
+	fmt.Println("    time is RFC 3339 or a duration ago, e.g. 30m")
+	fmt.Println("* logs grep <text>")
+	fmt.Println("* logs stats [game]")
+	fmt.Println("    wars won, lost and drawn per player")
+	fmt.Println("* leaderboard [n]")
+	fmt.Println("    the n best rated players, 10 by default")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
 
+// stdin is shared by every prompt, so lines buffered by one read are not
+// lost to the next when input is piped in.
+var stdin = bufio.NewScanner(os.Stdin)
+
+// ReadInput prompts for a line and splits it into words. It returns false
+// once stdin is closed.
+func ReadInput() ([]string, bool) {
+	fmt.Print("> ")
+	if !stdin.Scan() {
+		return nil, false
+	}
+	return strings.Fields(stdin.Text()), true
+}
+
 func GetInput() []string {
+	words, _ := ReadInput()
+	return words
+}
+
+// GetPassword reads a password without echoing it when stdin is a
+// terminal.
+func GetPassword() string {
+	fmt.Println("Please enter your password:")
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		words := GetInput()
+		if len(words) == 0 {
+			return ""
+		}
+		return words[0]
+	}
 	fmt.Print("> ")
-	scanner := bufio.NewScanner(os.Stdin)
-	scanned := scanner.Scan()
-	if !scanned {
-		return nil
-	}
-	line := scanner.Text()
-	line = strings.TrimSpace(line)
-	return strings.Fields(line)
+	password, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(password))
 }
 
 func GetMaliciousLog() string {
@@ -72,12 +146,23 @@ func GetMaliciousLog() string {
 	return msg
 }
 
+func PrintLeaderboard(players []routing.PlayerStats) {
+	if len(players) == 0 {
+		fmt.Println("Nobody has played yet.")
+		return
+	}
+	for i, p := range players {
+		fmt.Printf("%d. %s: %.0f rating, %d wars (%d won, %d lost, %d drawn), %d units spawned, %d lost, %d territories held\n",
+			i+1, p.Username, p.Rating, p.Wars, p.Won, p.Lost, p.Drawn, p.UnitsSpawned, p.UnitsLost, p.Territories)
+	}
+}
+
 func PrintQuit() {
 	fmt.Println("I hate this game! (╯°□°)╯︵ ┻━┻")
 }
 
 func (gs *GameState) CommandStatus() {
-	if gs.isPaused() {
+	if gs.IsPaused() {
 		fmt.Println("The game is paused.")
 		return
 	} else {
@@ -87,6 +172,51 @@ func (gs *GameState) CommandStatus() {
 	p := gs.GetPlayerSnap()
 	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
 	for _, unit := range p.Units {
-		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
+		fmt.Printf("* %v: %v, %v (%v/%v hp, %s, %v xp)\n", unit.ID, unit.Location, unit.Rank, unit.Health, MaxUnitHealth, veterancyTitle(unit), unit.Experience)
+	}
+
+	for username, rel := range gs.GetRelationsSnap() {
+		fmt.Printf("You have a %s with %s.\n", rel, username)
+	}
+
+	sightings := gs.GetSightingsSnap()
+	if len(sightings) == 0 {
+		fmt.Println("No enemy units in sight.")
+		return
+	}
+	fmt.Println("Enemy units in sight:")
+	for username, units := range sightings {
+		for _, unit := range units {
+			fmt.Printf("* %s's %v in %v\n", username, unit.Rank, unit.Location)
+		}
+	}
+}
+
+func PrintRules() {
+	rules := CurrentRules()
+	fmt.Printf("Scenario: %s\n", rules.Name)
+	if rules.ArmyCap > 0 {
+		fmt.Printf("Army cap: %v\n", rules.ArmyCap)
+	}
+	fmt.Println("Units:")
+	for _, ut := range rules.Units {
+		fmt.Printf("* %s: power %v, cost %v", ut.Rank, ut.Power, ut.Cost)
+		if ut.Movement > 0 {
+			fmt.Printf(", movement %v", ut.Movement)
+		}
+		if len(ut.Abilities) > 0 {
+			fmt.Printf(", abilities %v", ut.Abilities)
+		}
+		if ut.PromotesTo != "" {
+			fmt.Printf(", promotes to %s", ut.PromotesTo)
+		}
+		fmt.Println()
+	}
+	fmt.Println("Territories:")
+	for _, t := range rules.Map.Territories {
+		fmt.Printf("* %s, adjacent to %v\n", t.Name, t.Adjacent)
+	}
+	for _, c := range rules.Map.Continents {
+		fmt.Printf("Continent %s (bonus %v): %v\n", c.Name, c.Bonus, c.Territories)
 	}
 }
@@ -2,12 +2,43 @@ package gamelogic
 
 import (
 	"sync"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/ratelimit"
+	"github.com/x6Nenko/peril/internal/routing"
 )
 
 type GameState struct {
 	Player Player
+	GameID string
 	Paused bool
-	mu     *sync.RWMutex
+	// NextUnitID is the ID handed to the next spawned unit. It only ever
+	// grows, so IDs of dead units are never reused.
+	NextUnitID int
+	Sightings  map[string]map[int]Unit
+	// Relations holds our agreements with other players, Proposals the
+	// ones offered to us and Offers the ones we are waiting on
+	Relations map[string]Relation
+	Proposals map[string]Relation
+	Offers    map[string]Relation
+	// Presence is the last known status of the other players
+	Presence map[string]routing.PresenceStatus
+
+	// frozen are the players who timed out with their units frozen
+	frozen map[string]bool
+	// truceEnds is when each of our truces runs out
+	truceEnds map[string]time.Time
+	// pendingWars are the wars we defend, waiting on the attacker's result
+	pendingWars map[string]pendingWar
+	// foughtWars are the wars we attacked in, kept to answer the same
+	// recognition twice with the same result
+	foughtWars map[string]foughtWar
+	// gatheringWars are the wars we attacked in that wait for the
+	// defender's allies to join, by the WarID of the defender's recognition
+	gatheringWars map[string]*warGroup
+
+	chatLimiter *ratelimit.Bucket
+	mu          *sync.RWMutex
 }
 
 func NewGameState(username string) *GameState {
@@ -16,11 +47,39 @@ func NewGameState(username string) *GameState {
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Paused:     false,
+		NextUnitID: 1,
+		Sightings:  map[string]map[int]Unit{},
+		Relations:  map[string]Relation{},
+		Proposals:  map[string]Relation{},
+		Offers:     map[string]Relation{},
+		Presence:   map[string]routing.PresenceStatus{},
+
+		frozen:        map[string]bool{},
+		truceEnds:     map[string]time.Time{},
+		pendingWars:   map[string]pendingWar{},
+		foughtWars:    map[string]foughtWar{},
+		gatheringWars: map[string]*warGroup{},
+
+		chatLimiter: ratelimit.NewBucket(chatBurst, chatInterval),
+		mu:          &sync.RWMutex{},
 	}
 }
 
+// JoinGame records the game picked in the lobby and whether it is paused.
+func (gs *GameState) JoinGame(gameID string, paused bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.GameID = gameID
+	gs.Paused = paused
+}
+
+func (gs *GameState) GetGameID() string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.GameID
+}
+
 func (gs *GameState) resumeGame() {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -33,12 +92,20 @@ func (gs *GameState) pauseGame() {
 	gs.Paused = true
 }
 
-func (gs *GameState) isPaused() bool {
+func (gs *GameState) IsPaused() bool {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
 	return gs.Paused
 }
 
+func (gs *GameState) allocateUnitID() int {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	id := gs.NextUnitID
+	gs.NextUnitID++
+	return id
+}
+
 func (gs *GameState) addUnit(u Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -94,3 +161,15 @@ func (gs *GameState) GetPlayerSnap() Player {
 		Units:    Units,
 	}
 }
+
+func (gs *GameState) getUnitsInLocation(loc Location) []Unit {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	Units := []Unit{}
+	for _, v := range gs.Player.Units {
+		if v.Location == loc {
+			Units = append(Units, v)
+		}
+	}
+	return Units
+}
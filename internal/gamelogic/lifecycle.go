@@ -0,0 +1,225 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const MaxUnitHealth = 100
+
+const (
+	// experiencePerLevel is how much experience a unit needs per veterancy level
+	experiencePerLevel = 3
+	maxVeterancy       = 2
+	// PromotionCost is the experience spent to promote a unit one rank
+	PromotionCost = 5
+)
+
+const (
+	winExperience  = 2
+	drawExperience = 1
+	lossExperience = 1
+)
+
+func veterancy(u Unit) int {
+	level := u.Experience / experiencePerLevel
+	if level > maxVeterancy {
+		return maxVeterancy
+	}
+	return level
+}
+
+func veterancyTitle(u Unit) string {
+	switch veterancy(u) {
+	case 0:
+		return "recruit"
+	case 1:
+		return "veteran"
+	default:
+		return "elite"
+	}
+}
+
+// unitPower scales the rank's power by the unit's remaining health and
+// gives a 25% bonus per veterancy level.
+func unitPower(u Unit) float64 {
+	health := float64(u.Health) / MaxUnitHealth
+	return rankPower(u.Rank) * health * (1 + 0.25*float64(veterancy(u)))
+}
+
+// warDamage returns the damage dealt to every unit of the winning and the
+// losing side. A close defeat wounds the loser, while a winner with at
+// least twice the loser's power wipes out full-health units.
+func warDamage(winnerPower, loserPower float64) (winnerDamage, loserDamage int) {
+	if winnerPower <= 0 {
+		return 0, 0
+	}
+	margin := (winnerPower - loserPower) / winnerPower
+	loserDamage = 40 + int(math.Round(120*margin))
+	winnerDamage = int(math.Round(40 * loserPower / winnerPower))
+	return winnerDamage, loserDamage
+}
+
+func rankPromotions() map[UnitRank]UnitRank {
+	promotions := map[UnitRank]UnitRank{}
+	for _, ut := range CurrentRules().Units {
+		if ut.PromotesTo != "" {
+			promotions[ut.Rank] = ut.PromotesTo
+		}
+	}
+	return promotions
+}
+
+// applyWarDamage damages the units in the location that fought, or all of
+// them if fighters is nil. Units that survive gain experience, units that
+// run out of health are removed.
+func (gs *GameState) applyWarDamage(loc Location, damage, experience int, fighters map[int]Unit) (survivors, killed []Unit) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for k, v := range gs.Player.Units {
+		if v.Location != loc {
+			continue
+		}
+		if _, ok := fighters[k]; fighters != nil && !ok {
+			continue
+		}
+		v.Health -= damage
+		if v.Health <= 0 {
+			delete(gs.Player.Units, k)
+			killed = append(killed, v)
+			continue
+		}
+		v.Experience += experience
+		gs.Player.Units[k] = v
+		survivors = append(survivors, v)
+	}
+	return survivors, killed
+}
+
+func (gs *GameState) damageSightingsInLocation(username string, loc Location, damage int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for k, v := range gs.Sightings[username] {
+		if v.Location != loc {
+			continue
+		}
+		v.Health -= damage
+		if v.Health <= 0 {
+			delete(gs.Sightings[username], k)
+			continue
+		}
+		gs.Sightings[username][k] = v
+	}
+}
+
+func (gs *GameState) takeCasualties(loc Location, damage, experience int, fighters map[int]Unit) (killed []Unit) {
+	survivors, killed := gs.applyWarDamage(loc, damage, experience, fighters)
+	if len(killed) > 0 {
+		fmt.Printf("%v of your units in %s have been killed.\n", len(killed), loc)
+	}
+	if len(survivors) == 0 {
+		return killed
+	}
+	fmt.Printf("%v of your units in %s survived:\n", len(survivors), loc)
+	for _, unit := range survivors {
+		fmt.Printf("  * %v: %v (%v hp, %s)\n", unit.ID, unit.Rank, unit.Health, veterancyTitle(unit))
+	}
+	return killed
+}
+
+func (gs *GameState) CommandPromote(words []string) error {
+	if gs.IsPaused() {
+		return errors.New("the game is paused, you can not promote units")
+	}
+	if len(words) < 2 {
+		return errors.New("usage: promote <unitID>")
+	}
+	unitID, err := strconv.Atoi(words[1])
+	if err != nil {
+		return fmt.Errorf("error: %s is not a valid unit ID", words[1])
+	}
+	unit, ok := gs.GetUnit(unitID)
+	if !ok {
+		return fmt.Errorf("error: unit with ID %v not found", unitID)
+	}
+	newRank, ok := rankPromotions()[unit.Rank]
+	if !ok {
+		return fmt.Errorf("error: %s can not be promoted any further", unit.Rank)
+	}
+	if unit.Experience < PromotionCost {
+		return fmt.Errorf("error: unit %v needs %v experience to be promoted, it has %v", unitID, PromotionCost, unit.Experience)
+	}
+
+	unitType, _ := CurrentRules().UnitType(unit.Rank)
+	newType, _ := CurrentRules().UnitType(newRank)
+	err = gs.checkArmyCap(newType.Cost - unitType.Cost)
+	if err != nil {
+		return err
+	}
+
+	unit.Rank = newRank
+	unit.Experience -= PromotionCost
+	gs.UpdateUnit(unit)
+	fmt.Printf("Promoted unit %v to %s\n", unitID, newRank)
+	return nil
+}
+
+// CommandRetreat moves every unit in a location to an adjacent location
+// with no enemy units in sight, so that survivors of a lost war live to
+// fight another day.
+func (gs *GameState) CommandRetreat(words []string) (ArmyMove, error) {
+	if gs.IsPaused() {
+		return ArmyMove{}, errors.New("the game is paused, you can not retreat units")
+	}
+	if len(words) < 3 {
+		return ArmyMove{}, errors.New("usage: retreat <from> <to>")
+	}
+	from := Location(words[1])
+	to := Location(words[2])
+	locations := getAllLocations()
+	for _, loc := range []Location{from, to} {
+		if _, ok := locations[loc]; !ok {
+			return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", loc)
+		}
+	}
+
+	isAdjacent := false
+	for _, loc := range getAdjacentLocations()[from] {
+		if loc == to {
+			isAdjacent = true
+		}
+	}
+	if !isAdjacent {
+		return ArmyMove{}, fmt.Errorf("error: %s is not adjacent to %s", to, from)
+	}
+	for username, units := range gs.GetSightingsSnap() {
+		if gs.isAtPeace(username) {
+			continue
+		}
+		for _, unit := range units {
+			if unit.Location == to {
+				return ArmyMove{}, fmt.Errorf("error: %s is held by %s, you can only retreat to friendly territory", to, username)
+			}
+		}
+	}
+
+	units := gs.getUnitsInLocation(from)
+	if len(units) == 0 {
+		return ArmyMove{}, fmt.Errorf("error: you have no units in %s", from)
+	}
+	for i := range units {
+		units[i].Location = to
+		gs.UpdateUnit(units[i])
+	}
+
+	mv := ArmyMove{
+		GameID:     gs.GetGameID(),
+		ToLocation: to,
+		Units:      units,
+		Username:   gs.GetUsername(),
+	}
+	fmt.Printf("Retreated %v units from %s to %s\n", len(mv.Units), from, mv.ToLocation)
+	return mv, nil
+}
@@ -0,0 +1,231 @@
+package gamelogic
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWarDamage(t *testing.T) {
+	tests := []struct {
+		name         string
+		winner       float64
+		loser        float64
+		winnerDamage int
+		loserDamage  int
+	}{
+		{"even", 10, 10, 40, 40},
+		{"twice as strong", 10, 5, 20, 100},
+		{"unopposed", 10, 0, 0, 160},
+		{"no power", 0, 0, 0, 0},
+		{"overwhelming", 20, 5, 10, 130},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			winnerDamage, loserDamage := warDamage(tt.winner, tt.loser)
+			if winnerDamage != tt.winnerDamage || loserDamage != tt.loserDamage {
+				t.Errorf("warDamage(%v, %v) = %v, %v, want %v, %v",
+					tt.winner, tt.loser, winnerDamage, loserDamage, tt.winnerDamage, tt.loserDamage)
+			}
+		})
+	}
+}
+
+func TestUnitPower(t *testing.T) {
+	tests := []struct {
+		name string
+		unit Unit
+		want float64
+	}{
+		{"full health", Unit{Rank: RankInfantry, Health: MaxUnitHealth}, 1},
+		{"half health", Unit{Rank: RankInfantry, Health: MaxUnitHealth / 2}, 0.5},
+		{"veteran", Unit{Rank: RankInfantry, Health: MaxUnitHealth, Experience: 3}, 1.25},
+		{"elite cap", Unit{Rank: RankInfantry, Health: MaxUnitHealth, Experience: 100}, 1.5},
+		{"artillery", Unit{Rank: RankArtillery, Health: MaxUnitHealth}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unitPower(tt.unit); got != tt.want {
+				t.Errorf("unitPower(%+v) = %v, want %v", tt.unit, got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestUnit(gs *GameState, id int, rank UnitRank, loc Location) Unit {
+	u := Unit{
+		ID:       id,
+		Owner:    gs.GetUsername(),
+		Rank:     rank,
+		Location: loc,
+		Health:   MaxUnitHealth,
+	}
+	gs.UpdateUnit(u)
+	return u
+}
+
+func TestTakeCasualties(t *testing.T) {
+	gs := NewGameState("alice")
+	newTestUnit(gs, 1, RankInfantry, "europe")
+	wounded := newTestUnit(gs, 2, RankInfantry, "europe")
+	wounded.Health = 30
+	gs.UpdateUnit(wounded)
+	newTestUnit(gs, 3, RankInfantry, "asia")
+
+	killed := gs.takeCasualties("europe", 40, winExperience, nil)
+	if len(killed) != 1 || killed[0].ID != 2 {
+		t.Fatalf("killed = %v, want unit 2", killed)
+	}
+	units := gs.GetPlayerSnap().Units
+	if got := units[1]; got.Health != 60 || got.Experience != winExperience {
+		t.Errorf("survivor = %+v, want 60 hp and %v experience", got, winExperience)
+	}
+	if _, ok := units[2]; ok {
+		t.Errorf("dead unit 2 was not removed")
+	}
+	if got := units[3]; got.Health != MaxUnitHealth || got.Experience != 0 {
+		t.Errorf("unit in another location was damaged: %+v", got)
+	}
+}
+
+func TestTakeCasualtiesOnlyFighters(t *testing.T) {
+	gs := NewGameState("alice")
+	fighter := newTestUnit(gs, 1, RankInfantry, "europe")
+	newTestUnit(gs, 2, RankInfantry, "europe")
+
+	gs.takeCasualties("europe", 50, lossExperience, map[int]Unit{1: fighter})
+	units := gs.GetPlayerSnap().Units
+	if got := units[1].Health; got != 50 {
+		t.Errorf("fighter health = %v, want 50", got)
+	}
+	if got := units[2]; got.Health != MaxUnitHealth || got.Experience != 0 {
+		t.Errorf("unit that arrived after the war was damaged: %+v", got)
+	}
+}
+
+func TestCommandPromote(t *testing.T) {
+	tests := []struct {
+		name    string
+		unit    Unit
+		words   []string
+		paused  bool
+		wantErr string
+	}{
+		{"usage", Unit{}, []string{"promote"}, false, "usage"},
+		{"bad id", Unit{}, []string{"promote", "one"}, false, "not a valid unit ID"},
+		{"missing", Unit{}, []string{"promote", "7"}, false, "not found"},
+		{"paused", Unit{ID: 1, Rank: RankInfantry, Experience: PromotionCost}, []string{"promote", "1"}, true, "paused"},
+		{"needs experience", Unit{ID: 1, Rank: RankInfantry, Experience: PromotionCost - 1}, []string{"promote", "1"}, false, "needs"},
+		{"top rank", Unit{ID: 1, Rank: RankArtillery, Experience: PromotionCost}, []string{"promote", "1"}, false, "any further"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := NewGameState("alice")
+			if tt.unit.ID != 0 {
+				tt.unit.Owner = "alice"
+				tt.unit.Location = "europe"
+				tt.unit.Health = MaxUnitHealth
+				gs.UpdateUnit(tt.unit)
+			}
+			if tt.paused {
+				gs.pauseGame()
+			}
+			err := gs.CommandPromote(tt.words)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CommandPromote(%v) = %v, want error containing %q", tt.words, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommandPromoteSpendsExperience(t *testing.T) {
+	gs := NewGameState("alice")
+	u := newTestUnit(gs, 1, RankInfantry, "europe")
+	u.Experience = PromotionCost + 1
+	gs.UpdateUnit(u)
+
+	if err := gs.CommandPromote([]string{"promote", "1"}); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	got, _ := gs.GetUnit(1)
+	if got.Rank != RankCavalry || got.Experience != 1 {
+		t.Errorf("promoted unit = %+v, want cavalry with 1 experience", got)
+	}
+}
+
+func TestCommandPromoteArmyCap(t *testing.T) {
+	rules := *DefaultRules()
+	rules.ArmyCap = 2
+	UseRules(&rules)
+	t.Cleanup(func() { UseRules(DefaultRules()) })
+
+	gs := NewGameState("alice")
+	u := newTestUnit(gs, 1, RankInfantry, "europe")
+	u.Experience = PromotionCost
+	gs.UpdateUnit(u)
+
+	err := gs.CommandPromote([]string{"promote", "1"})
+	if err == nil || !strings.Contains(err.Error(), "army cap") {
+		t.Fatalf("CommandPromote over the cap = %v, want army cap error", err)
+	}
+	if got, _ := gs.GetUnit(1); got.Rank != RankInfantry || got.Experience != PromotionCost {
+		t.Errorf("unit changed after a rejected promotion: %+v", got)
+	}
+}
+
+func TestCommandRetreat(t *testing.T) {
+	tests := []struct {
+		name     string
+		words    []string
+		enemy    Location
+		relation Relation
+		paused   bool
+		wantErr  string
+	}{
+		{"usage", []string{"retreat", "europe"}, "", RelationNone, false, "usage"},
+		{"invalid location", []string{"retreat", "europe", "mars"}, "", RelationNone, false, "not a valid location"},
+		{"not adjacent", []string{"retreat", "europe", "australia"}, "", RelationNone, false, "not adjacent"},
+		{"enemy held", []string{"retreat", "europe", "asia"}, "asia", RelationNone, false, "held by bob"},
+		{"no units", []string{"retreat", "africa", "europe"}, "", RelationNone, false, "no units"},
+		{"paused", []string{"retreat", "europe", "asia"}, "", RelationNone, true, "paused"},
+		{"held by a truce partner", []string{"retreat", "europe", "asia"}, "asia", RelationTruce, false, ""},
+		{"free", []string{"retreat", "europe", "asia"}, "", RelationNone, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := NewGameState("alice")
+			newTestUnit(gs, 1, RankInfantry, "europe")
+			newTestUnit(gs, 2, RankCavalry, "europe")
+			newTestUnit(gs, 3, RankInfantry, "americas")
+			if tt.enemy != "" {
+				gs.recordSighting("bob", []Unit{{ID: 1, Owner: "bob", Rank: RankInfantry, Location: tt.enemy, Health: MaxUnitHealth}})
+			}
+			gs.setRelation("bob", tt.relation)
+			if tt.paused {
+				gs.pauseGame()
+			}
+
+			mv, err := gs.CommandRetreat(tt.words)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("CommandRetreat(%v) = %v, want error containing %q", tt.words, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CommandRetreat(%v): %v", tt.words, err)
+			}
+			if len(mv.Units) != 2 || mv.ToLocation != "asia" {
+				t.Errorf("move = %+v, want 2 units to asia", mv)
+			}
+			for id, unit := range gs.GetPlayerSnap().Units {
+				want := Location("asia")
+				if id == 3 {
+					want = "americas"
+				}
+				if unit.Location != want {
+					t.Errorf("unit %v in %s, want %s", id, unit.Location, want)
+				}
+			}
+		})
+	}
+}
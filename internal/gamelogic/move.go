@@ -20,37 +20,37 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 
 	fmt.Println()
 	fmt.Println("==== Move Detected ====")
-	fmt.Printf("%s is moving %v unit(s) to %s\n", move.Player.Username, len(move.Units), move.ToLocation)
+	if player.Username != move.Username && !gs.IsVisible(move.ToLocation) {
+		// The units left our sight (if we ever saw them) and went somewhere we can't see
+		gs.forgetSighting(move.Username, move.Units)
+		fmt.Printf("%s moved units somewhere beyond your sight.\n", move.Username)
+		return MoveOutComeSafe
+	}
+
+	fmt.Printf("%s is moving %v unit(s) to %s\n", move.Username, len(move.Units), move.ToLocation)
 	for _, unit := range move.Units {
-		fmt.Printf("* %v\n", unit.Rank)
+		fmt.Printf("* %v (%s)\n", unit.Rank, unit.GlobalID())
 	}
 
-	if player.Username == move.Player.Username {
+	if player.Username == move.Username {
 		return MoveOutcomeSamePlayer
 	}
+	gs.recordSighting(move.Username, move.Units)
 
-	overlappingLocation := getOverlappingLocation(player, move.Player)
-	if overlappingLocation != "" {
-		fmt.Printf("You have units in %s! You are at war with %s!\n", overlappingLocation, move.Player.Username)
+	if len(gs.getUnitsInLocation(move.ToLocation)) > 0 {
+		if gs.isAtPeace(move.Username) {
+			fmt.Printf("You have a %s with %s, your units share %s in peace.\n", gs.GetRelation(move.Username), move.Username, move.ToLocation)
+			return MoveOutComeSafe
+		}
+		fmt.Printf("You have units in %s! You are at war with %s!\n", move.ToLocation, move.Username)
 		return MoveOutcomeMakeWar
 	}
-	fmt.Printf("You are safe from %s's units.\n", move.Player.Username)
+	fmt.Printf("You are safe from %s's units.\n", move.Username)
 	return MoveOutComeSafe
 }
 
-func getOverlappingLocation(p1 Player, p2 Player) Location {
-	for _, u1 := range p1.Units {
-		for _, u2 := range p2.Units {
-			if u1.Location == u2.Location {
-				return u1.Location
-			}
-		}
-	}
-	return ""
-}
-
 func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
-	if gs.isPaused() {
+	if gs.IsPaused() {
 		return ArmyMove{}, errors.New("the game is paused, you can not move units")
 	}
 	if len(words) < 3 {
@@ -61,6 +61,16 @@ func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 	if _, ok := locations[newLocation]; !ok {
 		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
 	}
+	for username, units := range gs.GetSightingsSnap() {
+		if !gs.IsFrozen(username) {
+			continue
+		}
+		for _, unit := range units {
+			if unit.Location == newLocation {
+				return ArmyMove{}, fmt.Errorf("error: %s's units in %s are frozen until they come back", username, newLocation)
+			}
+		}
+	}
 	unitIDs := []int{}
 	for _, word := range words[2:] {
 		id := word
@@ -71,21 +81,34 @@ func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 		unitIDs = append(unitIDs, unitID)
 	}
 
-	newUnits := []Unit{}
 	for _, unitID := range unitIDs {
 		unit, ok := gs.GetUnit(unitID)
 		if !ok {
 			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
 		}
+		unitType, _ := CurrentRules().UnitType(unit.Rank)
+		distance := CurrentRules().distance(unit.Location, newLocation)
+		if distance < 0 {
+			return ArmyMove{}, fmt.Errorf("error: unit %v can not reach %s from %s", unitID, newLocation, unit.Location)
+		}
+		if unitType.Movement > 0 && distance > unitType.Movement {
+			return ArmyMove{}, fmt.Errorf("error: unit %v can only move %v territories at a time", unitID, unitType.Movement)
+		}
+	}
+
+	newUnits := []Unit{}
+	for _, unitID := range unitIDs {
+		unit, _ := gs.GetUnit(unitID)
 		unit.Location = newLocation
 		gs.UpdateUnit(unit)
 		newUnits = append(newUnits, unit)
 	}
 
 	mv := ArmyMove{
+		GameID:     gs.GetGameID(),
 		ToLocation: newLocation,
 		Units:      newUnits,
-		Player:     gs.GetPlayerSnap(),
+		Username:   gs.GetUsername(),
 	}
 	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
 	return mv, nil
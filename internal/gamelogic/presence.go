@@ -0,0 +1,175 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+// Heartbeat tells the servers that a player is still connected. Anyone can
+// listen in, so it doesn't carry the army; see ArmyReport.
+type Heartbeat struct {
+	CurrentTime time.Time
+	GameID      string
+	Username    string
+	Leaving     bool
+}
+
+func (hb Heartbeat) Sender() string {
+	return hb.Username
+}
+
+// ArmyReport sends the player's army to the server they logged in with
+// alone, so it can hand the army to a bot if the player times out.
+type ArmyReport struct {
+	GameID   string
+	Username string
+	Units    []Unit
+}
+
+func (ar ArmyReport) Sender() string {
+	return ar.Username
+}
+
+// BotHandoff gives the army of a timed out player to a bot.
+type BotHandoff struct {
+	GameID   string
+	Username string
+	Units    []Unit
+}
+
+func (gs *GameState) NewHeartbeat(leaving bool) Heartbeat {
+	return Heartbeat{
+		CurrentTime: time.Now(),
+		GameID:      gs.GetGameID(),
+		Username:    gs.GetUsername(),
+		Leaving:     leaving,
+	}
+}
+
+func (gs *GameState) NewArmyReport() ArmyReport {
+	return ArmyReport{
+		GameID:   gs.GetGameID(),
+		Username: gs.GetUsername(),
+		Units:    gs.getUnitsSnap(),
+	}
+}
+
+// AdoptUnits takes over the army of a timed out player, giving the units
+// new IDs. The army cap doesn't apply, the units already exist.
+func (gs *GameState) AdoptUnits(handoff BotHandoff) []Unit {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	adopted := []Unit{}
+	for _, unit := range handoff.Units {
+		unit.ID = gs.NextUnitID
+		unit.Owner = gs.Player.Username
+		gs.NextUnitID++
+		gs.Player.Units[unit.ID] = unit
+		adopted = append(adopted, unit)
+	}
+	return adopted
+}
+
+// IsFrozen reports whether a player timed out under the freeze policy.
+// Their units hold their ground until they come back, and can't be
+// attacked in the meantime.
+func (gs *GameState) IsFrozen(username string) bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.frozen[username]
+}
+
+// HandlePresence reports players joining and leaving the game. Players that
+// forfeit are forgotten along with their units and agreements. Every server
+// announces presence changes, so repeated events are ignored; the result
+// reports whether the event was news.
+func (gs *GameState) HandlePresence(ev routing.PresenceEvent) bool {
+	if ev.Username == gs.GetUsername() {
+		if ev.Status != routing.PresenceTimedOut || ev.Policy != routing.TimeoutBot {
+			return false
+		}
+		// We are still here, but a bot has our army now
+		gs.mu.Lock()
+		gs.Player.Units = map[int]Unit{}
+		gs.mu.Unlock()
+		fmt.Println()
+		fmt.Println("You timed out, and a bot took over your units.")
+		return true
+	}
+	gs.mu.Lock()
+	last := gs.Presence[ev.Username]
+	gs.Presence[ev.Username] = ev.Status
+	frozen := ev.Status == routing.PresenceTimedOut && ev.Policy == routing.TimeoutFreeze
+	if frozen {
+		gs.frozen[ev.Username] = true
+	} else {
+		delete(gs.frozen, ev.Username)
+	}
+	gs.mu.Unlock()
+	if last == ev.Status {
+		return false
+	}
+
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Presence ====")
+	switch ev.Status {
+	case routing.PresenceJoined:
+		fmt.Printf("%s joined the game.\n", ev.Username)
+		return true
+	case routing.PresenceLeft:
+		fmt.Printf("%s left the game.\n", ev.Username)
+	case routing.PresenceTimedOut:
+		fmt.Printf("%s lost their connection.\n", ev.Username)
+		switch ev.Policy {
+		case routing.TimeoutFreeze:
+			fmt.Printf("%s's units are frozen until they come back.\n", ev.Username)
+			return true
+		case routing.TimeoutBot:
+			// The bot's units show up as the bot's once it moves them
+			gs.mu.Lock()
+			delete(gs.Sightings, ev.Username)
+			gs.mu.Unlock()
+			fmt.Printf("A bot is taking over %s's units.\n", ev.Username)
+			return true
+		}
+	}
+
+	gs.mu.Lock()
+	delete(gs.Sightings, ev.Username)
+	delete(gs.Relations, ev.Username)
+	delete(gs.truceEnds, ev.Username)
+	delete(gs.Proposals, ev.Username)
+	delete(gs.Offers, ev.Username)
+	gs.mu.Unlock()
+	fmt.Printf("%s forfeits, their units are removed from the board.\n", ev.Username)
+	return true
+}
+
+// GetKnownPlayers returns the other players we have heard from, sorted.
+func (gs *GameState) GetKnownPlayers() []string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	known := map[string]struct{}{}
+	for username := range gs.Presence {
+		known[username] = struct{}{}
+	}
+	for username := range gs.Sightings {
+		known[username] = struct{}{}
+	}
+	for username := range gs.Relations {
+		known[username] = struct{}{}
+	}
+	for username := range gs.Proposals {
+		known[username] = struct{}{}
+	}
+	players := []string{}
+	for username := range known {
+		players = append(players, username)
+	}
+	sort.Strings(players)
+	return players
+}
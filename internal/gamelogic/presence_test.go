@@ -0,0 +1,69 @@
+package gamelogic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+func TestFrozenUnitsCanNotBeAttacked(t *testing.T) {
+	gs := newTestPlayer("alice", "europe", RankInfantry)
+	gs.recordSighting("bob", []Unit{{ID: 1, Owner: "bob", Rank: RankInfantry, Location: "asia", Health: MaxUnitHealth}})
+	gs.HandlePresence(routing.PresenceEvent{GameID: "g1", Username: "bob", Status: routing.PresenceTimedOut, Policy: routing.TimeoutFreeze})
+	if !gs.IsFrozen("bob") {
+		t.Fatalf("bob is not frozen after timing out")
+	}
+	_, err := gs.CommandMove([]string{"move", "asia", "1"})
+	if err == nil || !strings.Contains(err.Error(), "frozen") {
+		t.Fatalf("CommandMove into frozen units = %v, want frozen error", err)
+	}
+
+	gs.HandlePresence(routing.PresenceEvent{GameID: "g1", Username: "bob", Status: routing.PresenceJoined})
+	if gs.IsFrozen("bob") {
+		t.Fatalf("bob is still frozen after coming back")
+	}
+	if _, err := gs.CommandMove([]string{"move", "asia", "1"}); err != nil {
+		t.Errorf("CommandMove after bob came back: %v", err)
+	}
+}
+
+func TestBotTimeout(t *testing.T) {
+	gs := newTestPlayer("alice", "europe", RankInfantry)
+	gs.recordSighting("bob", []Unit{{ID: 1, Owner: "bob", Rank: RankInfantry, Location: "asia", Health: MaxUnitHealth}})
+	gs.HandlePresence(routing.PresenceEvent{GameID: "g1", Username: "bob", Status: routing.PresenceTimedOut, Policy: routing.TimeoutBot})
+	if _, ok := gs.GetSightingsSnap()["bob"]; ok {
+		t.Errorf("bob's units are still sighted after a bot took them")
+	}
+
+	// The timed out player's own client drops the army it no longer has
+	gs.HandlePresence(routing.PresenceEvent{GameID: "g1", Username: "alice", Status: routing.PresenceTimedOut, Policy: routing.TimeoutBot})
+	if units := gs.GetPlayerSnap().Units; len(units) != 0 {
+		t.Errorf("alice still has %d units after a bot took them", len(units))
+	}
+}
+
+func TestAdoptUnits(t *testing.T) {
+	bot := newTestPlayer("bot1", "asia", RankInfantry)
+	adopted := bot.AdoptUnits(BotHandoff{
+		GameID:   "g1",
+		Username: "bob",
+		Units: []Unit{
+			{ID: 1, Owner: "bob", Rank: RankCavalry, Location: "europe", Health: 40, Experience: 2},
+			{ID: 7, Owner: "bob", Rank: RankArtillery, Location: "asia", Health: MaxUnitHealth},
+		},
+	})
+	if len(adopted) != 2 || adopted[0].ID != 2 || adopted[1].ID != 3 {
+		t.Fatalf("adopted = %+v, want IDs 2 and 3", adopted)
+	}
+	units := bot.GetPlayerSnap().Units
+	if len(units) != 3 {
+		t.Fatalf("bot has %d units, want 3", len(units))
+	}
+	if got := units[2]; got.Owner != "bot1" || got.Rank != RankCavalry || got.Health != 40 || got.Experience != 2 || got.Location != "europe" {
+		t.Errorf("adopted unit = %+v, want bob's cavalry owned by bot1", got)
+	}
+	if bot.NextUnitID != 4 {
+		t.Errorf("NextUnitID = %d, want 4", bot.NextUnitID)
+	}
+}
@@ -0,0 +1,294 @@
+package gamelogic
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Ability string
+
+const (
+	// AbilityScout lets a unit see two territories away instead of one
+	AbilityScout Ability = "scout"
+	// AbilityFortify gives a unit 50% more power when it is defending
+	AbilityFortify Ability = "fortify"
+)
+
+func getAllAbilities() map[Ability]struct{} {
+	return map[Ability]struct{}{
+		AbilityScout:   {},
+		AbilityFortify: {},
+	}
+}
+
+type UnitType struct {
+	Rank  UnitRank `json:"rank" yaml:"rank"`
+	Power float64  `json:"power" yaml:"power"`
+	// Cost counts against the army cap
+	Cost int `json:"cost" yaml:"cost"`
+	// Movement is how many territories the unit can cross in one move,
+	// 0 means unlimited
+	Movement   int       `json:"movement" yaml:"movement"`
+	Abilities  []Ability `json:"abilities" yaml:"abilities"`
+	PromotesTo UnitRank  `json:"promotes_to" yaml:"promotes_to"`
+}
+
+func (ut UnitType) HasAbility(a Ability) bool {
+	for _, ability := range ut.Abilities {
+		if ability == a {
+			return true
+		}
+	}
+	return false
+}
+
+type Territory struct {
+	Name     Location   `json:"name" yaml:"name"`
+	Adjacent []Location `json:"adjacent" yaml:"adjacent"`
+}
+
+// Continent grants its bonus to the army cap of a player holding every one
+// of its territories.
+type Continent struct {
+	Name        string     `json:"name" yaml:"name"`
+	Territories []Location `json:"territories" yaml:"territories"`
+	Bonus       int        `json:"bonus" yaml:"bonus"`
+}
+
+type GameMap struct {
+	Territories []Territory `json:"territories" yaml:"territories"`
+	Continents  []Continent `json:"continents" yaml:"continents"`
+}
+
+// Rules describe a scenario: the unit types players can spawn and the map
+// they fight over.
+type Rules struct {
+	Name string `json:"name" yaml:"name"`
+	// ArmyCap limits the total cost of a player's living units, 0 means
+	// unlimited
+	ArmyCap int        `json:"army_cap" yaml:"army_cap"`
+	Units   []UnitType `json:"units" yaml:"units"`
+	Map     GameMap    `json:"map" yaml:"map"`
+}
+
+//go:embed default_rules.yaml
+var defaultRulesData []byte
+
+var activeRules atomic.Pointer[Rules]
+
+func init() {
+	rules, err := parseRules(defaultRulesData, ".yaml")
+	if err != nil {
+		panic(fmt.Sprintf("invalid default rules: %v", err))
+	}
+	activeRules.Store(rules)
+}
+
+// DefaultRules returns the built-in classic scenario.
+func DefaultRules() *Rules {
+	rules, _ := parseRules(defaultRulesData, ".yaml")
+	return rules
+}
+
+// LoadRules reads and validates a rules file. The format is picked by the
+// file extension: .yaml, .yml or .json.
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read rules file: %v", err)
+	}
+	rules, err := parseRules(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// UseRules replaces the rules used by every game state in the process. It
+// must be called before the game starts.
+func UseRules(rules *Rules) {
+	activeRules.Store(rules)
+}
+
+func CurrentRules() *Rules {
+	return activeRules.Load()
+}
+
+func parseRules(data []byte, ext string) (*Rules, error) {
+	rules := &Rules{}
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, rules); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.Unmarshal(data, rules); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported rules format %q", ext)
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *Rules) Validate() error {
+	var errs []error
+	if r.ArmyCap < 0 {
+		errs = append(errs, errors.New("army_cap must not be negative"))
+	}
+
+	if len(r.Units) == 0 {
+		errs = append(errs, errors.New("at least one unit type is required"))
+	}
+	ranks := map[UnitRank]struct{}{}
+	abilities := getAllAbilities()
+	for _, ut := range r.Units {
+		if !isValidName(string(ut.Rank)) {
+			errs = append(errs, fmt.Errorf("unit rank %q must be a single word", ut.Rank))
+		}
+		if _, ok := ranks[ut.Rank]; ok {
+			errs = append(errs, fmt.Errorf("unit rank %q is defined twice", ut.Rank))
+		}
+		ranks[ut.Rank] = struct{}{}
+		if ut.Power <= 0 {
+			errs = append(errs, fmt.Errorf("unit %s: power must be positive", ut.Rank))
+		}
+		if ut.Cost < 0 {
+			errs = append(errs, fmt.Errorf("unit %s: cost must not be negative", ut.Rank))
+		}
+		if ut.Movement < 0 {
+			errs = append(errs, fmt.Errorf("unit %s: movement must not be negative", ut.Rank))
+		}
+		for _, ability := range ut.Abilities {
+			if _, ok := abilities[ability]; !ok {
+				errs = append(errs, fmt.Errorf("unit %s: unknown ability %q", ut.Rank, ability))
+			}
+		}
+	}
+	for _, ut := range r.Units {
+		if ut.PromotesTo == "" {
+			continue
+		}
+		if _, ok := ranks[ut.PromotesTo]; !ok || ut.PromotesTo == ut.Rank {
+			errs = append(errs, fmt.Errorf("unit %s: invalid promotion to %q", ut.Rank, ut.PromotesTo))
+		}
+	}
+
+	if len(r.Map.Territories) == 0 {
+		errs = append(errs, errors.New("the map needs at least one territory"))
+	}
+	adjacency := map[Location]map[Location]struct{}{}
+	for _, t := range r.Map.Territories {
+		if !isValidName(string(t.Name)) {
+			errs = append(errs, fmt.Errorf("territory name %q must be a single word", t.Name))
+		}
+		if _, ok := adjacency[t.Name]; ok {
+			errs = append(errs, fmt.Errorf("territory %q is defined twice", t.Name))
+		}
+		adjacency[t.Name] = map[Location]struct{}{}
+		for _, loc := range t.Adjacent {
+			adjacency[t.Name][loc] = struct{}{}
+		}
+	}
+	for name, neighbours := range adjacency {
+		for loc := range neighbours {
+			if loc == name {
+				errs = append(errs, fmt.Errorf("territory %s is adjacent to itself", name))
+				continue
+			}
+			back, ok := adjacency[loc]
+			if !ok {
+				errs = append(errs, fmt.Errorf("territory %s: unknown adjacent territory %q", name, loc))
+				continue
+			}
+			if _, ok := back[name]; !ok {
+				errs = append(errs, fmt.Errorf("territory %s is adjacent to %s but not the other way around", name, loc))
+			}
+		}
+	}
+
+	continents := map[string]struct{}{}
+	owners := map[Location]string{}
+	for _, c := range r.Map.Continents {
+		if c.Name == "" {
+			errs = append(errs, errors.New("continent name must not be empty"))
+		}
+		if _, ok := continents[c.Name]; ok {
+			errs = append(errs, fmt.Errorf("continent %q is defined twice", c.Name))
+		}
+		continents[c.Name] = struct{}{}
+		if c.Bonus < 0 {
+			errs = append(errs, fmt.Errorf("continent %s: bonus must not be negative", c.Name))
+		}
+		if len(c.Territories) == 0 {
+			errs = append(errs, fmt.Errorf("continent %s has no territories", c.Name))
+		}
+		for _, loc := range c.Territories {
+			if _, ok := adjacency[loc]; !ok {
+				errs = append(errs, fmt.Errorf("continent %s: unknown territory %q", c.Name, loc))
+			}
+			if owner, ok := owners[loc]; ok {
+				errs = append(errs, fmt.Errorf("territory %s belongs to both %s and %s", loc, owner, c.Name))
+			}
+			owners[loc] = c.Name
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// isValidName rejects names that can't be typed as a single command word
+// or that would break routing keys.
+func isValidName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\n.*#")
+}
+
+func (r *Rules) UnitType(rank UnitRank) (UnitType, bool) {
+	for _, ut := range r.Units {
+		if ut.Rank == rank {
+			return ut, true
+		}
+	}
+	return UnitType{}, false
+}
+
+func (r *Rules) adjacency() map[Location][]Location {
+	adjacent := map[Location][]Location{}
+	for _, t := range r.Map.Territories {
+		adjacent[t.Name] = t.Adjacent
+	}
+	return adjacent
+}
+
+// distance returns the number of territories crossed on the shortest path
+// between two locations, or -1 if there is no path.
+func (r *Rules) distance(from, to Location) int {
+	adjacent := r.adjacency()
+	dist := map[Location]int{from: 0}
+	queue := []Location{from}
+	for len(queue) > 0 {
+		loc := queue[0]
+		queue = queue[1:]
+		if loc == to {
+			return dist[loc]
+		}
+		for _, next := range adjacent[loc] {
+			if _, seen := dist[next]; !seen {
+				dist[next] = dist[loc] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+	return -1
+}
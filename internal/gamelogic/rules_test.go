@@ -0,0 +1,117 @@
+package gamelogic
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func validRules() *Rules {
+	return &Rules{
+		Name: "test",
+		Units: []UnitType{
+			{Rank: "soldier", Power: 1, Cost: 1, PromotesTo: "knight"},
+			{Rank: "knight", Power: 3, Cost: 2, Abilities: []Ability{AbilityScout}},
+		},
+		Map: GameMap{
+			Territories: []Territory{
+				{Name: "north", Adjacent: []Location{"south"}},
+				{Name: "south", Adjacent: []Location{"north"}},
+			},
+			Continents: []Continent{
+				{Name: "land", Territories: []Location{"north", "south"}, Bonus: 2},
+			},
+		},
+	}
+}
+
+func TestRulesValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(r *Rules)
+		wantErr string
+	}{
+		{"valid", func(r *Rules) {}, ""},
+		{"negative army cap", func(r *Rules) { r.ArmyCap = -1 }, "army_cap must not be negative"},
+		{"no units", func(r *Rules) { r.Units = nil }, "at least one unit type"},
+		{"rank with a dot", func(r *Rules) { r.Units[1].Rank = "k.night"; r.Units[0].PromotesTo = "" }, "must be a single word"},
+		{"empty rank", func(r *Rules) { r.Units[1].Rank = ""; r.Units[0].PromotesTo = "" }, "must be a single word"},
+		{"duplicate rank", func(r *Rules) { r.Units[1].Rank = "soldier"; r.Units[0].PromotesTo = "" }, "defined twice"},
+		{"zero power", func(r *Rules) { r.Units[0].Power = 0 }, "power must be positive"},
+		{"negative cost", func(r *Rules) { r.Units[0].Cost = -1 }, "cost must not be negative"},
+		{"negative movement", func(r *Rules) { r.Units[0].Movement = -1 }, "movement must not be negative"},
+		{"unknown ability", func(r *Rules) { r.Units[0].Abilities = []Ability{"fly"} }, "unknown ability"},
+		{"unknown promotion", func(r *Rules) { r.Units[0].PromotesTo = "king" }, "invalid promotion"},
+		{"promotes to itself", func(r *Rules) { r.Units[0].PromotesTo = "soldier" }, "invalid promotion"},
+		{"no territories", func(r *Rules) { r.Map.Territories = nil; r.Map.Continents = nil }, "at least one territory"},
+		{"territory with a space", func(r *Rules) {
+			r.Map.Territories = append(r.Map.Territories, Territory{Name: "far east"})
+		}, "must be a single word"},
+		{"duplicate territory", func(r *Rules) {
+			r.Map.Territories = append(r.Map.Territories, Territory{Name: "north", Adjacent: []Location{"south"}})
+		}, "defined twice"},
+		{"adjacent to itself", func(r *Rules) {
+			r.Map.Territories[0].Adjacent = append(r.Map.Territories[0].Adjacent, "north")
+		}, "adjacent to itself"},
+		{"unknown adjacent", func(r *Rules) {
+			r.Map.Territories[0].Adjacent = append(r.Map.Territories[0].Adjacent, "west")
+		}, "unknown adjacent territory"},
+		{"one way adjacency", func(r *Rules) { r.Map.Territories[1].Adjacent = nil }, "not the other way around"},
+		{"unnamed continent", func(r *Rules) { r.Map.Continents[0].Name = "" }, "continent name must not be empty"},
+		{"duplicate continent", func(r *Rules) {
+			r.Map.Continents = append(r.Map.Continents, Continent{Name: "land", Territories: []Location{}})
+		}, "defined twice"},
+		{"negative bonus", func(r *Rules) { r.Map.Continents[0].Bonus = -1 }, "bonus must not be negative"},
+		{"empty continent", func(r *Rules) { r.Map.Continents[0].Territories = nil }, "has no territories"},
+		{"unknown continent territory", func(r *Rules) {
+			r.Map.Continents[0].Territories = append(r.Map.Continents[0].Territories, "west")
+		}, "unknown territory"},
+		{"territory in two continents", func(r *Rules) {
+			r.Map.Continents = append(r.Map.Continents, Continent{Name: "sea", Territories: []Location{"south"}})
+		}, "belongs to both"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := validRules()
+			tt.change(r)
+			err := r.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBundledRules(t *testing.T) {
+	if err := DefaultRules().Validate(); err != nil {
+		t.Errorf("default rules: %v", err)
+	}
+	paths, err := filepath.Glob(filepath.Join("..", "..", "rules", "*.yaml"))
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no bundled rules found")
+	}
+	for _, path := range paths {
+		if _, err := LoadRules(path); err != nil {
+			t.Errorf("LoadRules(%s): %v", path, err)
+		}
+	}
+}
+
+func TestLoadRulesUnsupportedFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.toml")
+	if _, err := LoadRules(path); err == nil {
+		t.Errorf("LoadRules(%s) = nil, want error", path)
+	}
+	if _, err := parseRules([]byte("name: x"), ".toml"); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("parseRules(.toml) = %v, want unsupported format error", err)
+	}
+}
@@ -22,13 +22,95 @@ func (gs *GameState) CommandSpawn(words []string) error {
 		return fmt.Errorf("error: %s is not a valid unit", rank)
 	}
 
-	id := len(gs.getUnitsSnap()) + 1
+	unitType, _ := CurrentRules().UnitType(UnitRank(rank))
+	err := gs.checkArmyCap(unitType.Cost)
+	if err != nil {
+		return err
+	}
+
+	id := gs.allocateUnitID()
 	gs.addUnit(Unit{
 		ID:       id,
+		Owner:    gs.GetUsername(),
 		Rank:     UnitRank(rank),
 		Location: Location(locationName),
+		Health:   MaxUnitHealth,
 	})
 
 	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
 	return nil
 }
+
+// armyCap is the rules' army cap plus the bonus of every continent the
+// player holds entirely. 0 means unlimited.
+func (gs *GameState) armyCap() int {
+	rules := CurrentRules()
+	if rules.ArmyCap == 0 {
+		return 0
+	}
+	held := map[Location]struct{}{}
+	for _, unit := range gs.getUnitsSnap() {
+		held[unit.Location] = struct{}{}
+	}
+	armyCap := rules.ArmyCap
+	for _, continent := range rules.Map.Continents {
+		holdsAll := true
+		for _, loc := range continent.Territories {
+			if _, ok := held[loc]; !ok {
+				holdsAll = false
+				break
+			}
+		}
+		if holdsAll {
+			armyCap += continent.Bonus
+		}
+	}
+	return armyCap
+}
+
+func (gs *GameState) armyCost() int {
+	return armyCost(gs.getUnitsSnap())
+}
+
+func armyCost(units []Unit) int {
+	cost := 0
+	for _, unit := range units {
+		unitType, _ := CurrentRules().UnitType(unit.Rank)
+		cost += unitType.Cost
+	}
+	return cost
+}
+
+// CheckArmy checks an army someone reported against the rules: its units
+// must have known ranks, and can't cost more than the army cap with the
+// bonus of every continent.
+func CheckArmy(units []Unit) error {
+	rules := CurrentRules()
+	for _, unit := range units {
+		if _, ok := rules.UnitType(unit.Rank); !ok {
+			return fmt.Errorf("unknown rank %q", unit.Rank)
+		}
+	}
+	if rules.ArmyCap == 0 {
+		return nil
+	}
+	maxCap := rules.ArmyCap
+	for _, continent := range rules.Map.Continents {
+		maxCap += continent.Bonus
+	}
+	if cost := armyCost(units); cost > maxCap {
+		return fmt.Errorf("the army costs %v, more than any army cap of %v", cost, maxCap)
+	}
+	return nil
+}
+
+func (gs *GameState) checkArmyCap(extraCost int) error {
+	armyCap := gs.armyCap()
+	if armyCap == 0 {
+		return nil
+	}
+	if cost := gs.armyCost(); cost+extraCost > armyCap {
+		return fmt.Errorf("error: your army would cost %v, but your army cap is %v", cost+extraCost, armyCap)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package gamelogic
+
+import "testing"
+
+func TestCommandSpawnNoOverwriteAfterRemoval(t *testing.T) {
+	gs := NewGameState("alice")
+	for _, words := range [][]string{
+		{"spawn", "europe", "infantry"},
+		{"spawn", "asia", "cavalry"},
+		{"spawn", "europe", "artillery"},
+	} {
+		if err := gs.CommandSpawn(words); err != nil {
+			t.Fatalf("spawn %v: %v", words, err)
+		}
+	}
+
+	gs.removeUnitsInLocation("europe")
+	if err := gs.CommandSpawn([]string{"spawn", "africa", "infantry"}); err != nil {
+		t.Fatalf("spawn after removal: %v", err)
+	}
+
+	units := gs.GetPlayerSnap().Units
+	if len(units) != 2 {
+		t.Fatalf("expected 2 units, got %d: %v", len(units), units)
+	}
+	survivor, ok := units[2]
+	if !ok || survivor.Location != "asia" || survivor.Rank != RankCavalry {
+		t.Errorf("unit 2 was overwritten: %+v", survivor)
+	}
+	spawned, ok := units[4]
+	if !ok || spawned.Location != "africa" {
+		t.Errorf("expected new unit with ID 4 in africa, got %v", units)
+	}
+}
+
+// spawnedIDs spawns n units in a location and returns their IDs, removing
+// every unit there after each third spawn.
+func spawnedIDs(t *testing.T, gs *GameState, n int) []int {
+	t.Helper()
+	ids := []int{}
+	for i := 0; i < n; i++ {
+		before := gs.GetPlayerSnap().Units
+		if err := gs.CommandSpawn([]string{"spawn", "americas", "infantry"}); err != nil {
+			t.Fatalf("spawn: %v", err)
+		}
+		for id := range gs.GetPlayerSnap().Units {
+			if _, ok := before[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		if i%3 == 1 {
+			gs.removeUnitsInLocation("americas")
+		}
+	}
+	return ids
+}
+
+func TestCommandSpawnIDsAreUnique(t *testing.T) {
+	alice := NewGameState("alice")
+	bob := NewGameState("bob")
+	aliceIDs := spawnedIDs(t, alice, 10)
+	bobIDs := spawnedIDs(t, bob, 10)
+	if len(aliceIDs) != 10 {
+		t.Fatalf("got %d new IDs for 10 spawns: %v", len(aliceIDs), aliceIDs)
+	}
+
+	// IDs of dead units are never handed out again
+	seen := map[int]struct{}{}
+	for _, id := range aliceIDs {
+		if _, dup := seen[id]; dup {
+			t.Errorf("ID %d was handed out twice: %v", id, aliceIDs)
+		}
+		seen[id] = struct{}{}
+	}
+
+	// Both players number their units alike, their global IDs tell them apart
+	global := map[string]struct{}{}
+	for i := range aliceIDs {
+		for _, u := range []Unit{{ID: aliceIDs[i], Owner: "alice"}, {ID: bobIDs[i], Owner: "bob"}} {
+			if _, dup := global[u.GlobalID()]; dup {
+				t.Errorf("duplicate global ID %s", u.GlobalID())
+			}
+			global[u.GlobalID()] = struct{}{}
+		}
+	}
+	if alice.NextUnitID != 11 {
+		t.Errorf("expected next ID 11, got %d", alice.NextUnitID)
+	}
+}
+
+func TestUnitGlobalID(t *testing.T) {
+	u := Unit{ID: 3, Owner: "alice"}
+	if got := u.GlobalID(); got != "alice:3" {
+		t.Errorf("GlobalID() = %q, want %q", got, "alice:3")
+	}
+}
+
+func TestCheckArmy(t *testing.T) {
+	rules := *DefaultRules()
+	rules.ArmyCap = 2
+	rules.Map.Continents = []Continent{{Name: "eurasia", Territories: []Location{"europe", "asia"}, Bonus: 1}}
+	UseRules(&rules)
+	t.Cleanup(func() { UseRules(DefaultRules()) })
+
+	infantry := Unit{ID: 1, Owner: "alice", Rank: RankInfantry, Location: "europe", Health: MaxUnitHealth}
+	tests := []struct {
+		name  string
+		units []Unit
+		ok    bool
+	}{
+		{"empty", nil, true},
+		{"within the cap with every bonus", []Unit{infantry, infantry, infantry}, true},
+		{"over the cap with every bonus", []Unit{infantry, infantry, infantry, infantry}, false},
+		{"unknown rank", []Unit{{ID: 1, Rank: "dragon"}}, false},
+	}
+	for _, tt := range tests {
+		if err := CheckArmy(tt.units); (err == nil) != tt.ok {
+			t.Errorf("%s: CheckArmy() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
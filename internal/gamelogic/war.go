@@ -1,7 +1,14 @@
 package gamelogic
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
 )
 
 type WarOutcome int
@@ -12,94 +19,596 @@ const (
 	WarOutcomeYouWon
 	WarOutcomeOpponentWon
 	WarOutcomeDraw
+	// WarOutcomeGathering means the war waits for the defender's allies
+	WarOutcomeGathering
 )
 
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
+const (
+	// WarTimeout is how long a recognition waits on the broker for the
+	// attacker to get it
+	WarTimeout = 30 * time.Second
+	// warResultGrace is how long the defender waits past the timeout for
+	// the attacker to fight a war it just got, and for its result
+	warResultGrace = 10 * time.Second
+	// foughtWarMemory is how long the attacker remembers a war, well past
+	// any redelivery of its recognition
+	foughtWarMemory = 10 * time.Minute
+	// allyGatherWindow is how long the attacker waits for the recognitions
+	// of the defender's allies before fighting
+	allyGatherWindow = 2 * time.Second
+)
+
+// pendingWar is a war we defend, and when we sent its recognition.
+type pendingWar struct {
+	rw   RecognitionOfWar
+	sent time.Time
+}
+
+type foughtWar struct {
+	at      time.Time
+	outcome WarOutcome
+	result  WarResult
+}
+
+// warGroup is a war waiting for the defender's allies to join it.
+type warGroup struct {
+	received time.Time
+	lead     RecognitionOfWar
+	allies   []RecognitionOfWar
+}
+
+func (g *warGroup) has(warID string) bool {
+	if g.lead.WarID == warID {
+		return true
+	}
+	for _, ally := range g.allies {
+		if ally.WarID == warID {
+			return true
+		}
+	}
+	return false
+}
+
+// accepts reports whether the recognition comes from an ally of the lead
+// defender in the same war. Both have to name each other, so a defender
+// can't make up allies, and each ally only adds the units it revealed
+// itself.
+func (g *warGroup) accepts(rw RecognitionOfWar) bool {
+	if rw.Location != g.lead.Location || rw.GameID != g.lead.GameID || rw.Defender.Username == g.lead.Defender.Username {
+		return false
+	}
+	for _, ally := range g.allies {
+		if ally.Defender.Username == rw.Defender.Username {
+			return false
+		}
+	}
+	return slices.Contains(g.lead.Allies, rw.Defender.Username) && slices.Contains(rw.Allies, g.lead.Defender.Username)
+}
+
+// WarAnswer is a war result addressed to one of the players who defended.
+type WarAnswer struct {
+	To     string
+	Result WarResult
+}
+
+// Key routes the answer: the defender who led the defense gets it on
+// war_results, its allies on war_allies, which the servers don't read.
+func (wa WarAnswer) Key() string {
+	prefix := routing.WarResultsPrefix
+	if wa.To != wa.Result.Defender.Username {
+		prefix = routing.WarAlliesPrefix
+	}
+	return routing.Key(prefix, wa.Result.GameID, wa.To, wa.Result.Attacker.Username)
+}
+
+func newWarID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// HandleWar fights a war we attacked in and reports its result. A war that
+// was already fought isn't fought again, its result is returned as it was.
+// If the defender has allies, the war waits allyGatherWindow for their
+// recognitions and is fought by FightGatheredWars.
+func (gs *GameState) HandleWar(rw RecognitionOfWar) (WarOutcome, WarResult) {
+	return gs.handleWar(rw, time.Now())
+}
+
+func (gs *GameState) handleWar(rw RecognitionOfWar, now time.Time) (WarOutcome, WarResult) {
 	defer fmt.Println("------------------------")
 	fmt.Println()
 	fmt.Println("==== War Declared ====")
 	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
 
 	player := gs.GetPlayerSnap()
-
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
-	}
-
 	if player.Username != rw.Attacker.Username {
 		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+		return WarOutcomeNotInvolved, WarResult{}
+	}
+	if fought, ok := gs.getFoughtWar(rw.WarID); ok {
+		fmt.Println("This war has already been fought.")
+		return fought.outcome, fought.result
+	}
+	if lead, ok := gs.gatherWar(rw, now); ok {
+		if lead != rw.Defender.Username {
+			fmt.Printf("%s joins the defense of their ally %s.\n", rw.Defender.Username, lead)
+		} else {
+			fmt.Printf("Waiting for %s's allies to join the war.\n", rw.Defender.Username)
+		}
+		return WarOutcomeGathering, WarResult{}
 	}
+	return gs.fightWar(rw, nil)
+}
 
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
-	if overlappingLocation == "" {
-		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+// FightGatheredWars fights the wars that have waited long enough for the
+// defenders' allies, and returns the results for every defender and ally.
+func (gs *GameState) FightGatheredWars(now time.Time) []WarAnswer {
+	gs.mu.Lock()
+	ready := []*warGroup{}
+	for id, group := range gs.gatheringWars {
+		if now.Sub(group.received) >= allyGatherWindow {
+			delete(gs.gatheringWars, id)
+			ready = append(ready, group)
+		}
 	}
+	gs.mu.Unlock()
+	sort.Slice(ready, func(i, j int) bool {
+		return ready[i].received.Before(ready[j].received)
+	})
+
+	answers := []WarAnswer{}
+	for _, group := range ready {
+		fmt.Println()
+		fmt.Println("==== War Declared ====")
+		outcome, result := gs.fightWar(group.lead, group.allies)
+		fmt.Println("------------------------")
+		if outcome == WarOutcomeNoUnits {
+			continue
+		}
+		answers = append(answers, WarAnswer{To: group.lead.Defender.Username, Result: result})
+		for _, ally := range group.allies {
+			allyResult := result
+			allyResult.WarID = ally.WarID
+			answers = append(answers, WarAnswer{To: ally.Defender.Username, Result: allyResult})
+		}
+	}
+	return answers
+}
 
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
+// gatherWar holds on to a recognition while the defender's allies may
+// still join it. It joins the war of an ally waiting at the same location
+// if both name each other, or waits for allies of its own if it names any.
+// It returns the defender leading the war it waits in.
+func (gs *GameState) gatherWar(rw RecognitionOfWar, now time.Time) (string, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for _, group := range gs.gatheringWars {
+		if group.has(rw.WarID) {
+			return group.lead.Defender.Username, true
 		}
 	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
+	for _, group := range gs.gatheringWars {
+		if group.accepts(rw) {
+			group.allies = append(group.allies, rw)
+			return group.lead.Defender.Username, true
 		}
 	}
+	if len(rw.Allies) == 0 {
+		return "", false
+	}
+	gs.gatheringWars[rw.WarID] = &warGroup{received: now, lead: rw}
+	return rw.Defender.Username, true
+}
+
+// fightWar fights the war of a defender and the allies who joined it. Our
+// own state is the source of truth for our side of the battle, and only
+// the units the defenders revealed fight on theirs.
+func (gs *GameState) fightWar(rw RecognitionOfWar, allies []RecognitionOfWar) (WarOutcome, WarResult) {
+	overlappingLocation := rw.Location
+	attackerUnits := gs.getUnitsInLocation(overlappingLocation)
+	defenderUnits := unitsInLocation(rw.Defender.Units, overlappingLocation)
+	if len(attackerUnits) == 0 || len(defenderUnits) == 0 {
+		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
+		return WarOutcomeNoUnits, WarResult{}
+	}
+	gs.recordSighting(rw.Defender.Username, defenderUnits)
+	allyUnits := []Unit{}
+	for _, ally := range allies {
+		units := unitsInLocation(ally.Defender.Units, overlappingLocation)
+		gs.recordSighting(ally.Defender.Username, units)
+		allyUnits = append(allyUnits, units...)
+	}
 
 	fmt.Printf("%s's units:\n", rw.Attacker.Username)
 	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+		fmt.Printf("  * %v (%v hp, %s)\n", unit.Rank, unit.Health, veterancyTitle(unit))
 	}
 	fmt.Printf("%s's units:\n", rw.Defender.Username)
 	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+		fmt.Printf("  * %v (%v hp, %s)\n", unit.Rank, unit.Health, veterancyTitle(unit))
+	}
+	if len(allyUnits) > 0 {
+		fmt.Printf("%s's allies:\n", rw.Defender.Username)
+		for _, unit := range allyUnits {
+			fmt.Printf("  * %s's %v (%v hp, %s)\n", unit.Owner, unit.Rank, unit.Health, veterancyTitle(unit))
+		}
 	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
+
+	result := WarResult{
+		GameID:      rw.GameID,
+		WarID:       rw.WarID,
+		CurrentTime: time.Now(),
+		Location:    overlappingLocation,
+		Attacker: WarSide{
+			Username: rw.Attacker.Username,
+			Units:    attackerUnits,
+		},
+		Defender: WarSide{
+			Username: rw.Defender.Username,
+			Units:    defenderUnits,
+			Allies:   allyUnits,
+		},
+		Recognitions: signedRecognitions(rw, allies),
+	}
+	fight(&result)
+	fmt.Printf("Attacker has a power level of %v\n", result.Attacker.Power)
+	fmt.Printf("Defender has a power level of %v\n", result.Defender.Power)
+
+	var outcome WarOutcome
+	experience := drawExperience
+	switch result.Outcome {
+	case WarAttackerWon:
 		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
-		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
+		outcome, experience = WarOutcomeYouWon, winExperience
+	case WarDefenderWon:
 		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
+		fmt.Println("You have lost the war!")
+		outcome, experience = WarOutcomeOpponentWon, lossExperience
+	default:
+		fmt.Println("The war ended in a draw!")
+		outcome = WarOutcomeDraw
+	}
+	gs.damageSightingsInLocation(rw.Defender.Username, overlappingLocation, result.Defender.Damage)
+	for _, ally := range allies {
+		gs.damageSightingsInLocation(ally.Defender.Username, overlappingLocation, result.Defender.Damage)
+	}
+	result.Attacker.Casualties = gs.takeCasualties(overlappingLocation, result.Attacker.Damage, experience, nil)
+	result.Defender.Casualties = killedBy(defenderUnits, result.Defender.Damage)
+	gs.rememberFoughtWar(outcome, result)
+	for _, ally := range allies {
+		allyResult := result
+		allyResult.WarID = ally.WarID
+		gs.rememberFoughtWar(outcome, allyResult)
+	}
+	return outcome, result
+}
+
+// signedRecognitions are the recognitions a war was fought on as their
+// defenders signed them, for the servers to check.
+func signedRecognitions(rw RecognitionOfWar, allies []RecognitionOfWar) []routing.SignedMessage {
+	signed := []routing.SignedMessage{}
+	for _, r := range append([]RecognitionOfWar{rw}, allies...) {
+		if r.Signed.Key != "" {
+			signed = append(signed, r.Signed)
+		}
+	}
+	return signed
+}
+
+// HandleWarResult applies the result of a war we defended, or joined as an
+// ally. Only the wars we are waiting on are applied, each of them once.
+// Rather than taking the attacker's word for the damage, we fight the war
+// again with the units everyone revealed, our own as we revealed them.
+func (gs *GameState) HandleWarResult(wr WarResult) (WarResultOutcome, bool) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== War Result ====")
+	rw, ok := gs.takePendingWar(wr)
+	if !ok {
+		fmt.Printf("Ignoring the result of a war with %s we are not waiting on.\n", wr.Attacker.Username)
+		return "", false
+	}
+
+	ours := unitsInLocation(rw.Defender.Units, rw.Location)
+	defender := WarSide{
+		Username: rw.Defender.Username,
+		Units:    ours,
+		Allies:   withoutOwners(wr.Defender.Allies, rw.Defender.Username, rw.Attacker.Username),
+	}
+	if wr.Defender.Username != rw.Defender.Username {
+		fmt.Printf("You joined %s's defense.\n", wr.Defender.Username)
+		defender = WarSide{
+			Username: wr.Defender.Username,
+			Units:    unitsInLocation(sliceToMap(wr.Defender.Units), rw.Location),
+			Allies:   append(withoutOwners(wr.Defender.Allies, rw.Defender.Username, rw.Attacker.Username, wr.Defender.Username), ours...),
 		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
+	result := WarResult{
+		Location: rw.Location,
+		Attacker: WarSide{
+			Username: rw.Attacker.Username,
+			Units:    unitsInLocation(sliceToMap(wr.Attacker.Units), rw.Location),
+		},
+		Defender: defender,
+	}
+	fight(&result)
+	fmt.Printf("%s attacked you in %s with a power level of %v against your %v.\n", rw.Attacker.Username, rw.Location, result.Attacker.Power, result.Defender.Power)
+
+	experience := drawExperience
+	switch result.Outcome {
+	case WarDefenderWon:
+		fmt.Println("You have won the war!")
+		experience = winExperience
+	case WarAttackerWon:
+		fmt.Println("You have lost the war!")
+		experience = lossExperience
+	default:
+		fmt.Println("The war ended in a draw!")
+	}
+	if result.Outcome != wr.Outcome {
+		fmt.Printf("%s reported a different outcome, %s, which is ignored.\n", rw.Attacker.Username, wr.Outcome)
+	}
+	gs.recordSighting(rw.Attacker.Username, result.Attacker.Units)
+	gs.damageSightingsInLocation(rw.Attacker.Username, rw.Location, result.Attacker.Damage)
+	gs.takeCasualties(rw.Location, result.Defender.Damage, experience, rw.Defender.Units)
+	return result.Outcome, true
+}
+
+// Defended gives a reported war the defense its defenders recognized, so
+// the attacker can't make up a war, or the units of its defenders. Allies
+// only count if they and the lead defender name each other, like when the
+// war was fought.
+func (wr WarResult) Defended(lead RecognitionOfWar, allies []RecognitionOfWar) (WarResult, error) {
+	if lead.WarID != wr.WarID || lead.GameID != wr.GameID || lead.Location != wr.Location ||
+		lead.Attacker.Username != wr.Attacker.Username || lead.Defender.Username != wr.Defender.Username {
+		return WarResult{}, fmt.Errorf("war %s isn't the war %s recognized", wr.WarID, lead.Defender.Username)
+	}
+	wr.Defender.Units = unitsInLocation(lead.Defender.Units, lead.Location)
+	wr.Defender.Allies = []Unit{}
+	group := warGroup{lead: lead}
+	for _, ally := range allies {
+		if ally.Attacker.Username != lead.Attacker.Username || !group.accepts(ally) {
+			continue
+		}
+		group.allies = append(group.allies, ally)
+		wr.Defender.Allies = append(wr.Defender.Allies, unitsInLocation(ally.Defender.Units, lead.Location)...)
+	}
+	return wr, nil
+}
+
+// Refight fights a reported war again from the units both sides revealed,
+// the way the defender does, so its outcome and casualties don't rest on
+// the attacker's word. Only units of each side in the location count, each
+// once, and only with a known rank and a health the rules allow.
+func (wr WarResult) Refight() (WarResult, error) {
+	attacker, defender := wr.Attacker.Username, wr.Defender.Username
+	result := WarResult{
+		GameID:      wr.GameID,
+		WarID:       wr.WarID,
+		CurrentTime: wr.CurrentTime,
+		Location:    wr.Location,
+		Attacker: WarSide{
+			Username: attacker,
+			Units:    fielded(wr.Attacker.Units, wr.Location, func(owner string) bool { return owner == attacker }),
+		},
+		Defender: WarSide{
+			Username: defender,
+			Units:    fielded(wr.Defender.Units, wr.Location, func(owner string) bool { return owner == defender }),
+			Allies: fielded(wr.Defender.Allies, wr.Location, func(owner string) bool {
+				return owner != attacker && owner != defender
+			}),
+		},
+	}
+	if len(result.Attacker.Units) == 0 || len(result.Defender.Units) == 0 {
+		return WarResult{}, fmt.Errorf("no units of both sides in %s", wr.Location)
+	}
+	fight(&result)
+	result.Attacker.Casualties = killedBy(result.Attacker.Units, result.Attacker.Damage)
+	result.Defender.Casualties = killedBy(result.Defender.Units, result.Defender.Damage)
+	return result, nil
+}
+
+// fielded keeps the valid units in the location whose owner is accepted,
+// each once.
+func fielded(units []Unit, loc Location, accepted func(owner string) bool) []Unit {
+	kept := []Unit{}
+	seen := map[string]struct{}{}
+	for _, unit := range units {
+		if unit.Location != loc || !accepted(unit.Owner) || unit.Health <= 0 || unit.Health > MaxUnitHealth || unit.Experience < 0 {
+			continue
+		}
+		if _, ok := CurrentRules().UnitType(unit.Rank); !ok {
+			continue
+		}
+		if _, ok := seen[unit.GlobalID()]; ok {
+			continue
+		}
+		seen[unit.GlobalID()] = struct{}{}
+		kept = append(kept, unit)
+	}
+	return kept
+}
+
+// ExpireWars gives up on the wars the attacker didn't fight in time. Their
+// recognitions expired before the attacker got them, so neither side takes
+// casualties. It returns how many wars it gave up on.
+func (gs *GameState) ExpireWars(now time.Time) int {
+	gs.mu.Lock()
+	expired := []RecognitionOfWar{}
+	for id, pending := range gs.pendingWars {
+		if now.After(pending.sent.Add(pending.rw.Timeout + warResultGrace)) {
+			delete(gs.pendingWars, id)
+			expired = append(expired, pending.rw)
+		}
+	}
+	gs.mu.Unlock()
+	for _, rw := range expired {
+		fmt.Println()
+		fmt.Printf("%s never fought the war in %s, it is called off.\n", rw.Attacker.Username, rw.Location)
+	}
+	return len(expired)
+}
+
+// takePendingWar finds the war a result is for. The result of a war we
+// joined as an ally names the ally who led the defense, who must be one we
+// named in our recognition.
+func (gs *GameState) takePendingWar(wr WarResult) (RecognitionOfWar, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	pending, ok := gs.pendingWars[wr.WarID]
+	rw := pending.rw
+	if !ok || rw.Attacker.Username != wr.Attacker.Username {
+		return RecognitionOfWar{}, false
+	}
+	if wr.Defender.Username != rw.Defender.Username && !slices.Contains(rw.Allies, wr.Defender.Username) {
+		return RecognitionOfWar{}, false
+	}
+	delete(gs.pendingWars, wr.WarID)
+	return rw, true
+}
+
+func (gs *GameState) getFoughtWar(warID string) (foughtWar, bool) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	fought, ok := gs.foughtWars[warID]
+	return fought, ok
+}
+
+func (gs *GameState) rememberFoughtWar(outcome WarOutcome, result WarResult) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	now := time.Now()
+	for id, fought := range gs.foughtWars {
+		if now.Sub(fought.at) > foughtWarMemory {
+			delete(gs.foughtWars, id)
+		}
+	}
+	gs.foughtWars[result.WarID] = foughtWar{at: now, outcome: outcome, result: result}
 }
 
-func unitsToPowerLevel(units []Unit) int {
-	power := 0
+// fight works out the outcome and the damage of a war from the units of
+// both sides. Both sides run it on the same units, so they agree.
+func fight(result *WarResult) {
+	attackerPower := unitsToPowerLevel(sortedByID(result.Attacker.Units), false)
+	defenderPower := unitsToPowerLevel(sortedByID(result.Defender.Units), true) + unitsToPowerLevel(sortedByID(result.Defender.Allies), true)
+	result.Attacker.Power = attackerPower
+	result.Defender.Power = defenderPower
+	switch {
+	case attackerPower > defenderPower:
+		result.Outcome = WarAttackerWon
+		result.Attacker.Damage, result.Defender.Damage = warDamage(attackerPower, defenderPower)
+	case defenderPower > attackerPower:
+		result.Outcome = WarDefenderWon
+		result.Defender.Damage, result.Attacker.Damage = warDamage(defenderPower, attackerPower)
+	default:
+		result.Outcome = WarDraw
+		damage, _ := warDamage(attackerPower, defenderPower)
+		result.Attacker.Damage, result.Defender.Damage = damage, damage
+	}
+}
+
+// sortedByID orders units so that their powers add up the same everywhere.
+func sortedByID(units []Unit) []Unit {
+	sorted := append([]Unit{}, units...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GlobalID() < sorted[j].GlobalID()
+	})
+	return sorted
+}
+
+func unitsInLocation(units map[int]Unit, loc Location) []Unit {
+	inLocation := []Unit{}
 	for _, unit := range units {
-		if unit.Rank == RankArtillery {
-			power += 10
+		if unit.Location == loc {
+			inLocation = append(inLocation, unit)
 		}
-		if unit.Rank == RankCavalry {
-			power += 5
+	}
+	return inLocation
+}
+
+// withoutOwners drops the units of the given players.
+func withoutOwners(units []Unit, owners ...string) []Unit {
+	kept := []Unit{}
+	for _, unit := range units {
+		if !slices.Contains(owners, unit.Owner) {
+			kept = append(kept, unit)
+		}
+	}
+	return kept
+}
+
+func sliceToMap(units []Unit) map[int]Unit {
+	byID := map[int]Unit{}
+	for _, unit := range units {
+		byID[unit.ID] = unit
+	}
+	return byID
+}
+
+// killedBy returns the units the damage kills.
+func killedBy(units []Unit, damage int) []Unit {
+	killed := []Unit{}
+	for _, unit := range units {
+		if unit.Health <= damage {
+			killed = append(killed, unit)
 		}
-		if unit.Rank == RankInfantry {
-			power += 1
+	}
+	return killed
+}
+
+// RecognizeWar builds the war recognition for a hostile move, revealing only
+// the units we have in the contested location, and waits for the
+// attacker's result until the timeout. Our allies with units there
+// recognize the war too, and naming them lets the attacker pool us.
+func (gs *GameState) RecognizeWar(move ArmyMove) RecognitionOfWar {
+	return gs.recognizeWar(move, time.Now())
+}
+
+func (gs *GameState) recognizeWar(move ArmyMove, now time.Time) RecognitionOfWar {
+	attacker := Player{
+		Username: move.Username,
+		Units:    map[int]Unit{},
+	}
+	for _, unit := range move.Units {
+		attacker.Units[unit.ID] = unit
+	}
+	defender := Player{
+		Username: gs.GetUsername(),
+		Units:    map[int]Unit{},
+	}
+	for _, unit := range gs.getUnitsInLocation(move.ToLocation) {
+		defender.Units[unit.ID] = unit
+	}
+	rw := RecognitionOfWar{
+		GameID:   gs.GetGameID(),
+		WarID:    newWarID(),
+		Location: move.ToLocation,
+		Attacker: attacker,
+		Defender: defender,
+		Allies:   gs.getAllies(move.Username),
+		Timeout:  WarTimeout,
+	}
+	gs.mu.Lock()
+	gs.pendingWars[rw.WarID] = pendingWar{rw: rw, sent: now}
+	gs.mu.Unlock()
+	return rw
+}
+
+func unitsToPowerLevel(units []Unit, defending bool) float64 {
+	power := 0.0
+	for _, unit := range units {
+		unitType, _ := CurrentRules().UnitType(unit.Rank)
+		if defending && unitType.HasAbility(AbilityFortify) {
+			power += unitPower(unit) * 1.5
+			continue
 		}
+		power += unitPower(unit)
 	}
 	return power
 }
+
+func rankPower(rank UnitRank) float64 {
+	unitType, _ := CurrentRules().UnitType(rank)
+	return unitType.Power
+}
@@ -0,0 +1,332 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+func TestRefight(t *testing.T) {
+	attacker := newTestPlayer("xavier", "europe", RankInfantry)
+	defender := newTestPlayer("dana", "europe", RankCavalry)
+	outcome, fought := attacker.HandleWar(defender.RecognizeWar(moveOf(attacker)))
+	if outcome != WarOutcomeOpponentWon {
+		t.Fatalf("HandleWar = %v, want the defender to win", outcome)
+	}
+
+	got, err := fought.Refight()
+	if err != nil {
+		t.Fatalf("Refight: %v", err)
+	}
+	if got.Outcome != fought.Outcome || got.Attacker.Damage != fought.Attacker.Damage || got.Defender.Damage != fought.Defender.Damage {
+		t.Errorf("Refight() = %+v, want the war as fought %+v", got, fought)
+	}
+	if !reflect.DeepEqual(unitIDs(got.Attacker.Casualties), unitIDs(fought.Attacker.Casualties)) {
+		t.Errorf("attacker casualties = %v, want %v", got.Attacker.Casualties, fought.Attacker.Casualties)
+	}
+
+	// The attacker claims a win with made up, doubled and misplaced units
+	cheat := fought
+	cheat.Outcome = WarAttackerWon
+	strong := fought.Attacker.Units[0]
+	strong.Health = 10 * MaxUnitHealth
+	elsewhere := fought.Attacker.Units[0]
+	elsewhere.ID, elsewhere.Location = 9, "asia"
+	borrowed := fought.Attacker.Units[0]
+	borrowed.ID, borrowed.Owner = 10, "mallory"
+	cheat.Attacker.Units = append(append([]Unit{}, fought.Attacker.Units...), fought.Attacker.Units[0], strong, elsewhere, borrowed)
+	cheat.Defender.Allies = []Unit{fought.Attacker.Units[0]}
+
+	got, err = cheat.Refight()
+	if err != nil {
+		t.Fatalf("Refight: %v", err)
+	}
+	if got.Outcome != WarDefenderWon || got.Attacker.Power != fought.Attacker.Power || got.Defender.Power != fought.Defender.Power {
+		t.Errorf("Refight() of a cheat = %s with %v against %v, want %s with %v against %v",
+			got.Outcome, got.Attacker.Power, got.Defender.Power, fought.Outcome, fought.Attacker.Power, fought.Defender.Power)
+	}
+
+	cheat.Defender.Units = nil
+	if _, err := cheat.Refight(); err == nil {
+		t.Errorf("Refight() without defenders = nil, want an error")
+	}
+}
+
+func unitIDs(units []Unit) []string {
+	ids := []string{}
+	for _, unit := range sortedByID(units) {
+		ids = append(ids, unit.GlobalID())
+	}
+	return ids
+}
+
+// healths maps the IDs of a player's units to their health.
+func healths(gs *GameState) map[int]int {
+	byID := map[int]int{}
+	for id, unit := range gs.GetPlayerSnap().Units {
+		byID[id] = unit.Health
+	}
+	return byID
+}
+
+func TestHandleWarOnce(t *testing.T) {
+	attacker := newTestPlayer("xavier", "europe", RankCavalry, RankInfantry)
+	defender := newTestPlayer("dana", "europe", RankInfantry, RankInfantry)
+	rw := defender.RecognizeWar(moveOf(attacker))
+	rw.Signed = routing.SignedMessage{Key: "war.g1.xavier.dana", Username: "dana"}
+
+	outcome, result := attacker.HandleWar(rw)
+	if outcome != WarOutcomeYouWon {
+		t.Fatalf("HandleWar = %v, want the attacker to win", outcome)
+	}
+	if want := []routing.SignedMessage{rw.Signed}; !reflect.DeepEqual(result.Recognitions, want) {
+		t.Errorf("result recognitions = %+v, want the signed recognition %+v", result.Recognitions, want)
+	}
+	after := healths(attacker)
+
+	// The recognition is delivered again
+	again, againResult := attacker.HandleWar(rw)
+	if again != outcome || !reflect.DeepEqual(againResult, result) {
+		t.Errorf("HandleWar() again = %v, %+v, want the first result %v, %+v", again, againResult, outcome, result)
+	}
+	if got := healths(attacker); !reflect.DeepEqual(got, after) {
+		t.Errorf("units after the redelivery = %v, want %v", got, after)
+	}
+
+	// A new recognition of the same move is a new war
+	if outcome, second := attacker.HandleWar(defender.RecognizeWar(moveOf(attacker))); outcome == WarOutcomeNotInvolved || second.WarID == result.WarID {
+		t.Errorf("HandleWar() of a new WarID = %v, %+v, want a war of its own", outcome, second)
+	}
+}
+
+func TestHandleWarNotInvolved(t *testing.T) {
+	attacker := newTestPlayer("xavier", "europe", RankCavalry)
+	defender := newTestPlayer("dana", "europe", RankInfantry)
+	other := newTestPlayer("olga", "europe", RankInfantry)
+	if outcome, _ := other.HandleWar(defender.RecognizeWar(moveOf(attacker))); outcome != WarOutcomeNotInvolved {
+		t.Errorf("HandleWar() by someone else = %v, want not involved", outcome)
+	}
+}
+
+// The recognition only says how long the defender waits, counted on the
+// defender's clock, so the attacker's clock can be off by any amount.
+func TestWarWithSkewedClocks(t *testing.T) {
+	for _, skew := range []time.Duration{-2 * time.Minute, -15 * time.Second, 15 * time.Second, 2 * time.Minute} {
+		attacker := newTestPlayer("xavier", "europe", RankInfantry)
+		defender := newTestPlayer("dana", "europe", RankInfantry)
+		ally := newTestPlayer("abe", "europe", RankInfantry)
+		defender.setRelation("abe", RelationAlliance)
+		ally.setRelation("dana", RelationAlliance)
+
+		// The defender's clock
+		sent := time.Now()
+		move := moveOf(attacker)
+		rw := defender.recognizeWar(move, sent)
+		allied := ally.recognizeWar(move, sent)
+		if rw.Timeout != WarTimeout {
+			t.Fatalf("recognition timeout = %v, want %v", rw.Timeout, WarTimeout)
+		}
+
+		// The attacker gets them a second later by the defender's clock,
+		// and waits for the ally by its own
+		received := sent.Add(time.Second + skew)
+		for _, r := range []RecognitionOfWar{rw, allied} {
+			if outcome, _ := attacker.handleWar(r, received); outcome != WarOutcomeGathering {
+				t.Fatalf("skew %v: handleWar(%s) = %v, want gathering", skew, r.Defender.Username, outcome)
+			}
+		}
+		answers := attacker.FightGatheredWars(received.Add(allyGatherWindow))
+		if len(answers) != 2 {
+			t.Fatalf("skew %v: got %d answers, want 2", skew, len(answers))
+		}
+
+		// The results arrive in time by the defenders' clock
+		arrived := sent.Add(time.Second + allyGatherWindow)
+		for _, player := range []*GameState{defender, ally} {
+			if n := player.ExpireWars(arrived); n != 0 {
+				t.Errorf("skew %v: %s gave up on %d wars before the results arrived", skew, player.GetUsername(), n)
+			}
+		}
+		for i, player := range []*GameState{defender, ally} {
+			if _, ok := player.HandleWarResult(answers[i].Result); !ok {
+				t.Errorf("skew %v: %s ignored the result of a war the attacker fought", skew, player.GetUsername())
+			}
+		}
+	}
+}
+
+func TestHandleWarResult(t *testing.T) {
+	attacker := newTestPlayer("xavier", "europe", RankInfantry, RankInfantry)
+	defender := newTestPlayer("dana", "europe", RankInfantry, RankInfantry)
+	rw := defender.RecognizeWar(moveOf(attacker))
+	_, result := attacker.HandleWar(rw)
+
+	unknown := result
+	unknown.WarID = "unknown"
+	if _, ok := defender.HandleWarResult(unknown); ok {
+		t.Errorf("HandleWarResult() of an unknown war = ok, want it ignored")
+	}
+	forged := result
+	forged.Attacker.Username = "mallory"
+	if _, ok := defender.HandleWarResult(forged); ok {
+		t.Errorf("HandleWarResult() from another attacker = ok, want it ignored")
+	}
+
+	// The attacker claims a win and more damage than the war dealt
+	claimed := result
+	claimed.Outcome = WarAttackerWon
+	claimed.Defender.Damage = MaxUnitHealth
+	outcome, ok := defender.HandleWarResult(claimed)
+	if !ok || outcome != result.Outcome {
+		t.Fatalf("HandleWarResult() = %v, %v, want the war fought again to %v", outcome, ok, result.Outcome)
+	}
+	after := healths(defender)
+	if len(after) != 2 {
+		t.Fatalf("defender has %d units, want both to survive", len(after))
+	}
+	for id, health := range after {
+		if health != MaxUnitHealth-result.Defender.Damage {
+			t.Errorf("unit %d has %d hp, want %d", id, health, MaxUnitHealth-result.Defender.Damage)
+		}
+	}
+
+	if _, ok := defender.HandleWarResult(result); ok {
+		t.Errorf("HandleWarResult() applied a war twice")
+	}
+	if got := healths(defender); !reflect.DeepEqual(got, after) {
+		t.Errorf("units after the second result = %v, want %v", got, after)
+	}
+}
+
+func TestExpireWars(t *testing.T) {
+	attacker := newTestPlayer("xavier", "europe", RankCavalry)
+	defender := newTestPlayer("dana", "europe", RankInfantry)
+	sent := time.Now()
+	rw := defender.recognizeWar(moveOf(attacker), sent)
+
+	if n := defender.ExpireWars(sent.Add(rw.Timeout)); n != 0 {
+		t.Errorf("ExpireWars() at the timeout = %d, want 0 while a result may be on its way", n)
+	}
+	if n := defender.ExpireWars(sent.Add(rw.Timeout + warResultGrace + time.Second)); n != 1 {
+		t.Fatalf("ExpireWars() past the grace = %d, want 1", n)
+	}
+	if n := defender.ExpireWars(sent.Add(rw.Timeout + warResultGrace + time.Second)); n != 0 {
+		t.Errorf("ExpireWars() again = %d, want 0", n)
+	}
+
+	// A result arriving after all is ignored
+	_, result := attacker.HandleWar(rw)
+	before := healths(defender)
+	if _, ok := defender.HandleWarResult(result); ok {
+		t.Errorf("HandleWarResult() of an expired war = ok, want it ignored")
+	}
+	if got := healths(defender); !reflect.DeepEqual(got, before) {
+		t.Errorf("units after an expired war = %v, want %v", got, before)
+	}
+}
+
+func TestFightIsDeterministic(t *testing.T) {
+	units := func(owner string, ranks ...UnitRank) []Unit {
+		list := []Unit{}
+		for i, rank := range ranks {
+			list = append(list, Unit{ID: i + 1, Owner: owner, Rank: rank, Location: "europe", Health: MaxUnitHealth - 7*i, Experience: i})
+		}
+		return list
+	}
+	reversed := func(list []Unit) []Unit {
+		out := []Unit{}
+		for i := len(list) - 1; i >= 0; i-- {
+			out = append(out, list[i])
+		}
+		return out
+	}
+	attackers := units("xavier", RankCavalry, RankInfantry, RankArtillery)
+	defenders := units("dana", RankInfantry, RankArtillery)
+	allies := units("abe", RankCavalry, RankInfantry)
+
+	first := WarResult{Attacker: WarSide{Units: attackers}, Defender: WarSide{Units: defenders, Allies: allies}}
+	second := WarResult{Attacker: WarSide{Units: reversed(attackers)}, Defender: WarSide{Units: reversed(defenders), Allies: reversed(allies)}}
+	fight(&first)
+	fight(&second)
+	if first.Outcome != second.Outcome {
+		t.Errorf("outcome = %s, want %s whatever the order of the units", second.Outcome, first.Outcome)
+	}
+	if first.Attacker.Power != second.Attacker.Power || first.Attacker.Damage != second.Attacker.Damage {
+		t.Errorf("attacker side = %+v, want %+v whatever the order of the units", second.Attacker, first.Attacker)
+	}
+	if first.Defender.Power != second.Defender.Power || first.Defender.Damage != second.Defender.Damage {
+		t.Errorf("defender side = %+v, want %+v whatever the order of the units", second.Defender, first.Defender)
+	}
+
+	// Both sides of a war compute the same damage
+	attacker := newTestPlayer("xavier", "europe", RankCavalry, RankInfantry)
+	defender := newTestPlayer("dana", "europe", RankArtillery, RankInfantry, RankInfantry)
+	_, result := attacker.HandleWar(defender.RecognizeWar(moveOf(attacker)))
+	if _, ok := defender.HandleWarResult(result); !ok {
+		t.Fatalf("HandleWarResult() = not applied, want applied")
+	}
+	for _, unit := range defender.GetPlayerSnap().Units {
+		if unit.Health != MaxUnitHealth-result.Defender.Damage {
+			t.Errorf("defender's unit %d has %d hp, want the %d damage the attacker computed", unit.ID, unit.Health, result.Defender.Damage)
+		}
+	}
+	if got, want := len(defender.GetPlayerSnap().Units), 3-len(result.Defender.Casualties); got != want {
+		t.Errorf("defender has %d units left, want %d after the attacker's casualties %v", got, want, result.Defender.Casualties)
+	}
+}
+
+func TestDefended(t *testing.T) {
+	attacker := newTestPlayer("xavier", "europe", RankCavalry)
+	defender := newTestPlayer("dana", "europe", RankInfantry, RankInfantry)
+	ally := newTestPlayer("abe", "europe", RankCavalry)
+	stranger := newTestPlayer("sam", "europe", RankArtillery)
+	defender.setRelation("abe", RelationAlliance)
+	ally.setRelation("dana", RelationAlliance)
+	stranger.setRelation("dana", RelationAlliance)
+
+	move := moveOf(attacker)
+	lead := defender.RecognizeWar(move)
+	allies := []RecognitionOfWar{ally.RecognizeWar(move), stranger.RecognizeWar(move)}
+
+	// The attacker claims dana fought alone with one weak unit
+	weak := lead.Defender.Units[1]
+	weak.Health = 1
+	reported := WarResult{
+		GameID:   lead.GameID,
+		WarID:    lead.WarID,
+		Location: lead.Location,
+		Attacker: WarSide{Username: "xavier", Units: unitsInLocation(attacker.GetPlayerSnap().Units, "europe")},
+		Defender: WarSide{Username: "dana", Units: []Unit{weak}},
+	}
+	got, err := reported.Defended(lead, allies)
+	if err != nil {
+		t.Fatalf("Defended: %v", err)
+	}
+	if want := unitIDs(unitsInLocation(lead.Defender.Units, "europe")); !reflect.DeepEqual(unitIDs(got.Defender.Units), want) {
+		t.Errorf("defender units = %v, want the recognized %v", unitIDs(got.Defender.Units), want)
+	}
+	// sam names dana, but dana doesn't name sam
+	if want := unitIDs(unitsInLocation(allies[0].Defender.Units, "europe")); !reflect.DeepEqual(unitIDs(got.Defender.Allies), want) {
+		t.Errorf("allied units = %v, want abe's %v", unitIDs(got.Defender.Allies), want)
+	}
+
+	tests := []struct {
+		name   string
+		change func(wr *WarResult)
+	}{
+		{"other war", func(wr *WarResult) { wr.WarID = "other" }},
+		{"other game", func(wr *WarResult) { wr.GameID = "g2" }},
+		{"other location", func(wr *WarResult) { wr.Location = "asia" }},
+		{"other defender", func(wr *WarResult) { wr.Defender.Username = "abe" }},
+		{"other attacker", func(wr *WarResult) { wr.Attacker.Username = "mallory" }},
+	}
+	for _, tt := range tests {
+		wr := reported
+		tt.change(&wr)
+		if _, err := wr.Defended(lead, allies); err == nil {
+			t.Errorf("%s: Defended() = nil, want an error", tt.name)
+		}
+	}
+}
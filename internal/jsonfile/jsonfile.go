@@ -0,0 +1,105 @@
+// Package jsonfile keeps a value in a JSON file that several servers share.
+package jsonfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/x6Nenko/peril/internal/filelock"
+)
+
+// File is a JSON file re-read before every change, so servers sharing it
+// see each other's changes. A lock file next to it keeps them from losing
+// each other's changes, and a change is written to a temporary file first,
+// so readers never see half of it.
+type File struct {
+	path string
+	// name says what the file holds in errors, e.g. "users"
+	name string
+	mu   sync.Mutex
+	lock *os.File
+}
+
+func Open(path, name string) (*File, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s lock file: %v", name, err)
+	}
+	return &File{path: path, name: name, lock: lock}, nil
+}
+
+func (f *File) Close() error {
+	return f.lock.Close()
+}
+
+// Read decodes the file into v. A missing or empty file leaves v as it is.
+func (f *File) Read(v any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.read(v)
+}
+
+func (f *File) read(v any) error {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read %s file: %v", f.name, err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("could not parse %s file: %v", f.name, err)
+	}
+	return nil
+}
+
+// Update reads the file into v under the lock, and saves v once change
+// has changed it. change reports whether it did; its error aborts the
+// update.
+func (f *File) Update(v any, change func() (bool, error)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := filelock.Lock(f.lock)
+	if err != nil {
+		return fmt.Errorf("could not lock %s file: %v", f.name, err)
+	}
+	defer filelock.Unlock(f.lock)
+
+	err = f.read(v)
+	if err != nil {
+		return err
+	}
+	changed, err := change()
+	if err != nil || !changed {
+		return err
+	}
+	return f.save(v)
+}
+
+func (f *File) save(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not write %s file: %v", f.name, err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not write %s file: %v", f.name, err)
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,92 @@
+package jsonfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func openTestFile(t *testing.T, path string) *File {
+	t.Helper()
+	f, err := Open(path, "test")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	a := openTestFile(t, path)
+	b := openTestFile(t, path)
+
+	add := func(f *File, key string) error {
+		m := map[string]int{}
+		return f.Update(&m, func() (bool, error) {
+			m[key]++
+			return true, nil
+		})
+	}
+	if err := add(a, "alice"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	// The other server sees the first change before making its own
+	if err := add(b, "bob"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	got := map[string]int{}
+	if err := a.Read(&got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if want := map[string]int{"alice": 1, "bob": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = %v, want %v", got, want)
+	}
+	if matches, _ := filepath.Glob(path + ".*[0-9]"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestUpdateWithoutChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	f := openTestFile(t, path)
+	m := map[string]int{}
+	if err := f.Update(&m, func() (bool, error) { return false, nil }); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("an update without changes wrote the file: %v", err)
+	}
+
+	failed := errors.New("no such game")
+	err := f.Update(&m, func() (bool, error) {
+		m["alice"] = 1
+		return true, failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("Update() = %v, want the change's error", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a failed update wrote the file: %v", err)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.json")
+	f := openTestFile(t, path)
+
+	m := map[string]int{"kept": 1}
+	if err := f.Read(&m); err != nil || m["kept"] != 1 {
+		t.Errorf("Read() of a missing file = %v, %v, want v left as it was", m, err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatalf("could not write %s: %v", path, err)
+	}
+	if err := f.Read(&m); err == nil || !strings.Contains(err.Error(), "could not parse test file") {
+		t.Errorf("Read() of a broken file = %v, want a parse error", err)
+	}
+}
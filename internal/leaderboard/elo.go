@@ -0,0 +1,23 @@
+package leaderboard
+
+import "math"
+
+const (
+	// InitialRating is the rating of a player before their first war
+	InitialRating = 1000.0
+	// kFactor is the most a rating can move in one war
+	kFactor = 32.0
+)
+
+// expectedScore is the score Elo expects of a player against the opponent,
+// where a win scores 1 and a draw 0.5.
+func expectedScore(rating, opponent float64) float64 {
+	return 1 / (1 + math.Pow(10, (opponent-rating)/400))
+}
+
+// rate returns both players' ratings after a war, given the score of a:
+// 1 if a won, 0.5 for a draw and 0 if b won.
+func rate(a, b, score float64) (float64, float64) {
+	change := kFactor * (score - expectedScore(a, b))
+	return a + change, b - change
+}
@@ -0,0 +1,250 @@
+package leaderboard
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/jsonfile"
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+// DefaultSize is how many players a leaderboard shows unless asked for
+// more or fewer.
+const DefaultSize = 10
+
+// army is what the army reports showed of a player's army in a game.
+type army struct {
+	// HighestUnitID is the highest unit ID reported. IDs are handed out in
+	// order, so units above it are new
+	HighestUnitID int
+	// Spawned counts the new units reported, each once
+	Spawned     int
+	Territories int
+}
+
+type armyKey struct {
+	username string
+	gameID   string
+}
+
+// recentWars is how many of a player's latest wars as the attacker are
+// remembered, so a war result delivered again isn't counted twice.
+const recentWars = 100
+
+type record struct {
+	routing.PlayerStats
+	Games map[string]army
+	// RecentWars are the IDs of the latest wars the player attacked in
+	RecentWars []string `json:",omitempty"`
+}
+
+// Store keeps player stats in a shared JSON file.
+//
+// Army reports come every few seconds from every player, so they are kept
+// in memory and saved together by FlushArmies.
+type Store struct {
+	file *jsonfile.File
+	mu   sync.Mutex
+	// armies are the armies as of the last flush and the reports since,
+	// and changes what the reports since added to them
+	armies  map[armyKey]army
+	changes map[armyKey]army
+}
+
+func Open(path string) (*Store, error) {
+	file, err := jsonfile.Open(path, "stats")
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{file: file, armies: map[armyKey]army{}, changes: map[armyKey]army{}}
+	records, err := s.load()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.readArmies(records)
+	return s, nil
+}
+
+func (s *Store) load() (map[string]*record, error) {
+	records := map[string]*record{}
+	err := s.file.Read(&records)
+	return records, err
+}
+
+// update applies change to the stats and saves them if it changed any.
+func (s *Store) update(change func(records map[string]*record) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateLocked(change)
+}
+
+func (s *Store) updateLocked(change func(records map[string]*record) bool) error {
+	records := map[string]*record{}
+	return s.file.Update(&records, func() (bool, error) {
+		return change(records), nil
+	})
+}
+
+func player(records map[string]*record, username string) *record {
+	r, ok := records[username]
+	if !ok {
+		r = &record{
+			PlayerStats: routing.PlayerStats{Username: username, Rating: InitialRating},
+		}
+		records[username] = r
+	}
+	if r.Games == nil {
+		r.Games = map[string]army{}
+	}
+	return r
+}
+
+// RecordWar counts a war for both sides and updates their ratings, unless
+// it is one of the attacker's recent wars. Allies of the defender fight
+// without being rated.
+func (s *Store) RecordWar(wr gamelogic.WarResult) error {
+	return s.update(func(records map[string]*record) bool {
+		attacker := player(records, wr.Attacker.Username)
+		if slices.Contains(attacker.RecentWars, wr.WarID) {
+			return false
+		}
+		attacker.RecentWars = append(attacker.RecentWars, wr.WarID)
+		if len(attacker.RecentWars) > recentWars {
+			attacker.RecentWars = attacker.RecentWars[len(attacker.RecentWars)-recentWars:]
+		}
+		defender := player(records, wr.Defender.Username)
+		score := 0.5
+		switch wr.Outcome {
+		case gamelogic.WarAttackerWon:
+			score = 1
+			attacker.Won++
+			defender.Lost++
+		case gamelogic.WarDefenderWon:
+			score = 0
+			attacker.Lost++
+			defender.Won++
+		default:
+			attacker.Drawn++
+			defender.Drawn++
+		}
+		attacker.Wars++
+		defender.Wars++
+		attacker.UnitsLost += len(wr.Attacker.Casualties)
+		defender.UnitsLost += len(wr.Defender.Casualties)
+		attacker.Rating, defender.Rating = rate(attacker.Rating, defender.Rating, score)
+		return true
+	})
+}
+
+// RecordArmy counts the units a player spawned since their last report
+// and the territories they hold, from an army report. A player who left is
+// recorded with an empty army. Only unit IDs the report holds count as
+// spawned, so a made up ID counts as one unit at most, and the army must
+// fit the rules. Reports are kept in memory until FlushArmies.
+func (s *Store) RecordArmy(ar gamelogic.ArmyReport) error {
+	err := gamelogic.CheckArmy(ar.Units)
+	if err != nil {
+		return fmt.Errorf("invalid army from %s: %v", ar.Username, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := armyKey{username: ar.Username, gameID: ar.GameID}
+	previous := s.armies[key]
+	latest := previous
+	territories := map[gamelogic.Location]struct{}{}
+	counted := map[int]struct{}{}
+	for _, unit := range ar.Units {
+		territories[unit.Location] = struct{}{}
+		if _, ok := counted[unit.ID]; unit.ID > previous.HighestUnitID && !ok {
+			counted[unit.ID] = struct{}{}
+			latest.Spawned++
+		}
+		latest.HighestUnitID = max(latest.HighestUnitID, unit.ID)
+	}
+	latest.Territories = len(territories)
+	if latest == previous {
+		return nil
+	}
+	s.armies[key] = latest
+	change := s.changes[key]
+	change.Spawned += latest.Spawned - previous.Spawned
+	change.HighestUnitID = latest.HighestUnitID
+	change.Territories = latest.Territories
+	s.changes[key] = change
+	return nil
+}
+
+// FlushArmies saves the army reports recorded since the last flush, and
+// reads back the armies other servers saved.
+func (s *Store) FlushArmies() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.updateLocked(func(records map[string]*record) bool {
+		for key, change := range s.changes {
+			r := player(records, key.username)
+			a := r.Games[key.gameID]
+			a.Spawned += change.Spawned
+			a.HighestUnitID = max(a.HighestUnitID, change.HighestUnitID)
+			a.Territories = change.Territories
+			r.Games[key.gameID] = a
+			r.UnitsSpawned, r.Territories = 0, 0
+			for _, a := range r.Games {
+				r.UnitsSpawned += a.Spawned
+				r.Territories += a.Territories
+			}
+		}
+		s.readArmies(records)
+		return len(s.changes) > 0
+	})
+	if err != nil {
+		return err
+	}
+	s.changes = map[armyKey]army{}
+	return nil
+}
+
+// readArmies takes the armies from the stats file.
+func (s *Store) readArmies(records map[string]*record) {
+	for username, r := range records {
+		for gameID, a := range r.Games {
+			s.armies[armyKey{username: username, gameID: gameID}] = a
+		}
+	}
+}
+
+// Leaderboard returns the limit best rated players, or every player if
+// limit isn't positive.
+func (s *Store) Leaderboard(limit int) ([]routing.PlayerStats, error) {
+	records, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	players := []routing.PlayerStats{}
+	for _, r := range records {
+		players = append(players, r.PlayerStats)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		if players[i].Rating != players[j].Rating {
+			return players[i].Rating > players[j].Rating
+		}
+		if players[i].Won != players[j].Won {
+			return players[i].Won > players[j].Won
+		}
+		return players[i].Username < players[j].Username
+	})
+	if limit > 0 && len(players) > limit {
+		players = players[:limit]
+	}
+	return players, nil
+}
+
+// Close saves the army reports not flushed yet.
+func (s *Store) Close() error {
+	err := s.FlushArmies()
+	s.file.Close()
+	return err
+}
@@ -0,0 +1,167 @@
+package leaderboard
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+func openTestStore(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func report(username string, ids ...int) gamelogic.ArmyReport {
+	ar := gamelogic.ArmyReport{GameID: "g1", Username: username}
+	for i, id := range ids {
+		loc := gamelogic.Location("europe")
+		if i%2 == 1 {
+			loc = "asia"
+		}
+		ar.Units = append(ar.Units, gamelogic.Unit{ID: id, Owner: username, Rank: gamelogic.RankInfantry, Location: loc, Health: gamelogic.MaxUnitHealth})
+	}
+	return ar
+}
+
+func stats(t *testing.T, s *Store, username string) routing.PlayerStats {
+	t.Helper()
+	players, err := s.Leaderboard(0)
+	if err != nil {
+		t.Fatalf("Leaderboard: %v", err)
+	}
+	for _, p := range players {
+		if p.Username == username {
+			return p
+		}
+	}
+	return routing.PlayerStats{}
+}
+
+func TestRecordArmy(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "stats.json"))
+	reports := []gamelogic.ArmyReport{
+		report("alice", 1, 2),
+		// The same army again
+		report("alice", 1, 2),
+		// Unit 2 died, 3 was spawned
+		report("alice", 1, 3),
+		// A made up ID counts as one unit, not a million
+		report("alice", 1, 3, 1000000),
+		// IDs below the highest were counted already
+		report("alice", 4, 5),
+		// Left the game
+		report("alice"),
+	}
+	for _, ar := range reports {
+		if err := s.RecordArmy(ar); err != nil {
+			t.Fatalf("RecordArmy: %v", err)
+		}
+	}
+
+	if got := stats(t, s, "alice"); got.Username != "" {
+		t.Errorf("stats before a flush = %+v, want nothing saved yet", got)
+	}
+	if err := s.FlushArmies(); err != nil {
+		t.Fatalf("FlushArmies: %v", err)
+	}
+	if got := stats(t, s, "alice"); got.UnitsSpawned != 4 || got.Territories != 0 {
+		t.Errorf("spawned, territories = %d, %d, want 4, 0", got.UnitsSpawned, got.Territories)
+	}
+
+	if err := s.RecordArmy(report("alice", 1000001, 1000002)); err != nil {
+		t.Fatalf("RecordArmy: %v", err)
+	}
+	if err := s.FlushArmies(); err != nil {
+		t.Fatalf("FlushArmies: %v", err)
+	}
+	if got := stats(t, s, "alice"); got.UnitsSpawned != 6 || got.Territories != 2 {
+		t.Errorf("spawned, territories = %d, %d, want 6, 2", got.UnitsSpawned, got.Territories)
+	}
+}
+
+func TestRecordArmyChecksTheRules(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "stats.json"))
+	ar := report("mallory", 1)
+	ar.Units[0].Rank = "dragon"
+	if err := s.RecordArmy(ar); err == nil {
+		t.Errorf("RecordArmy() with an unknown rank = nil, want an error")
+	}
+}
+
+func TestRecordArmySharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	a := openTestStore(t, path)
+	b := openTestStore(t, path)
+	if err := a.RecordArmy(report("alice", 1, 2)); err != nil {
+		t.Fatalf("RecordArmy: %v", err)
+	}
+	if err := a.FlushArmies(); err != nil {
+		t.Fatalf("FlushArmies: %v", err)
+	}
+	if err := b.RecordWar(gamelogic.WarResult{
+		GameID:   "g1",
+		WarID:    "w1",
+		Attacker: gamelogic.WarSide{Username: "alice"},
+		Defender: gamelogic.WarSide{Username: "bob"},
+		Outcome:  gamelogic.WarAttackerWon,
+	}); err != nil {
+		t.Fatalf("RecordWar: %v", err)
+	}
+	// alice now reports to the other server, which reads her army first
+	if err := b.FlushArmies(); err != nil {
+		t.Fatalf("FlushArmies: %v", err)
+	}
+	if err := b.RecordArmy(report("alice", 1, 2, 3)); err != nil {
+		t.Fatalf("RecordArmy: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	got := stats(t, a, "alice")
+	if got.UnitsSpawned != 3 || got.Won != 1 || got.Rating <= InitialRating {
+		t.Errorf("stats = %+v, want 3 units spawned and a won war", got)
+	}
+}
+
+func TestRecordWarOnce(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "stats.json"))
+	war := func(id string) gamelogic.WarResult {
+		return gamelogic.WarResult{
+			GameID:   "g1",
+			WarID:    id,
+			Attacker: gamelogic.WarSide{Username: "alice"},
+			Defender: gamelogic.WarSide{Username: "bob"},
+			Outcome:  gamelogic.WarAttackerWon,
+		}
+	}
+	for _, id := range []string{"w1", "w2", "w1"} {
+		if err := s.RecordWar(war(id)); err != nil {
+			t.Fatalf("RecordWar(%s): %v", id, err)
+		}
+	}
+	if got := stats(t, s, "bob"); got.Wars != 2 || got.Lost != 2 {
+		t.Errorf("bob's stats = %+v, want 2 wars lost", got)
+	}
+
+	// Only the latest wars are remembered
+	for i := 0; i < recentWars; i++ {
+		if err := s.RecordWar(war(fmt.Sprintf("later%d", i))); err != nil {
+			t.Fatalf("RecordWar: %v", err)
+		}
+	}
+	if err := s.RecordWar(war("w1")); err != nil {
+		t.Fatalf("RecordWar: %v", err)
+	}
+	if got, want := stats(t, s, "alice").Wars, recentWars+3; got != want {
+		t.Errorf("got %d wars for alice, want %d", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+package lobby
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/jsonfile"
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+// Registry tracks the games hosted on the broker in a shared JSON file.
+// Servers sharing the file share the registry: lobby requests are spread
+// over the servers, and whichever server gets one applies it for all of
+// them.
+type Registry struct {
+	file *jsonfile.File
+}
+
+func Open(path string) (*Registry, error) {
+	file, err := jsonfile.Open(path, "games")
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{file: file}, nil
+}
+
+func (r *Registry) Close() error {
+	return r.file.Close()
+}
+
+// ValidGameID rejects IDs that would break routing keys and queue names.
+func ValidGameID(id string) error {
+	if id == "" {
+		return errors.New("game ID must not be empty")
+	}
+	if strings.ContainsAny(id, " \t\n.*#") {
+		return fmt.Errorf("game ID %q must be a single word without '.', '*' or '#'", id)
+	}
+	return nil
+}
+
+func (r *Registry) load() (map[string]*routing.GameInfo, error) {
+	games := map[string]*routing.GameInfo{}
+	err := r.file.Read(&games)
+	return games, err
+}
+
+// update applies change to the games, and saves them if it changed any.
+func (r *Registry) update(change func(games map[string]*routing.GameInfo) (bool, error)) error {
+	games := map[string]*routing.GameInfo{}
+	return r.file.Update(&games, func() (bool, error) {
+		return change(games)
+	})
+}
+
+func (r *Registry) Create(id string) error {
+	if err := ValidGameID(id); err != nil {
+		return err
+	}
+	return r.update(func(games map[string]*routing.GameInfo) (bool, error) {
+		if _, ok := games[id]; ok {
+			return false, fmt.Errorf("game %s already exists", id)
+		}
+		games[id] = &routing.GameInfo{
+			ID:        id,
+			Players:   []string{},
+			CreatedAt: time.Now(),
+		}
+		return true, nil
+	})
+}
+
+func (r *Registry) Join(id, username string) (routing.GameInfo, error) {
+	var info routing.GameInfo
+	err := r.update(func(games map[string]*routing.GameInfo) (bool, error) {
+		g, ok := games[id]
+		if !ok {
+			return false, fmt.Errorf("game %s does not exist", id)
+		}
+		i := sort.SearchStrings(g.Players, username)
+		joined := i < len(g.Players) && g.Players[i] == username
+		if !joined {
+			g.Players = append(g.Players[:i], append([]string{username}, g.Players[i:]...)...)
+		}
+		info = *g
+		return !joined, nil
+	})
+	return info, err
+}
+
+func (r *Registry) Leave(id, username string) error {
+	return r.update(func(games map[string]*routing.GameInfo) (bool, error) {
+		g, ok := games[id]
+		if !ok {
+			return false, nil
+		}
+		i := sort.SearchStrings(g.Players, username)
+		if i == len(g.Players) || g.Players[i] != username {
+			return false, nil
+		}
+		g.Players = append(g.Players[:i], g.Players[i+1:]...)
+		return true, nil
+	})
+}
+
+func (r *Registry) SetPaused(id string, paused bool) error {
+	return r.update(func(games map[string]*routing.GameInfo) (bool, error) {
+		g, ok := games[id]
+		if !ok {
+			return false, fmt.Errorf("game %s does not exist", id)
+		}
+		changed := g.Paused != paused
+		g.Paused = paused
+		return changed, nil
+	})
+}
+
+// Get returns a game, or an error if it doesn't exist.
+func (r *Registry) Get(id string) (routing.GameInfo, error) {
+	games, err := r.load()
+	if err != nil {
+		return routing.GameInfo{}, err
+	}
+	g, ok := games[id]
+	if !ok {
+		return routing.GameInfo{}, fmt.Errorf("game %s does not exist", id)
+	}
+	return *g, nil
+}
+
+func (r *Registry) List() ([]routing.GameInfo, error) {
+	games, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	list := []routing.GameInfo{}
+	for _, g := range games {
+		list = append(list, *g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// Handle applies a lobby request and builds the reply for the client.
+// Creating a game also joins it.
+func (r *Registry) Handle(req routing.LobbyRequest) routing.LobbyReply {
+	reply := routing.LobbyReply{RequestID: req.RequestID}
+	var err error
+	switch req.Action {
+	case routing.LobbyList:
+		reply.Games, err = r.List()
+	case routing.LobbyCreate:
+		err = r.Create(req.GameID)
+		if err == nil {
+			var info routing.GameInfo
+			info, err = r.Join(req.GameID, req.Username)
+			reply.Games = []routing.GameInfo{info}
+		}
+	case routing.LobbyJoin:
+		var info routing.GameInfo
+		info, err = r.Join(req.GameID, req.Username)
+		reply.Games = []routing.GameInfo{info}
+	case routing.LobbyLeave:
+		err = r.Leave(req.GameID, req.Username)
+	default:
+		err = fmt.Errorf("unknown lobby action %q", req.Action)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+		reply.Games = nil
+		return reply
+	}
+	reply.OK = true
+	return reply
+}
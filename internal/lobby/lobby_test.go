@@ -0,0 +1,79 @@
+package lobby
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+func TestRegistriesShareTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "games.json")
+	first, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer first.Close()
+	second, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer second.Close()
+
+	reply := first.Handle(routing.LobbyRequest{Action: routing.LobbyCreate, GameID: "g1", Username: "alice"})
+	if !reply.OK {
+		t.Fatalf("create: %s", reply.Error)
+	}
+	reply = second.Handle(routing.LobbyRequest{Action: routing.LobbyJoin, GameID: "g1", Username: "bob"})
+	if !reply.OK {
+		t.Fatalf("join on the other server: %s", reply.Error)
+	}
+	if reply = second.Handle(routing.LobbyRequest{Action: routing.LobbyCreate, GameID: "g1", Username: "bob"}); reply.OK {
+		t.Errorf("created g1 twice")
+	}
+	if err := second.SetPaused("g1", true); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+
+	info, err := first.Get("g1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if len(info.Players) != 2 || info.Players[0] != "alice" || info.Players[1] != "bob" || !info.Paused {
+		t.Errorf("Get(g1) = %+v, want alice and bob in a paused game", info)
+	}
+
+	if err := first.Leave("g1", "alice"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	games, err := second.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(games) != 1 || len(games[0].Players) != 1 || games[0].Players[0] != "bob" {
+		t.Errorf("List() = %+v, want g1 with bob", games)
+	}
+	if _, err := first.Get("g2"); err == nil {
+		t.Errorf("Get(g2) found a game that was never created")
+	}
+}
+
+func TestJoinTwice(t *testing.T) {
+	r, err := Open(filepath.Join(t.TempDir(), "games.json"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+	if err := r.Create("g1"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		info, err := r.Join("g1", "alice")
+		if err != nil || len(info.Players) != 1 {
+			t.Errorf("join %d = %+v, %v, want alice once", i+1, info, err)
+		}
+	}
+	if err := r.Create("bad.id"); err == nil {
+		t.Errorf("Create(bad.id) = nil, want error")
+	}
+}
@@ -0,0 +1,278 @@
+package login
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/auth"
+	"github.com/x6Nenko/peril/internal/pubsub"
+	"github.com/x6Nenko/peril/internal/routing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const Timeout = 5 * time.Second
+
+// Credentials is what a player needs after logging in: a signer for
+// everything they publish, and the keys to check what other players and
+// the servers publish.
+type Credentials struct {
+	Username string
+	// ServerID is the server that accepted the login, which the player
+	// reports their army to
+	ServerID string
+	Signer   *pubsub.Signer
+	Players  *pubsub.KeyRing
+	Servers  pubsub.TrustedKey
+	// Registered is set if the login created the account
+	Registered bool
+}
+
+func newToken(size int) string {
+	b := make([]byte, size)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type client struct {
+	conn      *amqp.Connection
+	done      chan struct{}
+	publishCh *amqp.Channel
+}
+
+// listen declares one of the client's private queues, which only the
+// servers publish to, and passes the replies on.
+func (c *client) listen(queueName string, opts ...pubsub.SubscribeOption) (<-chan routing.AuthReply, error) {
+	replies := make(chan routing.AuthReply, 10)
+	opts = append(opts, pubsub.WithDone(c.done))
+	err := pubsub.SubscribeJSON(
+		c.conn,
+		"",
+		queueName,
+		"",
+		pubsub.Transient,
+		func(reply routing.AuthReply) pubsub.AckType {
+			select {
+			case replies <- reply:
+			default:
+			}
+			return pubsub.Ack
+		},
+		opts...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+func wait(replies <-chan routing.AuthReply, requestID string) (routing.AuthReply, error) {
+	timeout := time.After(Timeout)
+	for {
+		select {
+		case reply := <-replies:
+			if reply.RequestID != requestID {
+				continue
+			}
+			if !reply.OK {
+				return reply, errors.New(reply.Error)
+			}
+			return reply, nil
+		case <-timeout:
+			return routing.AuthReply{}, errors.New("no server answered, is the Peril server running?")
+		}
+	}
+}
+
+// hello asks the servers to introduce themselves, and picks the first one
+// to answer. The hello is the only part of the login anyone can read, so
+// anyone could answer it; with a pinned key, only answers signed with it
+// count.
+func (c *client) hello(pinned ed25519.PublicKey) (routing.AuthReply, error) {
+	req := routing.AuthRequest{
+		RequestID: newToken(8),
+		ReplyTo:   newToken(8),
+		Action:    routing.AuthHello,
+	}
+	opts := []pubsub.SubscribeOption{}
+	if pinned != nil {
+		opts = append(opts, pubsub.WithVerifier(pubsub.TrustedKey(pinned)))
+	}
+	replies, err := c.listen(routing.Key(routing.AuthPrefix, routing.HelloSlug, req.ReplyTo), opts...)
+	if err != nil {
+		return routing.AuthReply{}, err
+	}
+	helloKey := routing.Key(routing.AuthPrefix, routing.HelloSlug)
+	err = pubsub.PublishJSON(c.publishCh, routing.ExchangePerilTopic, helloKey, req)
+	if err != nil {
+		return routing.AuthReply{}, err
+	}
+	server, err := wait(replies, req.RequestID)
+	if err != nil {
+		return server, err
+	}
+	if server.ServerID == "" || len(server.ServerKey) != ed25519.PublicKeySize {
+		return server, errors.New("the server did not introduce itself properly")
+	}
+	if pinned != nil && !pinned.Equal(ed25519.PublicKey(server.ServerKey)) {
+		return server, fmt.Errorf("server %s does not have the pinned key", server.ServerID)
+	}
+	return server, nil
+}
+
+// request sends an auth request to the server's private queue. The reply
+// comes back on ours, whose name only that server learns.
+func (c *client) request(server routing.AuthReply, replyTo string, replies <-chan routing.AuthReply, action routing.AuthAction, username, password string, publicKey ed25519.PublicKey) (routing.AuthReply, error) {
+	req := routing.AuthRequest{
+		RequestID: newToken(8),
+		ReplyTo:   replyTo,
+		Action:    action,
+		Username:  username,
+		Password:  password,
+		PublicKey: publicKey,
+	}
+	serverQueue := routing.Key(routing.AuthPrefix, routing.ServerSlug, server.ServerID)
+	err := pubsub.PublishJSON(c.publishCh, "", serverQueue, req)
+	if err != nil {
+		return routing.AuthReply{}, err
+	}
+	return wait(replies, req.RequestID)
+}
+
+// ServerKey decodes the server key from the config. Without one, it falls
+// back on the key learned on the first login, if there was one.
+func ServerKey(configured string) (ed25519.PublicKey, error) {
+	if configured == "" {
+		path, err := knownKeyPath()
+		if err != nil {
+			return nil, nil
+		}
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		configured = strings.TrimSpace(string(data))
+	}
+	key, err := hex.DecodeString(configured)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("server key must be %d hex encoded bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// knownKeyPath is where the key learned on the first login is kept.
+func knownKeyPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "peril", "server_key"), nil
+}
+
+func rememberServerKey(key ed25519.PublicKey) error {
+	path, err := knownKeyPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600)
+}
+
+// Login logs a player in, registering the username first if it is new. A
+// fresh key pair is made for every login. The password only ever travels
+// between private queues, never through an exchange anyone can bind to.
+//
+// Only a server with the server key is trusted with the password. Without
+// one, the first server to answer is trusted, and its key is remembered for
+// later logins.
+func Login(conn *amqp.Connection, publishCh *amqp.Channel, username, password string, serverKey ed25519.PublicKey) (Credentials, error) {
+	c := &client{
+		conn:      conn,
+		done:      make(chan struct{}),
+		publishCh: publishCh,
+	}
+	defer close(c.done)
+	server, err := c.hello(serverKey)
+	if err != nil {
+		return Credentials{}, err
+	}
+	servers := pubsub.TrustedKey(server.ServerKey)
+	replyTo := newToken(16)
+	replies, err := c.listen(routing.Key(routing.AuthPrefix, routing.ReplySlug, replyTo), pubsub.WithVerifier(servers))
+	if err != nil {
+		return Credentials{}, err
+	}
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	registered := false
+	reply, err := c.request(server, replyTo, replies, routing.AuthLogin, username, password, publicKey)
+	if err != nil && err.Error() == auth.ErrUnknownUser.Error() {
+		registered = true
+		reply, err = c.request(server, replyTo, replies, routing.AuthRegister, username, password, publicKey)
+	}
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	players := pubsub.NewKeyRing()
+	for player, key := range reply.Keys {
+		err = players.Add(player, key)
+		if err != nil {
+			log.Printf("ignoring key of %s: %v", player, err)
+		}
+	}
+	if serverKey == nil {
+		log.Printf("trusting server key %x from now on", server.ServerKey)
+		err = rememberServerKey(server.ServerKey)
+		if err != nil {
+			log.Printf("could not remember the server key: %v", err)
+		}
+	}
+	return Credentials{
+		Username:   username,
+		ServerID:   server.ServerID,
+		Signer:     pubsub.NewSigner(publishCh, username, privateKey),
+		Players:    players,
+		Servers:    servers,
+		Registered: registered,
+	}, nil
+}
+
+// FollowKeys keeps Players up to date with the keys of players who log in
+// later, as announced by the servers.
+func (c Credentials) FollowKeys(conn *amqp.Connection, queueName string, opts ...pubsub.SubscribeOption) error {
+	opts = append(opts, pubsub.WithVerifier(c.Servers))
+	return pubsub.SubscribeJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		queueName,
+		routing.Key(routing.KeysPrefix, "*"),
+		pubsub.Transient,
+		func(ka routing.KeyAnnouncement) pubsub.AckType {
+			err := c.Players.Add(ka.Username, ka.PublicKey)
+			if err != nil {
+				log.Printf("ignoring key announcement: %v", err)
+				return pubsub.NackDiscard
+			}
+			return pubsub.Ack
+		},
+		opts...,
+	)
+}
@@ -0,0 +1,88 @@
+package login
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newPublicKey(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	return public
+}
+
+func TestServerKey(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	key := newPublicKey(t)
+
+	tests := []struct {
+		name       string
+		configured string
+		want       ed25519.PublicKey
+		wantErr    string
+	}{
+		{"none", "", nil, ""},
+		{"configured", hex.EncodeToString(key), key, ""},
+		{"not hex", "server", nil, "hex encoded"},
+		{"too short", hex.EncodeToString(key[:16]), nil, "hex encoded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ServerKey(tt.configured)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ServerKey(%q) = %v, want error containing %q", tt.configured, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ServerKey(%q): %v", tt.configured, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ServerKey(%q) = %x, want %x", tt.configured, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRememberServerKey(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	learned := newPublicKey(t)
+	if err := rememberServerKey(learned); err != nil {
+		t.Fatalf("rememberServerKey: %v", err)
+	}
+
+	got, err := ServerKey("")
+	if err != nil {
+		t.Fatalf("ServerKey: %v", err)
+	}
+	if !got.Equal(learned) {
+		t.Errorf("ServerKey() = %x, want the remembered %x", got, learned)
+	}
+
+	// A configured key wins over the remembered one
+	configured := newPublicKey(t)
+	got, err = ServerKey(hex.EncodeToString(configured))
+	if err != nil {
+		t.Fatalf("ServerKey: %v", err)
+	}
+	if !got.Equal(configured) {
+		t.Errorf("ServerKey(configured) = %x, want %x", got, configured)
+	}
+
+	path := filepath.Join(dir, "peril", "server_key")
+	if err := os.WriteFile(path, []byte("garbage\n"), 0600); err != nil {
+		t.Fatalf("could not write %s: %v", path, err)
+	}
+	if _, err := ServerKey(""); err == nil {
+		t.Errorf("ServerKey() with a broken remembered key = nil, want error")
+	}
+}
@@ -0,0 +1,66 @@
+package logstore
+
+import (
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+type pendingLog struct {
+	log  routing.GameLog
+	done chan error
+}
+
+// Batcher groups the logs of concurrent writers into one Write, so a busy
+// consumer doesn't pay for a write per message.
+type Batcher struct {
+	store   Store
+	size    int
+	delay   time.Duration
+	pending chan pendingLog
+}
+
+// NewBatcher writes a batch once it holds size logs, or delay after its
+// first log arrived.
+func NewBatcher(store Store, size int, delay time.Duration) *Batcher {
+	b := &Batcher{
+		store:   store,
+		size:    size,
+		delay:   delay,
+		pending: make(chan pendingLog),
+	}
+	go b.run()
+	return b
+}
+
+// Write stores a log with the next batch and returns once it is written.
+func (b *Batcher) Write(gl routing.GameLog) error {
+	done := make(chan error, 1)
+	b.pending <- pendingLog{log: gl, done: done}
+	return <-done
+}
+
+func (b *Batcher) run() {
+	for first := range b.pending {
+		batch := []pendingLog{first}
+		timeout := time.After(b.delay)
+	collect:
+		for len(batch) < b.size {
+			select {
+			case p := <-b.pending:
+				batch = append(batch, p)
+			case <-timeout:
+				break collect
+			}
+		}
+
+		logs := make([]routing.GameLog, len(batch))
+		for i, p := range batch {
+			logs[i] = p.log
+		}
+		err := b.store.Write(logs)
+		for _, p := range batch {
+			p.done <- err
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package logstore
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+// recordingStore keeps the batches written to it.
+type recordingStore struct {
+	mu      sync.Mutex
+	batches [][]routing.GameLog
+	err     error
+}
+
+func (s *recordingStore) Write(logs []routing.GameLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, logs)
+	return s.err
+}
+
+func (s *recordingStore) Query(q Query) ([]routing.GameLog, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) Close() error {
+	return nil
+}
+
+func (s *recordingStore) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sizes := []int{}
+	for _, batch := range s.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+// writeAll writes the logs from one goroutine each, and waits for them.
+func writeAll(b *Batcher, logs []routing.GameLog) []error {
+	errs := make([]error, len(logs))
+	var wg sync.WaitGroup
+	for i, gl := range logs {
+		wg.Add(1)
+		go func(i int, gl routing.GameLog) {
+			defer wg.Done()
+			errs[i] = b.Write(gl)
+		}(i, gl)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestBatcherFillsBatches(t *testing.T) {
+	store := &recordingStore{}
+	// The delay is long enough that only full batches get written
+	b := NewBatcher(store, 5, time.Hour)
+	for _, err := range writeAll(b, logsAt(10, "alice")) {
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	sizes := store.sizes()
+	if len(sizes) != 2 || sizes[0] != 5 || sizes[1] != 5 {
+		t.Errorf("batch sizes = %v, want [5 5]", sizes)
+	}
+}
+
+func TestBatcherWritesAfterDelay(t *testing.T) {
+	store := &recordingStore{}
+	b := NewBatcher(store, 100, 10*time.Millisecond)
+	begin := time.Now()
+	if err := b.Write(logsAt(1, "alice")[0]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if waited := time.Since(begin); waited < 10*time.Millisecond {
+		t.Errorf("a lone log was written after %v, want it to wait for others", waited)
+	}
+	if sizes := store.sizes(); len(sizes) != 1 || sizes[0] != 1 {
+		t.Errorf("batch sizes = %v, want [1]", sizes)
+	}
+}
+
+func TestBatcherReturnsTheStoreError(t *testing.T) {
+	store := &recordingStore{err: errors.New("disk full")}
+	b := NewBatcher(store, 3, time.Hour)
+	for i, err := range writeAll(b, logsAt(3, "alice")) {
+		if err == nil || err.Error() != "disk full" {
+			t.Errorf("Write(%d) = %v, want the store's error", i, err)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package logstore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/filelock"
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+// rotatedSuffix follows the log file's name in its rotated copies, e.g.
+// game.log.20240102-150405.000000000, with .gz once compressed. It is the
+// time of the rotation and sorts in time order.
+const rotatedSuffix = "20060102-150405.000000000"
+
+// maxLineLength is the longest line a query reads. Longer lines are
+// skipped, like lines that don't parse.
+const maxLineLength = 1024 * 1024
+
+// FileStore appends logs as lines of text, one write per batch. Once the
+// current file is too big or too old it is renamed with a timestamp, and
+// optionally compressed and eventually deleted.
+//
+// Servers sharing the file take turns through a lock file next to it, so
+// their batches never interleave and only one of them rotates. The lock
+// file also holds the time the current file got its first log, by the
+// clock of the server that wrote it.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	opts Options
+	lock *os.File
+	f    *os.File
+	w    *bufio.Writer
+	// cleaning is held while old files are compressed and deleted, and
+	// cleaners counts the cleanups running
+	cleaning sync.Mutex
+	cleaners sync.WaitGroup
+}
+
+func OpenFile(path string, opts Options) (*FileStore, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open logs lock file: %v", err)
+	}
+	s := &FileStore{path: path, opts: opts, lock: lock}
+	err = s.open()
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	// Finish the work of a server that stopped while cleaning up
+	s.startClean()
+	return s, nil
+}
+
+func (s *FileStore) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open logs file: %v", err)
+	}
+	s.f = f
+	s.w = bufio.NewWriterSize(f, 64*1024)
+	return nil
+}
+
+// started reads when the current file got its first log from the lock
+// file, or the zero time if it has none yet. Call it with the lock held.
+func (s *FileStore) started() time.Time {
+	b := make([]byte, 64)
+	n, _ := s.lock.ReadAt(b, 0)
+	started, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b[:n])))
+	if err != nil {
+		return time.Time{}
+	}
+	return started
+}
+
+// setStarted records when the current file got its first log in the lock
+// file, or clears it for the zero time. Call it with the lock held.
+func (s *FileStore) setStarted(started time.Time) error {
+	err := s.lock.Truncate(0)
+	if err != nil || started.IsZero() {
+		return err
+	}
+	_, err = s.lock.WriteAt([]byte(started.UTC().Format(time.RFC3339Nano)+"\n"), 0)
+	return err
+}
+
+func (s *FileStore) Write(logs []routing.GameLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := filelock.Lock(s.lock)
+	if err != nil {
+		return fmt.Errorf("could not lock logs file: %v", err)
+	}
+	defer filelock.Unlock(s.lock)
+
+	// Follow another server's rotation before writing
+	err = s.reopenIfMoved()
+	if err != nil {
+		return err
+	}
+	// Logs carry the time their sender claims, so the file's age is kept
+	// by the servers' clocks instead
+	started := s.started()
+	if started.IsZero() && len(logs) > 0 {
+		started = time.Now()
+		err = s.setStarted(started)
+		if err != nil {
+			return fmt.Errorf("could not write logs lock file: %v", err)
+		}
+	}
+	for _, gl := range logs {
+		s.w.WriteString(Format(gl))
+		s.w.WriteByte('\n')
+	}
+	err = s.w.Flush()
+	if err != nil {
+		return fmt.Errorf("could not write to logs file: %v", err)
+	}
+	return s.rotateIfDue(started)
+}
+
+func (s *FileStore) reopenIfMoved() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not read logs file: %v", err)
+	}
+	current, err := os.Stat(s.path)
+	if err == nil && os.SameFile(info, current) {
+		return nil
+	}
+	s.f.Close()
+	return s.open()
+}
+
+// rotateIfDue moves the current file aside once it is too big or too old,
+// and starts a new one.
+func (s *FileStore) rotateIfDue(started time.Time) error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not read logs file: %v", err)
+	}
+	full := s.opts.MaxSize > 0 && info.Size() >= s.opts.MaxSize
+	old := s.opts.MaxAge > 0 && !started.IsZero() && time.Since(started) >= s.opts.MaxAge
+	if !full && !old {
+		return nil
+	}
+
+	s.f.Close()
+	err = os.Rename(s.path, s.path+"."+time.Now().UTC().Format(rotatedSuffix))
+	if err != nil {
+		return fmt.Errorf("could not rotate logs file: %v", err)
+	}
+	err = s.setStarted(time.Time{})
+	if err != nil {
+		return fmt.Errorf("could not write logs lock file: %v", err)
+	}
+	err = s.open()
+	if err != nil {
+		return err
+	}
+	s.startClean()
+	return nil
+}
+
+func (s *FileStore) startClean() {
+	s.cleaners.Add(1)
+	go func() {
+		defer s.cleaners.Done()
+		s.clean()
+	}()
+}
+
+// segment is a rotated log file.
+type segment struct {
+	name    string
+	rotated time.Time
+	gzipped bool
+}
+
+// segments lists the rotated files, oldest first. A file being compressed
+// is listed once.
+func (s *FileStore) segments() ([]segment, error) {
+	names, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]segment{}
+	for _, name := range names {
+		suffix := strings.TrimPrefix(name, s.path+".")
+		gzipped := strings.HasSuffix(suffix, ".gz")
+		rotated, err := time.Parse(rotatedSuffix, strings.TrimSuffix(suffix, ".gz"))
+		if err != nil {
+			continue
+		}
+		key := strings.TrimSuffix(name, ".gz")
+		if _, ok := found[key]; ok && !gzipped {
+			continue
+		}
+		found[key] = segment{name: name, rotated: rotated, gzipped: gzipped}
+	}
+
+	segments := []segment{}
+	for _, seg := range found {
+		segments = append(segments, seg)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].rotated.Before(segments[j].rotated)
+	})
+	return segments, nil
+}
+
+// clean compresses rotated files and deletes those past retention. Several
+// servers may clean at once, so files vanishing underneath are fine.
+func (s *FileStore) clean() {
+	s.cleaning.Lock()
+	defer s.cleaning.Unlock()
+	segments, err := s.segments()
+	if err != nil {
+		log.Printf("could not list old logs files: %v", err)
+		return
+	}
+
+	keep := []segment{}
+	for _, seg := range segments {
+		if s.opts.Retention > 0 && time.Since(seg.rotated) > s.opts.Retention {
+			remove(seg.name)
+			continue
+		}
+		keep = append(keep, seg)
+	}
+	if s.opts.MaxBackups > 0 && len(keep) > s.opts.MaxBackups {
+		for _, seg := range keep[:len(keep)-s.opts.MaxBackups] {
+			remove(seg.name)
+		}
+		keep = keep[len(keep)-s.opts.MaxBackups:]
+	}
+
+	if !s.opts.Compress {
+		return
+	}
+	for _, seg := range keep {
+		if seg.gzipped {
+			continue
+		}
+		err := compress(seg.name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("could not compress %s: %v", seg.name, err)
+		}
+	}
+}
+
+func remove(name string) {
+	err := os.Remove(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("could not delete old logs file: %v", err)
+	}
+}
+
+// compress replaces name with name.gz. The gzip is written under a
+// temporary name first, so readers never see half of it.
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".gz.tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := gzip.NewWriter(tmp)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), name+".gz")
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Query scans the rotated files and then the current one, skipping lines
+// it can't parse.
+func (s *FileStore) Query(q Query) ([]routing.GameLog, error) {
+	segments, err := s.segments()
+	if err != nil {
+		return nil, fmt.Errorf("could not list logs files: %v", err)
+	}
+	segments = append(segments, segment{name: s.path})
+
+	logs := []routing.GameLog{}
+	for _, seg := range segments {
+		// Whole files older than the query can be skipped
+		if !q.Since.IsZero() && !seg.rotated.IsZero() && seg.rotated.Before(q.Since) {
+			continue
+		}
+		err = scanFile(seg, func(gl routing.GameLog) {
+			if !q.matches(gl) {
+				return
+			}
+			logs = append(logs, gl)
+			if q.Limit > 0 && len(logs) > 2*q.Limit {
+				logs = append(logs[:0], logs[len(logs)-q.Limit:]...)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if q.Limit > 0 && len(logs) > q.Limit {
+		logs = logs[len(logs)-q.Limit:]
+	}
+	return logs, nil
+}
+
+func scanFile(seg segment, fn func(routing.GameLog)) error {
+	f, err := os.Open(seg.name)
+	if errors.Is(err, os.ErrNotExist) && !seg.gzipped && !seg.rotated.IsZero() {
+		// Compressed since it was listed
+		seg.name += ".gz"
+		seg.gzipped = true
+		f, err = os.Open(seg.name)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		// Deleted since it was listed
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not open logs file: %v", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if seg.gzipped {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("could not read logs file %s: %v", seg.name, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, tooLong, err := readLine(reader, maxLineLength)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read logs file %s: %v", seg.name, err)
+		}
+		if tooLong {
+			continue
+		}
+		gl, err := parse(line)
+		if err != nil {
+			continue
+		}
+		fn(gl)
+	}
+}
+
+// readLine reads the next line without its newline. A line longer than max
+// is read past and reported as too long instead of returned.
+func readLine(r *bufio.Reader, max int) (string, bool, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, bytes.TrimSuffix(chunk, []byte("\n"))...)
+			if len(line) > max {
+				line, tooLong = nil, true
+			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if errors.Is(err, io.EOF) && (len(line) > 0 || tooLong) {
+			// The last line has no newline
+			err = nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		return string(line), tooLong, nil
+	}
+}
+
+// Close waits for old files to be cleaned up.
+func (s *FileStore) Close() error {
+	s.cleaners.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.w.Flush()
+	if closeErr := s.f.Close(); err == nil {
+		err = closeErr
+	}
+	s.lock.Close()
+	return err
+}
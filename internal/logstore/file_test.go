@@ -0,0 +1,330 @@
+package logstore
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+func openTestFile(t *testing.T, opts Options) (*FileStore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "game.log")
+	s, err := OpenFile(path, opts)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func TestFileStoreQueryLimit(t *testing.T) {
+	s, _ := openTestFile(t, Options{})
+	logs := logsAt(25, "alice")
+	logs = append(logs, logsAt(3, "bob")...)
+	if err := s.Write(logs); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		// 25 matches trims the kept logs more than once on the way
+		{"limit", Query{Username: "alice", Limit: 4}, []string{"log 22", "log 23", "log 24", "log 25"}},
+		{"limit of one", Query{Username: "alice", Limit: 1}, []string{"log 25"}},
+		{"limit over the matches", Query{Username: "bob", Limit: 10}, []string{"log 1", "log 2", "log 3"}},
+		{"no limit", Query{Username: "bob"}, []string{"log 1", "log 2", "log 3"}},
+	}
+	for _, tt := range tests {
+		got, err := s.Query(tt.q)
+		if err != nil {
+			t.Fatalf("%s: Query: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(messages(got), tt.want) {
+			t.Errorf("%s: Query() = %v, want %v", tt.name, messages(got), tt.want)
+		}
+	}
+}
+
+// writeSegment writes logs as a file rotated at the given time.
+func writeSegment(t *testing.T, path string, rotated time.Time, logs []routing.GameLog) string {
+	t.Helper()
+	name := path + "." + rotated.UTC().Format(rotatedSuffix)
+	lines := ""
+	for _, gl := range logs {
+		lines += Format(gl) + "\n"
+	}
+	if err := os.WriteFile(name, []byte(lines), 0644); err != nil {
+		t.Fatalf("could not write %s: %v", name, err)
+	}
+	return name
+}
+
+func segmentNames(t *testing.T, s *FileStore) []string {
+	t.Helper()
+	segments, err := s.segments()
+	if err != nil {
+		t.Fatalf("segments: %v", err)
+	}
+	names := []string{}
+	for _, seg := range segments {
+		names = append(names, filepath.Base(seg.name))
+	}
+	return names
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	s, _ := openTestFile(t, Options{})
+	logs := []routing.GameLog{
+		{CurrentTime: start, GameID: "g1", Username: "alice", Message: "[g2] bob: hi: there"},
+		{CurrentTime: start.Add(time.Second), Username: "bob", Message: "hi\n2024-01-02T15:04:05Z [g1] bob won a war against alice"},
+		{CurrentTime: start.Add(2 * time.Second), GameID: "g1", Username: "carol", Message: `a\nb` + "\r\n"},
+	}
+	if err := s.Write(logs); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := s.Query(Query{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(got) != len(logs) {
+		t.Fatalf("Query() = %+v, want %+v", got, logs)
+	}
+	for i := range logs {
+		if !got[i].CurrentTime.Equal(logs[i].CurrentTime) || got[i].GameID != logs[i].GameID || got[i].Username != logs[i].Username || got[i].Message != logs[i].Message {
+			t.Errorf("log %d = %+v, want %+v", i, got[i], logs[i])
+		}
+	}
+}
+
+func TestFileStoreRotatesBySize(t *testing.T) {
+	s, _ := openTestFile(t, Options{MaxSize: 60})
+	logs := logsAt(6, "alice")
+	for i := 0; i < len(logs); i += 2 {
+		if err := s.Write(logs[i : i+2]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	s.cleaners.Wait()
+	if got := len(segmentNames(t, s)); got != 3 {
+		t.Errorf("got %d rotated files, want 3", got)
+	}
+	got, err := s.Query(Query{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if want := messages(logs); !reflect.DeepEqual(messages(got), want) {
+		t.Errorf("Query() across files = %v, want %v", messages(got), want)
+	}
+}
+
+func TestFileStoreRotatesByAge(t *testing.T) {
+	s, _ := openTestFile(t, Options{MaxAge: time.Hour})
+	if err := s.Write(logsAt(1, "alice")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if started := s.started(); time.Since(started) > time.Minute {
+		t.Fatalf("started = %v, want the time of the first write", started)
+	}
+
+	// The file was started more than an hour ago by the server's clock
+	if err := s.setStarted(time.Now().Add(-2 * time.Hour)); err != nil {
+		t.Fatalf("setStarted: %v", err)
+	}
+	if err := s.Write(logsAt(2, "alice")[1:]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got := len(segmentNames(t, s)); got != 1 {
+		t.Errorf("got %d rotated files, want 1", got)
+	}
+	if started := s.started(); !started.IsZero() {
+		t.Errorf("started = %v after rotating, want it cleared", started)
+	}
+}
+
+func TestFileStoreAgeIgnoresLogTimes(t *testing.T) {
+	s, _ := openTestFile(t, Options{MaxAge: time.Hour})
+	logs := logsAt(2, "mallory")
+	for i := range logs {
+		logs[i].CurrentTime = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	for _, gl := range logs {
+		if err := s.Write([]routing.GameLog{gl}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if got := segmentNames(t, s); len(got) != 0 {
+		t.Errorf("rotated %v for logs claiming to be old, want no rotation", got)
+	}
+}
+
+func TestFileStoreSharesStartTime(t *testing.T) {
+	a, path := openTestFile(t, Options{MaxAge: time.Hour})
+	b, err := OpenFile(path, Options{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer b.Close()
+	if err := a.Write(logsAt(1, "alice")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got, want := b.started(), a.started(); got.IsZero() || !got.Equal(want) {
+		t.Errorf("started by the other server = %v, want %v", got, want)
+	}
+}
+
+func TestFileStoreCompress(t *testing.T) {
+	s, path := openTestFile(t, Options{MaxSize: 1, Compress: true})
+	logs := logsAt(3, "alice")
+	for _, gl := range logs {
+		if err := s.Write([]routing.GameLog{gl}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	s.cleaners.Wait()
+	names := segmentNames(t, s)
+	if len(names) != 3 {
+		t.Fatalf("rotated files = %v, want 3", names)
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("%s was not compressed", name)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(path), strings.TrimSuffix(name, ".gz"))); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s was kept next to its gzip: %v", strings.TrimSuffix(name, ".gz"), err)
+		}
+	}
+	got, err := s.Query(Query{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if want := messages(logs); !reflect.DeepEqual(messages(got), want) {
+		t.Errorf("Query() from gzips = %v, want %v", messages(got), want)
+	}
+}
+
+func TestScanFileCompressedSinceListed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.log")
+	rotated := start
+	name := writeSegment(t, path, rotated, logsAt(2, "alice"))
+	if err := compress(name); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+
+	got := []routing.GameLog{}
+	err := scanFile(segment{name: name, rotated: rotated}, func(gl routing.GameLog) {
+		got = append(got, gl)
+	})
+	if err != nil {
+		t.Fatalf("scanFile: %v", err)
+	}
+	if want := []string{"log 1", "log 2"}; !reflect.DeepEqual(messages(got), want) {
+		t.Errorf("scanFile() = %v, want %v", messages(got), want)
+	}
+
+	if err := scanFile(segment{name: path + ".gone", rotated: rotated}, func(routing.GameLog) {}); err != nil {
+		t.Errorf("scanFile() of a deleted file = %v, want nil", err)
+	}
+}
+
+func TestScanFileSkipsLongLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.log")
+	long := routing.GameLog{CurrentTime: start, Username: "alice", Message: strings.Repeat("a", maxLineLength)}
+	lines := Format(long) + "\n" + Format(logsAt(1, "bob")[0]) + "\n" + Format(long)
+	if err := os.WriteFile(path, []byte(lines), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	got := []routing.GameLog{}
+	err := scanFile(segment{name: path}, func(gl routing.GameLog) {
+		got = append(got, gl)
+	})
+	if err != nil {
+		t.Fatalf("scanFile: %v", err)
+	}
+	if want := []string{"log 1"}; !reflect.DeepEqual(messages(got), want) {
+		t.Errorf("scanFile() = %v, want %v", messages(got), want)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	// Lines of more than 16 bytes are read in pieces
+	long, longer := strings.Repeat("a", 20), strings.Repeat("a", 21)
+	tests := []struct {
+		name        string
+		text        string
+		want        []string
+		wantTooLong []bool
+	}{
+		{"lines", "ab\ncd\n", []string{"ab", "cd"}, []bool{false, false}},
+		{"no final newline", "ab\ncd", []string{"ab", "cd"}, []bool{false, false}},
+		{"empty line", "\nab\n", []string{"", "ab"}, []bool{false, false}},
+		{"at the limit", long + "\n" + long, []string{long, long}, []bool{false, false}},
+		{"too long", longer + "\nab\n", []string{"", "ab"}, []bool{true, false}},
+		{"too long at the end", "ab\n" + longer, []string{"ab", ""}, []bool{false, true}},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReaderSize(strings.NewReader(tt.text), 16)
+		got, gotTooLong := []string{}, []bool{}
+		for {
+			line, tooLong, err := readLine(r, len(long))
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: readLine: %v", tt.name, err)
+			}
+			got = append(got, line)
+			gotTooLong = append(gotTooLong, tooLong)
+		}
+		if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(gotTooLong, tt.wantTooLong) {
+			t.Errorf("%s: readLine() = %q %v, want %q %v", tt.name, got, gotTooLong, tt.want, tt.wantTooLong)
+		}
+	}
+}
+
+func TestFileStoreClean(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		opts Options
+		ages []time.Duration
+		want []time.Duration
+	}{
+		{"keep everything", Options{}, []time.Duration{72 * time.Hour, time.Hour}, []time.Duration{72 * time.Hour, time.Hour}},
+		{"retention", Options{Retention: 24 * time.Hour}, []time.Duration{72 * time.Hour, 48 * time.Hour, time.Hour}, []time.Duration{time.Hour}},
+		{"max backups", Options{MaxBackups: 2}, []time.Duration{4 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour}, []time.Duration{2 * time.Hour, time.Hour}},
+		{"both", Options{Retention: 24 * time.Hour, MaxBackups: 2}, []time.Duration{48 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour}, []time.Duration{2 * time.Hour, time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "game.log")
+			for _, age := range tt.ages {
+				writeSegment(t, path, now.Add(-age), logsAt(1, "alice"))
+			}
+			s, err := OpenFile(path, tt.opts)
+			if err != nil {
+				t.Fatalf("OpenFile: %v", err)
+			}
+			// Opening cleans up
+			s.Close()
+
+			want := []string{}
+			for _, age := range tt.want {
+				want = append(want, "game.log."+now.Add(-age).UTC().Format(rotatedSuffix))
+			}
+			if got := segmentNames(t, s); !reflect.DeepEqual(got, want) {
+				t.Errorf("kept %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package logstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+// Store keeps game logs. Implementations are safe for concurrent use, and
+// for several servers sharing one store.
+type Store interface {
+	// Write stores a batch of logs at once
+	Write(logs []routing.GameLog) error
+	// Query returns the logs matching q, oldest first
+	Query(q Query) ([]routing.GameLog, error)
+	Close() error
+}
+
+// Query selects logs. Zero fields match everything.
+type Query struct {
+	GameID   string
+	Username string
+	Since    time.Time
+	Until    time.Time
+	// Contains matches logs whose message contains the text
+	Contains string
+	// Limit keeps only the newest logs
+	Limit int
+}
+
+func (q Query) matches(gl routing.GameLog) bool {
+	if q.GameID != "" && gl.GameID != q.GameID {
+		return false
+	}
+	if q.Username != "" && gl.Username != q.Username {
+		return false
+	}
+	if !q.Since.IsZero() && gl.CurrentTime.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !gl.CurrentTime.Before(q.Until) {
+		return false
+	}
+	if q.Contains != "" && !strings.Contains(gl.Message, q.Contains) {
+		return false
+	}
+	return true
+}
+
+// Options tune a store. Backends ignore the options that don't apply.
+type Options struct {
+	// MaxSize is the size in bytes at which the file store starts a new
+	// file, 0 means never
+	MaxSize int64
+	// MaxAge is how long the file store writes to a file before starting
+	// a new one, 0 means forever
+	MaxAge time.Duration
+	// Compress gzips the files the file store is done with
+	Compress bool
+	// MaxBackups is how many old files the file store keeps, 0 means all
+	MaxBackups int
+	// Retention is how long the file store keeps old files, 0 means
+	// forever
+	Retention time.Duration
+}
+
+const (
+	BackendFile   = "file"
+	BackendSQLite = "sqlite"
+)
+
+// Open opens the store of the given backend at path.
+func Open(backend, path string, opts Options) (Store, error) {
+	switch backend {
+	case BackendFile:
+		return OpenFile(path, opts)
+	case BackendSQLite:
+		return OpenSQLite(path)
+	}
+	return nil, fmt.Errorf("unknown log store %q, use %s or %s", backend, BackendFile, BackendSQLite)
+}
+
+// Line breaks in messages are escaped, so a message can't forge the lines
+// after it.
+var (
+	messageEscaper   = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+	messageUnescaper = strings.NewReplacer("\\\\", "\\", "\\n", "\n", "\\r", "\r")
+)
+
+// Format renders a log as a line of the log file, without the newline.
+func Format(gl routing.GameLog) string {
+	message := messageEscaper.Replace(gl.Message)
+	if gl.GameID == "" {
+		return fmt.Sprintf("%v %v: %v", gl.CurrentTime.Format(time.RFC3339), gl.Username, message)
+	}
+	return fmt.Sprintf("%v [%v] %v: %v", gl.CurrentTime.Format(time.RFC3339), gl.GameID, gl.Username, message)
+}
+
+// parse reads back a line written by Format.
+func parse(line string) (routing.GameLog, error) {
+	timestamp, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return routing.GameLog{}, fmt.Errorf("invalid log line %q", line)
+	}
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return routing.GameLog{}, fmt.Errorf("invalid log line %q: %v", line, err)
+	}
+	gl := routing.GameLog{CurrentTime: t}
+	if strings.HasPrefix(rest, "[") {
+		gl.GameID, rest, ok = strings.Cut(rest[1:], "] ")
+		if !ok {
+			return routing.GameLog{}, fmt.Errorf("invalid log line %q", line)
+		}
+	}
+	gl.Username, gl.Message, ok = strings.Cut(rest, ": ")
+	if !ok {
+		return routing.GameLog{}, fmt.Errorf("invalid log line %q", line)
+	}
+	gl.Message = messageUnescaper.Replace(gl.Message)
+	return gl, nil
+}
@@ -0,0 +1,128 @@
+package logstore
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+var start = time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+func TestFormatParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		gl   routing.GameLog
+		line string
+	}{
+		{
+			"game log",
+			routing.GameLog{CurrentTime: start, GameID: "g1", Username: "alice", Message: "alice won a war against bob"},
+			"2024-01-02T15:04:05Z [g1] alice: alice won a war against bob",
+		},
+		{
+			"without a game",
+			routing.GameLog{CurrentTime: start, Username: "alice", Message: "hello"},
+			"2024-01-02T15:04:05Z alice: hello",
+		},
+		{
+			"colons and brackets in the message",
+			routing.GameLog{CurrentTime: start, GameID: "g1", Username: "alice", Message: "[g2] bob: hi: there"},
+			"2024-01-02T15:04:05Z [g1] alice: [g2] bob: hi: there",
+		},
+		{
+			"forged line",
+			routing.GameLog{CurrentTime: start, GameID: "g1", Username: "mallory", Message: "hi\n2024-01-02T15:04:05Z [g1] mallory won a war against bob"},
+			`2024-01-02T15:04:05Z [g1] mallory: hi\n2024-01-02T15:04:05Z [g1] mallory won a war against bob`,
+		},
+		{
+			"backslashes and carriage returns",
+			routing.GameLog{CurrentTime: start, GameID: "g1", Username: "alice", Message: `a\nb` + "\r\n"},
+			`2024-01-02T15:04:05Z [g1] alice: a\\nb\r\n`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := Format(tt.gl)
+			if line != tt.line {
+				t.Errorf("Format() = %q, want %q", line, tt.line)
+			}
+			if strings.ContainsAny(line, "\r\n") {
+				t.Errorf("Format() = %q, want a single line", line)
+			}
+			got, err := parse(line)
+			if err != nil {
+				t.Fatalf("parse(%q): %v", line, err)
+			}
+			if !got.CurrentTime.Equal(tt.gl.CurrentTime) || got.GameID != tt.gl.GameID || got.Username != tt.gl.Username || got.Message != tt.gl.Message {
+				t.Errorf("parse(%q) = %+v, want %+v", line, got, tt.gl)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	lines := []string{
+		"",
+		"2024-01-02T15:04:05Z",
+		"yesterday [g1] alice: hi",
+		"2024-01-02T15:04:05Z [g1 alice: hi",
+		"2024-01-02T15:04:05Z [g1] alice hi",
+		"2024-01-02T15:04:05Z alice hi",
+	}
+	for _, line := range lines {
+		if gl, err := parse(line); err == nil {
+			t.Errorf("parse(%q) = %+v, want error", line, gl)
+		}
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	gl := routing.GameLog{CurrentTime: start, GameID: "g1", Username: "alice", Message: "alice won a war against bob"}
+	tests := []struct {
+		name string
+		q    Query
+		want bool
+	}{
+		{"everything", Query{}, true},
+		{"game", Query{GameID: "g1"}, true},
+		{"other game", Query{GameID: "g2"}, false},
+		{"user", Query{Username: "alice"}, true},
+		{"other user", Query{Username: "bob"}, false},
+		{"since is inclusive", Query{Since: start}, true},
+		{"since later", Query{Since: start.Add(time.Second)}, false},
+		{"until is exclusive", Query{Until: start}, false},
+		{"until later", Query{Until: start.Add(time.Second)}, true},
+		{"contains", Query{Contains: "won a war"}, true},
+		{"does not contain", Query{Contains: "draw"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.q.matches(gl); got != tt.want {
+			t.Errorf("%s: matches() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// logsAt makes n logs a second apart from start, messages numbered from 1.
+func logsAt(n int, username string) []routing.GameLog {
+	logs := []routing.GameLog{}
+	for i := 1; i <= n; i++ {
+		logs = append(logs, routing.GameLog{
+			CurrentTime: start.Add(time.Duration(i) * time.Second),
+			GameID:      "g1",
+			Username:    username,
+			Message:     "log " + strconv.Itoa(i),
+		})
+	}
+	return logs
+}
+
+func messages(logs []routing.GameLog) []string {
+	got := []string{}
+	for _, gl := range logs {
+		got = append(got, gl.Message)
+	}
+	return got
+}
@@ -0,0 +1,134 @@
+package logstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS game_logs (
+	id       INTEGER PRIMARY KEY,
+	time     INTEGER NOT NULL,
+	game_id  TEXT NOT NULL,
+	username TEXT NOT NULL,
+	message  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS game_logs_time ON game_logs (time);
+CREATE INDEX IF NOT EXISTS game_logs_username ON game_logs (username, time);
+CREATE INDEX IF NOT EXISTS game_logs_game ON game_logs (game_id, time);
+`
+
+// SQLiteStore keeps logs in an embedded SQLite database. Several servers
+// can share the file: writers wait for each other instead of failing.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	// WAL lets readers carry on while another server writes
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open log database: %v", err)
+	}
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create log tables: %v", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Write(logs []routing.GameLog) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not write logs: %v", err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("INSERT INTO game_logs (time, game_id, username, message) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("could not write logs: %v", err)
+	}
+	defer stmt.Close()
+	for _, gl := range logs {
+		_, err = stmt.Exec(gl.CurrentTime.UnixNano(), gl.GameID, gl.Username, gl.Message)
+		if err != nil {
+			return fmt.Errorf("could not write logs: %v", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("could not write logs: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Query(q Query) ([]routing.GameLog, error) {
+	conditions := []string{}
+	args := []any{}
+	if q.GameID != "" {
+		conditions = append(conditions, "game_id = ?")
+		args = append(args, q.GameID)
+	}
+	if q.Username != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, q.Username)
+	}
+	if !q.Since.IsZero() {
+		conditions = append(conditions, "time >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		conditions = append(conditions, "time < ?")
+		args = append(args, q.Until.UnixNano())
+	}
+	if q.Contains != "" {
+		conditions = append(conditions, "instr(message, ?) > 0")
+		args = append(args, q.Contains)
+	}
+
+	query := "SELECT time, game_id, username, message FROM game_logs"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// Take the newest logs when limited, and put them back in order below
+	query += " ORDER BY time DESC, id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query logs: %v", err)
+	}
+	defer rows.Close()
+	logs := []routing.GameLog{}
+	for rows.Next() {
+		var nanos int64
+		var gl routing.GameLog
+		err = rows.Scan(&nanos, &gl.GameID, &gl.Username, &gl.Message)
+		if err != nil {
+			return nil, fmt.Errorf("could not query logs: %v", err)
+		}
+		gl.CurrentTime = time.Unix(0, nanos)
+		logs = append(logs, gl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not query logs: %v", err)
+	}
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
+	}
+	return logs, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,49 @@
+package logstore
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+func TestSQLiteStoreQueryOrder(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	defer s.Close()
+
+	logs := logsAt(5, "alice")
+	// Written out of order, and two logs at the same time keep the order
+	// they were written in
+	same := logs[4]
+	same.Message = "log 5b"
+	for _, batch := range [][]routing.GameLog{{logs[2], logs[0]}, {logs[4], same}, {logs[1], logs[3]}} {
+		if err := s.Write(batch); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"all, oldest first", Query{}, []string{"log 1", "log 2", "log 3", "log 4", "log 5", "log 5b"}},
+		{"limit keeps the newest", Query{Limit: 3}, []string{"log 4", "log 5", "log 5b"}},
+		{"since and until", Query{Since: logs[1].CurrentTime, Until: logs[3].CurrentTime}, []string{"log 2", "log 3"}},
+		{"contains", Query{Contains: "5"}, []string{"log 5", "log 5b"}},
+		{"other user", Query{Username: "bob"}, []string{}},
+	}
+	for _, tt := range tests {
+		got, err := s.Query(tt.q)
+		if err != nil {
+			t.Fatalf("%s: Query: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(messages(got), tt.want) {
+			t.Errorf("%s: Query() = %v, want %v", tt.name, messages(got), tt.want)
+		}
+	}
+}
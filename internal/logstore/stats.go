@@ -0,0 +1,82 @@
+package logstore
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+)
+
+// Tags start the messages of the logs the server writes itself, like
+// "[war <warID>] alice won a war against bob". Players' own logs must not
+// start with one, so only the server can write them.
+const (
+	TagWar  = "war"
+	TagChat = "chat"
+)
+
+// Tagged reports whether a message starts with one of the server's tags.
+func Tagged(message string) bool {
+	for _, tag := range []string{TagWar, TagChat} {
+		if strings.HasPrefix(message, "["+tag+" ") || strings.HasPrefix(message, "["+tag+"]") {
+			return true
+		}
+	}
+	return false
+}
+
+// PlayerStats counts the wars of one player.
+type PlayerStats struct {
+	Username string
+	Won      int
+	Lost     int
+	Drawn    int
+}
+
+// war is what the stats need of a war result.
+type war struct {
+	gameID string
+	// winner and loser are the attacker and defender in a draw
+	winner, loser string
+	draw          bool
+}
+
+func newWar(wr gamelogic.WarResult) war {
+	return war{gameID: wr.GameID, winner: wr.Winner(), loser: wr.Loser(), draw: wr.Outcome == gamelogic.WarDraw}
+}
+
+// tally counts the wars of a game, or of every game if gameID is empty,
+// most wins first.
+func tally(wars map[string]war, gameID string) []PlayerStats {
+	byPlayer := map[string]*PlayerStats{}
+	player := func(username string) *PlayerStats {
+		if byPlayer[username] == nil {
+			byPlayer[username] = &PlayerStats{Username: username}
+		}
+		return byPlayer[username]
+	}
+	for _, w := range wars {
+		if gameID != "" && w.gameID != gameID {
+			continue
+		}
+		if w.draw {
+			player(w.winner).Drawn++
+			player(w.loser).Drawn++
+		} else {
+			player(w.winner).Won++
+			player(w.loser).Lost++
+		}
+	}
+
+	stats := []PlayerStats{}
+	for _, s := range byPlayer {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Won != stats[j].Won {
+			return stats[i].Won > stats[j].Won
+		}
+		return stats[i].Username < stats[j].Username
+	})
+	return stats
+}
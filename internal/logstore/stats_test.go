@@ -0,0 +1,23 @@
+package logstore
+
+import "testing"
+
+func TestTagged(t *testing.T) {
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		{"[war 8c1f] alice won a war against bob", true},
+		{"[chat all] hi", true},
+		{"[war] alice won a war against bob", true},
+		{"alice won a war against bob", false},
+		{" [war 8c1f] alice won a war against bob", false},
+		{"[warning] look out", false},
+		{"All warfare is based on deception.", false},
+	}
+	for _, tt := range tests {
+		if got := Tagged(tt.message); got != tt.want {
+			t.Errorf("Tagged(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+}
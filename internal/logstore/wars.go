@@ -0,0 +1,129 @@
+package logstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/x6Nenko/peril/internal/filelock"
+	"github.com/x6Nenko/peril/internal/gamelogic"
+)
+
+// WarLog keeps the results of the wars fought apart from the game logs, one
+// JSON object per line. Servers sharing the file take turns through a lock
+// file next to it. Each one keeps the wars it read in memory, so a war
+// delivered twice is only recorded once.
+type WarLog struct {
+	mu   sync.Mutex
+	lock *os.File
+	f    *os.File
+	// read is how far into the file the wars were read
+	read int64
+	wars map[string]war
+}
+
+func OpenWarLog(path string) (*WarLog, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open wars lock file: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("could not open wars file: %v", err)
+	}
+	return &WarLog{lock: lock, f: f, wars: map[string]war{}}, nil
+}
+
+// catchUp reads the wars written since the last call, by this server or
+// another. A line still being written is left for the next call. Call it
+// with mu held.
+func (l *WarLog) catchUp() error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not read wars file: %v", err)
+	}
+	r := bufio.NewReader(io.NewSectionReader(l.f, l.read, info.Size()-l.read))
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read wars file: %v", err)
+		}
+		l.read += int64(len(line))
+		var wr gamelogic.WarResult
+		if json.Unmarshal(line, &wr) != nil || wr.WarID == "" {
+			continue
+		}
+		if _, ok := l.wars[wr.WarID]; !ok {
+			l.wars[wr.WarID] = newWar(wr)
+		}
+	}
+}
+
+// Record adds a war unless one with the same WarID was recorded before,
+// and reports whether it did.
+func (l *WarLog) Record(wr gamelogic.WarResult) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := filelock.Lock(l.lock)
+	if err != nil {
+		return false, fmt.Errorf("could not lock wars file: %v", err)
+	}
+	defer filelock.Unlock(l.lock)
+
+	err = l.catchUp()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := l.wars[wr.WarID]; ok {
+		return false, nil
+	}
+	line, err := json.Marshal(wr)
+	if err != nil {
+		return false, err
+	}
+	_, err = l.f.Write(append(line, '\n'))
+	if err != nil {
+		return false, fmt.Errorf("could not write to wars file: %v", err)
+	}
+	l.wars[wr.WarID] = newWar(wr)
+	return true, nil
+}
+
+// Recorded reports whether a war with the given WarID was recorded.
+func (l *WarLog) Recorded(warID string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.catchUp()
+	if err != nil {
+		return false, err
+	}
+	_, ok := l.wars[warID]
+	return ok, nil
+}
+
+// Stats returns the stats of a game, or of every game if gameID is empty.
+func (l *WarLog) Stats(gameID string) ([]PlayerStats, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.catchUp()
+	if err != nil {
+		return nil, err
+	}
+	return tally(l.wars, gameID), nil
+}
+
+func (l *WarLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.f.Close()
+	l.lock.Close()
+	return err
+}
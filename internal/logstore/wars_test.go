@@ -0,0 +1,123 @@
+package logstore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+)
+
+func openTestWarLog(t *testing.T, path string) *WarLog {
+	t.Helper()
+	l, err := OpenWarLog(path)
+	if err != nil {
+		t.Fatalf("OpenWarLog: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func warResult(warID, gameID, attacker, defender string, outcome gamelogic.WarResultOutcome) gamelogic.WarResult {
+	return gamelogic.WarResult{
+		GameID:      gameID,
+		WarID:       warID,
+		CurrentTime: start,
+		Location:    "europe",
+		Attacker:    gamelogic.WarSide{Username: attacker},
+		Defender:    gamelogic.WarSide{Username: defender},
+		Outcome:     outcome,
+	}
+}
+
+func record(t *testing.T, l *WarLog, wr gamelogic.WarResult, want bool) {
+	t.Helper()
+	got, err := l.Record(wr)
+	if err != nil {
+		t.Fatalf("Record(%s): %v", wr.WarID, err)
+	}
+	if got != want {
+		t.Errorf("Record(%s) = %v, want %v", wr.WarID, got, want)
+	}
+}
+
+func TestWarLogStats(t *testing.T) {
+	l := openTestWarLog(t, filepath.Join(t.TempDir(), "wars.jsonl"))
+	record(t, l, warResult("w1", "g1", "alice", "bob", gamelogic.WarAttackerWon), true)
+	record(t, l, warResult("w2", "g1", "bob", "alice", gamelogic.WarDefenderWon), true)
+	record(t, l, warResult("w3", "g2", "carol", "alice", gamelogic.WarDraw), true)
+	// Delivered twice, counted once
+	record(t, l, warResult("w1", "g1", "alice", "bob", gamelogic.WarAttackerWon), false)
+
+	tests := []struct {
+		gameID string
+		want   []PlayerStats
+	}{
+		{"", []PlayerStats{{"alice", 2, 0, 1}, {"bob", 0, 2, 0}, {"carol", 0, 0, 1}}},
+		{"g1", []PlayerStats{{"alice", 2, 0, 0}, {"bob", 0, 2, 0}}},
+		{"g3", []PlayerStats{}},
+	}
+	for _, tt := range tests {
+		got, err := l.Stats(tt.gameID)
+		if err != nil {
+			t.Fatalf("Stats(%q): %v", tt.gameID, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Stats(%q) = %+v, want %+v", tt.gameID, got, tt.want)
+		}
+	}
+}
+
+func TestWarLogShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wars.jsonl")
+	a := openTestWarLog(t, path)
+	b := openTestWarLog(t, path)
+	record(t, a, warResult("w1", "g1", "alice", "bob", gamelogic.WarAttackerWon), true)
+	// Redelivered to the other server
+	record(t, b, warResult("w1", "g1", "alice", "bob", gamelogic.WarAttackerWon), false)
+	record(t, b, warResult("w2", "g1", "alice", "bob", gamelogic.WarAttackerWon), true)
+
+	got, err := a.Stats("")
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	want := []PlayerStats{{"alice", 2, 0, 0}, {"bob", 0, 2, 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() with the other server's wars = %+v, want %+v", got, want)
+	}
+}
+
+func TestWarLogReadsWholeLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wars.jsonl")
+	l := openTestWarLog(t, path)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	// Another server is halfway through a line, after one that isn't a war
+	if _, err := f.WriteString("not json\n{\"GameID\":\"g1\",\"WarID\":\"w1\""); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	got, err := l.Stats("")
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Stats() = %+v, want the half written war left out", got)
+	}
+
+	if _, err := f.WriteString(",\"Attacker\":{\"Username\":\"alice\"},\"Defender\":{\"Username\":\"bob\"},\"Outcome\":\"attacker_won\"}\n"); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	got, err = l.Stats("")
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	want := []PlayerStats{{"alice", 1, 0, 0}, {"bob", 0, 1, 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() once the line is done = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package presence
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+type Entry struct {
+	GameID   string
+	Username string
+	Status   routing.PresenceStatus
+	LastSeen time.Time
+	// Units is the army from the player's last army report. Players only
+	// report to the server they logged in with, so other servers have none
+	Units []gamelogic.Unit
+}
+
+// Table tracks which players are connected, based on their heartbeats.
+type Table struct {
+	timeout time.Duration
+	entries map[string]*Entry
+	mu      *sync.Mutex
+}
+
+func NewTable(timeout time.Duration) *Table {
+	return &Table{
+		timeout: timeout,
+		entries: map[string]*Entry{},
+		mu:      &sync.Mutex{},
+	}
+}
+
+func entryKey(gameID, username string) string {
+	return routing.Key(gameID, username)
+}
+
+// entry returns the player's entry, adding it if it is new. The caller must
+// hold mu.
+func (t *Table) entry(gameID, username string) (*Entry, bool) {
+	key := entryKey(gameID, username)
+	e, ok := t.entries[key]
+	if !ok {
+		e = &Entry{
+			GameID:   gameID,
+			Username: username,
+		}
+		t.entries[key] = e
+	}
+	return e, ok
+}
+
+// Seen records a heartbeat received at now and returns the player's new
+// status if it changed: joined for a new or returning player, left for a
+// goodbye. The time in the heartbeat is the client's, which it could set to
+// anything.
+func (t *Table) Seen(hb gamelogic.Heartbeat, now time.Time) (routing.PresenceStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entry(hb.GameID, hb.Username)
+	e.LastSeen = now
+
+	status := routing.PresenceJoined
+	if hb.Leaving {
+		status = routing.PresenceLeft
+	}
+	if ok && e.Status == status {
+		return status, false
+	}
+	e.Status = status
+	return status, true
+}
+
+// Report records the army a player reported.
+func (t *Table) Report(ar gamelogic.ArmyReport) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, _ := t.entry(ar.GameID, ar.Username)
+	e.Units = ar.Units
+}
+
+// Sweep marks every online player not heard from within the timeout as
+// timed out and returns them.
+func (t *Table) Sweep(now time.Time) []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timedOut := []Entry{}
+	for _, e := range t.entries {
+		if e.Status == routing.PresenceJoined && now.Sub(e.LastSeen) > t.timeout {
+			e.Status = routing.PresenceTimedOut
+			timedOut = append(timedOut, *e)
+		}
+	}
+	return timedOut
+}
+
+// IsOnline reports whether the player is connected to any game.
+func (t *Table) IsOnline(username string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, e := range t.entries {
+		if e.Username == username && e.Status == routing.PresenceJoined {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Table) List() []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := []Entry{}
+	for _, e := range t.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].GameID != entries[j].GameID {
+			return entries[i].GameID < entries[j].GameID
+		}
+		return entries[i].Username < entries[j].Username
+	})
+	return entries
+}
@@ -0,0 +1,71 @@
+package presence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/gamelogic"
+	"github.com/x6Nenko/peril/internal/routing"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestSeenUsesReceiveTime(t *testing.T) {
+	table := NewTable(10 * time.Second)
+	// A client claiming to live an hour ahead can't put off its timeout
+	hb := gamelogic.Heartbeat{GameID: "g1", Username: "alice", CurrentTime: start.Add(time.Hour)}
+	status, changed := table.Seen(hb, start)
+	if !changed || status != routing.PresenceJoined {
+		t.Fatalf("Seen() = %v, %v, want joined, true", status, changed)
+	}
+	if _, changed := table.Seen(hb, start.Add(time.Second)); changed {
+		t.Errorf("second heartbeat changed the status")
+	}
+
+	if timedOut := table.Sweep(start.Add(10 * time.Second)); len(timedOut) != 0 {
+		t.Fatalf("timed out %v before the timeout", timedOut)
+	}
+	timedOut := table.Sweep(start.Add(12 * time.Second))
+	if len(timedOut) != 1 || timedOut[0].Username != "alice" || timedOut[0].Status != routing.PresenceTimedOut {
+		t.Fatalf("Sweep() = %v, want alice timed out", timedOut)
+	}
+	if table.IsOnline("alice") {
+		t.Errorf("alice is online after timing out")
+	}
+	if status, changed := table.Seen(hb, start.Add(time.Minute)); !changed || status != routing.PresenceJoined {
+		t.Errorf("returning heartbeat = %v, %v, want joined, true", status, changed)
+	}
+}
+
+func TestSeenLeaving(t *testing.T) {
+	table := NewTable(time.Minute)
+	table.Seen(gamelogic.Heartbeat{GameID: "g1", Username: "alice"}, start)
+	status, changed := table.Seen(gamelogic.Heartbeat{GameID: "g1", Username: "alice", Leaving: true}, start)
+	if !changed || status != routing.PresenceLeft {
+		t.Fatalf("Seen(leaving) = %v, %v, want left, true", status, changed)
+	}
+	if timedOut := table.Sweep(start.Add(time.Hour)); len(timedOut) != 0 {
+		t.Errorf("a player who left timed out: %v", timedOut)
+	}
+}
+
+func TestReport(t *testing.T) {
+	table := NewTable(time.Second)
+	table.Seen(gamelogic.Heartbeat{GameID: "g1", Username: "alice"}, start)
+	table.Seen(gamelogic.Heartbeat{GameID: "g1", Username: "bob"}, start)
+	table.Report(gamelogic.ArmyReport{GameID: "g1", Username: "alice", Units: []gamelogic.Unit{{ID: 1, Owner: "alice"}}})
+
+	timedOut := table.Sweep(start.Add(time.Minute))
+	if len(timedOut) != 2 {
+		t.Fatalf("Sweep() = %v, want alice and bob", timedOut)
+	}
+	for _, entry := range timedOut {
+		want := 0
+		if entry.Username == "alice" {
+			want = 1
+		}
+		if len(entry.Units) != want {
+			t.Errorf("%s has %d units, want %d", entry.Username, len(entry.Units), want)
+		}
+	}
+}
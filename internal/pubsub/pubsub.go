@@ -6,6 +6,10 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"log"
+	"strconv"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -31,6 +35,22 @@ func DeclareAndBind(
 	queueName,
 	key string,
 	queueType SimpleQueueType,
+) (*amqp.Channel, amqp.Queue, error) {
+	return DeclareAndBindWithArgs(conn, exchange, queueName, key, queueType, nil)
+}
+
+// DeclareAndBindWithArgs is DeclareAndBind with extra queue arguments, such
+// as x-max-length. The dead letter exchange is always set. With no exchange
+// the queue isn't bound at all: only messages published to the default
+// exchange under the queue's name reach it, so a transient queue declared
+// this way is private to its connection.
+func DeclareAndBindWithArgs(
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType SimpleQueueType,
+	args amqp.Table,
 ) (*amqp.Channel, amqp.Queue, error) {
 	// Create a new channel
 	ch, err := conn.Channel()
@@ -44,20 +64,28 @@ func DeclareAndBind(
 	exclusive := queueType == Transient
 
 	// Declare the queue with dead letter exchange configuration
+	queueArgs := amqp.Table{
+		"x-dead-letter-exchange": routing.ExchangePerilDLX,
+	}
+	for k, v := range args {
+		queueArgs[k] = v
+	}
 	queue, err := ch.QueueDeclare(
 		queueName,
 		durable,
 		autoDelete,
 		exclusive,
 		false, // noWait
-		amqp.Table{
-			"x-dead-letter-exchange": "peril_dlx",
-		},
+		queueArgs,
 	)
 	if err != nil {
 		return nil, amqp.Queue{}, err
 	}
 
+	if exchange == "" {
+		return ch, queue, nil
+	}
+
 	// Bind the queue to the exchange
 	err = ch.QueueBind(
 		queueName,
@@ -73,7 +101,29 @@ func DeclareAndBind(
 	return ch, queue, nil
 }
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
+// Publisher is anything messages can be published on: an *amqp.Channel,
+// or a Signer wrapping one.
+type Publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// Expiring publishes messages the broker drops unless they are delivered
+// within ttl.
+func Expiring(ch Publisher, ttl time.Duration) Publisher {
+	return expiring{ch: ch, ttl: ttl}
+}
+
+type expiring struct {
+	ch  Publisher
+	ttl time.Duration
+}
+
+func (e expiring) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	msg.Expiration = strconv.FormatInt(e.ttl.Milliseconds(), 10)
+	return e.ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+func PublishJSON[T any](ch Publisher, exchange, key string, val T) error {
 	// Marshal the value to JSON bytes
 	jsonBytes, err := json.Marshal(val)
 	if err != nil {
@@ -99,7 +149,7 @@ func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
 	return nil
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
+func PublishGob[T any](ch Publisher, exchange, key string, val T) error {
 	// Encode the value to gob bytes
 	var buffer bytes.Buffer
 	encoder := gob.NewEncoder(&buffer)
@@ -127,6 +177,40 @@ func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
 	return nil
 }
 
+type subscribeOptions struct {
+	verifier  Verifier
+	queueArgs amqp.Table
+	done      <-chan struct{}
+	workers   int
+}
+
+type SubscribeOption func(*subscribeOptions)
+
+// WithQueueArgs declares the queue with extra arguments, e.g. a length
+// limit and overflow policy.
+func WithQueueArgs(args amqp.Table) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.queueArgs = args
+	}
+}
+
+// WithDone ends the subscription once done is closed. Transient queues are
+// deleted with it.
+func WithDone(done <-chan struct{}) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.done = done
+	}
+}
+
+// WithWorkers handles up to n messages at once instead of one at a time,
+// e.g. so a handler can wait for its message to be written in a batch.
+// Messages are no longer handled in order.
+func WithWorkers(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.workers = n
+	}
+}
+
 func subscribe[T any](
 	conn *amqp.Connection,
 	exchange,
@@ -135,18 +219,24 @@ func subscribe[T any](
 	simpleQueueType SimpleQueueType,
 	handler func(T) AckType,
 	unmarshaller func([]byte) (T, error),
+	opts ...SubscribeOption,
 ) error {
+	options := subscribeOptions{workers: 1}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	// Call DeclareAndBind to ensure the queue exists and is bound to the exchange
-	ch, _, err := DeclareAndBind(conn, exchange, queueName, key, simpleQueueType)
+	ch, _, err := DeclareAndBindWithArgs(conn, exchange, queueName, key, simpleQueueType, options.queueArgs)
 	if err != nil {
 		return err
 	}
 
-	// Set QoS to limit prefetch count to 10
+	// Set QoS to limit prefetch count to 10, or to one per worker
 	err = ch.Qos(
-		10,    // prefetch count
-		0,     // prefetch size
-		false, // global
+		max(10, options.workers), // prefetch count
+		0,                        // prefetch size
+		false,                    // global
 	)
 	if err != nil {
 		return err
@@ -166,34 +256,80 @@ func subscribe[T any](
 		return err
 	}
 
-	// Start a goroutine to process messages
-	go func() {
-		for delivery := range deliveries {
-			// Unmarshal the message body into type T
-			msg, err := unmarshaller(delivery.Body)
+	if options.done != nil {
+		go func() {
+			<-options.done
+			ch.Close()
+		}()
+	}
+
+	// Start the goroutines to process messages
+	var verifier Verifier
+	var guard *replayGuard
+	if options.verifier != nil {
+		verifier = forQueue(options.verifier, simpleQueueType)
+		guard = newReplayGuard()
+	}
+	for i := 0; i < options.workers; i++ {
+		go handleDeliveries(deliveries, handler, unmarshaller, verifier, guard)
+	}
+
+	return nil
+}
+
+// handleDeliveries verifies, decodes and handles deliveries until the
+// channel is closed.
+func handleDeliveries[T any](deliveries <-chan amqp.Delivery, handler func(T) AckType, unmarshaller func([]byte) (T, error), verifier Verifier, guard *replayGuard) {
+	for delivery := range deliveries {
+		// Reject messages that aren't signed by the player named in the
+		// routing key, sending them to the dead letter exchange
+		sender := ""
+		if verifier != nil {
+			var err error
+			sender, err = verifier.Verify(delivery.RoutingKey, delivery.Headers, delivery.Body)
+			if err == nil {
+				err = guard.check(delivery, time.Now())
+			}
 			if err != nil {
+				log.Printf("Rejecting message on %s: %v", delivery.RoutingKey, err)
+				delivery.Nack(false, false)
 				continue
 			}
+		}
 
-			// Call the handler function with the unmarshaled message
-			ackType := handler(msg)
-
-			// Handle acknowledgment based on the returned AckType
-			switch ackType {
-			case Ack:
-				log.Println("Acknowledging message")
-				delivery.Ack(false)
-			case NackRequeue:
-				log.Println("Nacking message with requeue")
-				delivery.Nack(false, true)
-			case NackDiscard:
-				log.Println("Nacking message without requeue (discard)")
-				delivery.Nack(false, false)
-			}
+		// Unmarshal the message body into type T
+		msg, err := unmarshaller(delivery.Body)
+		if err != nil {
+			log.Printf("Discarding malformed message on %s: %v", delivery.RoutingKey, err)
+			delivery.Nack(false, false)
+			continue
+		}
+		if s, ok := any(msg).(interface{ Sender() string }); ok && sender != "" && s.Sender() != sender {
+			log.Printf("Rejecting message on %s: %s claims to be %s", delivery.RoutingKey, sender, s.Sender())
+			delivery.Nack(false, false)
+			continue
+		}
+		// Messages that get passed on keep their signature
+		if s, ok := any(&msg).(interface{ SetSigned(routing.SignedMessage) }); ok && sender != "" {
+			s.SetSigned(signedMessage(delivery))
 		}
-	}()
 
-	return nil
+		// Call the handler function with the unmarshaled message
+		ackType := handler(msg)
+
+		// Handle acknowledgment based on the returned AckType
+		switch ackType {
+		case Ack:
+			log.Println("Acknowledging message")
+			delivery.Ack(false)
+		case NackRequeue:
+			log.Println("Nacking message with requeue")
+			delivery.Nack(false, true)
+		case NackDiscard:
+			log.Println("Nacking message without requeue (discard)")
+			delivery.Nack(false, false)
+		}
+	}
 }
 
 func SubscribeJSON[T any](
@@ -203,13 +339,14 @@ func SubscribeJSON[T any](
 	key string,
 	queueType SimpleQueueType,
 	handler func(T) AckType,
+	opts ...SubscribeOption,
 ) error {
 	unmarshaller := func(data []byte) (T, error) {
 		var msg T
 		err := json.Unmarshal(data, &msg)
 		return msg, err
 	}
-	return subscribe(conn, exchange, queueName, key, queueType, handler, unmarshaller)
+	return subscribe(conn, exchange, queueName, key, queueType, handler, unmarshaller, opts...)
 }
 
 func SubscribeGob[T any](
@@ -219,6 +356,7 @@ func SubscribeGob[T any](
 	key string,
 	queueType SimpleQueueType,
 	handler func(T) AckType,
+	opts ...SubscribeOption,
 ) error {
 	unmarshaller := func(data []byte) (T, error) {
 		var msg T
@@ -227,5 +365,23 @@ func SubscribeGob[T any](
 		err := decoder.Decode(&msg)
 		return msg, err
 	}
-	return subscribe(conn, exchange, queueName, key, queueType, handler, unmarshaller)
+	return subscribe(conn, exchange, queueName, key, queueType, handler, unmarshaller, opts...)
+}
+
+// Bind adds another binding to an existing queue, for queues that need to
+// receive more than one routing key.
+func Bind(conn *amqp.Connection, exchange, queueName, key string) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	return ch.QueueBind(
+		queueName,
+		key,
+		exchange,
+		false, // noWait
+		nil,   // args
+	)
 }
@@ -0,0 +1,282 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/x6Nenko/peril/internal/routing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	HeaderUser      = "x-peril-user"
+	HeaderTimestamp = "x-peril-timestamp"
+	HeaderSignature = "x-peril-signature"
+)
+
+// MaxSignatureAge bounds how old a signed message on a transient queue can
+// be, so captured messages can't be replayed later. Durable queues keep
+// messages while their consumers are down, so their messages can be older,
+// see WithVerifier.
+const MaxSignatureAge = 2 * time.Minute
+
+// signedPayload covers the routing key as well as the body, so a signed
+// message can't be re-published under someone else's key.
+func signedPayload(key, timestamp string, body []byte) []byte {
+	payload := []byte(key + "\n" + timestamp + "\n")
+	return append(payload, body...)
+}
+
+// Signer publishes messages signed with a player's Ed25519 key.
+type Signer struct {
+	ch       Publisher
+	username string
+	key      ed25519.PrivateKey
+}
+
+func NewSigner(ch Publisher, username string, key ed25519.PrivateKey) *Signer {
+	return &Signer{
+		ch:       ch,
+		username: username,
+		key:      key,
+	}
+}
+
+func (s *Signer) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	msg.Headers[HeaderUser] = s.username
+	msg.Headers[HeaderTimestamp] = timestamp
+	msg.Headers[HeaderSignature] = ed25519.Sign(s.key, signedPayload(key, timestamp, msg.Body))
+	return s.ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+// Verifier checks a delivery and returns the player who signed it.
+type Verifier interface {
+	Verify(key string, headers amqp.Table, body []byte) (string, error)
+}
+
+// WithVerifier rejects and dead-letters deliveries that fail verification,
+// and deliveries of a message the subscription has already seen. On a
+// durable queue the verifiers of this package don't check the age of a
+// message, so messages that waited out a restart of the consumers aren't
+// dead-lettered; its handler must cope with a message replayed after the
+// replay check forgets it, e.g. by deduping on an ID in the message.
+func WithVerifier(v Verifier) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.verifier = v
+	}
+}
+
+// VerifySigned checks a message that was passed on by whoever received it,
+// and returns the player who signed it. It was signed before it was passed
+// on, so its age isn't checked.
+func VerifySigned(v Verifier, msg routing.SignedMessage) (string, error) {
+	headers := amqp.Table{
+		HeaderUser:      msg.Username,
+		HeaderTimestamp: msg.Timestamp,
+		HeaderSignature: msg.Signature,
+	}
+	return forQueue(v, Durable).Verify(msg.Key, headers, msg.Body)
+}
+
+// signedMessage keeps a verified delivery as its sender signed it.
+func signedMessage(delivery amqp.Delivery) routing.SignedMessage {
+	username, _ := delivery.Headers[HeaderUser].(string)
+	timestamp, _ := delivery.Headers[HeaderTimestamp].(string)
+	signature, _ := delivery.Headers[HeaderSignature].([]byte)
+	return routing.SignedMessage{
+		Key:       delivery.RoutingKey,
+		Username:  username,
+		Timestamp: timestamp,
+		Signature: signature,
+		Body:      delivery.Body,
+	}
+}
+
+// KeyRing holds the public keys of the players and verifies that messages
+// are signed by the player named in the last segment of the routing key.
+type KeyRing struct {
+	keys map[string]ed25519.PublicKey
+	mu   *sync.RWMutex
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{
+		keys: map[string]ed25519.PublicKey{},
+		mu:   &sync.RWMutex{},
+	}
+}
+
+func (kr *KeyRing) Add(username string, key []byte) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key for %s", username)
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys[username] = ed25519.PublicKey(key)
+	return nil
+}
+
+func (kr *KeyRing) Get(username string) (ed25519.PublicKey, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, ok := kr.keys[username]
+	return key, ok
+}
+
+func (kr *KeyRing) Snapshot() map[string][]byte {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	keys := map[string][]byte{}
+	for username, key := range kr.keys {
+		keys[username] = key
+	}
+	return keys
+}
+
+func (kr *KeyRing) Verify(key string, headers amqp.Table, body []byte) (string, error) {
+	return verify(key, headers, body, true, true, kr.Get)
+}
+
+func (kr *KeyRing) durable() Verifier {
+	return durableVerifier{lookup: kr.Get, named: true}
+}
+
+// Direct verifies messages published straight to a private queue, whose
+// routing key is the queue's name instead of the sender's. The message
+// itself must name its sender, see handleDeliveries.
+func (kr *KeyRing) Direct() Verifier {
+	return directVerifier{kr}
+}
+
+type directVerifier struct {
+	kr *KeyRing
+}
+
+func (v directVerifier) Verify(key string, headers amqp.Table, body []byte) (string, error) {
+	return verify(key, headers, body, false, true, v.kr.Get)
+}
+
+func (v directVerifier) durable() Verifier {
+	return durableVerifier{lookup: v.kr.Get}
+}
+
+// TrustedKey verifies messages signed with one known key, whoever they are
+// signed as. The servers of a deployment share a key, and each signs as
+// its own server ID.
+type TrustedKey ed25519.PublicKey
+
+func (tk TrustedKey) Verify(key string, headers amqp.Table, body []byte) (string, error) {
+	return verify(key, headers, body, false, true, tk.lookup)
+}
+
+func (tk TrustedKey) durable() Verifier {
+	return durableVerifier{lookup: tk.lookup}
+}
+
+func (tk TrustedKey) lookup(string) (ed25519.PublicKey, bool) {
+	return ed25519.PublicKey(tk), len(tk) == ed25519.PublicKeySize
+}
+
+// durableVerifier verifies messages off a durable queue like the verifier
+// it comes from, but whatever their age.
+type durableVerifier struct {
+	lookup func(username string) (ed25519.PublicKey, bool)
+	named  bool
+}
+
+func (v durableVerifier) Verify(key string, headers amqp.Table, body []byte) (string, error) {
+	return verify(key, headers, body, v.named, false, v.lookup)
+}
+
+// forQueue returns the verifier to use on a queue of the given type.
+func forQueue(v Verifier, queueType SimpleQueueType) Verifier {
+	if d, ok := v.(interface{ durable() Verifier }); ok && queueType == Durable {
+		return d.durable()
+	}
+	return v
+}
+
+// verify checks the signature on a delivery with the signer's key, that
+// the routing key ends with the signer's name if named is set, and that
+// the message isn't older than MaxSignatureAge if checkAge is set.
+func verify(key string, headers amqp.Table, body []byte, named, checkAge bool, lookup func(username string) (ed25519.PublicKey, bool)) (string, error) {
+	username, _ := headers[HeaderUser].(string)
+	timestamp, _ := headers[HeaderTimestamp].(string)
+	signature, _ := headers[HeaderSignature].([]byte)
+	if username == "" || timestamp == "" || len(signature) == 0 {
+		return "", errors.New("message is not signed")
+	}
+
+	segments := strings.Split(key, ".")
+	if claimed := segments[len(segments)-1]; named && claimed != username {
+		return "", fmt.Errorf("message signed by %s was published as %s", username, claimed)
+	}
+
+	nanos, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature timestamp %q", timestamp)
+	}
+	age := time.Since(time.Unix(0, nanos))
+	if checkAge && (age > MaxSignatureAge || age < -MaxSignatureAge) {
+		return "", fmt.Errorf("signature from %s is %v old", username, age.Round(time.Second))
+	}
+
+	publicKey, ok := lookup(username)
+	if !ok {
+		return "", fmt.Errorf("no public key registered for %s", username)
+	}
+	if !ed25519.Verify(publicKey, signedPayload(key, timestamp, body), signature) {
+		return "", fmt.Errorf("invalid signature from %s", username)
+	}
+	return username, nil
+}
+
+// replayGuard remembers the signatures of the messages a subscription has
+// seen, so a captured message published to it again is rejected. It
+// forgets them after twice MaxSignatureAge, when transient queues reject
+// them by age anyway.
+type replayGuard struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newReplayGuard() *replayGuard {
+	return &replayGuard{seen: map[string]time.Time{}}
+}
+
+// check returns an error if the delivery is a message seen before. A
+// message the broker hands back after a requeue is marked as redelivered,
+// which a re-published message can't be.
+func (g *replayGuard) check(delivery amqp.Delivery, now time.Time) error {
+	signature, _ := delivery.Headers[HeaderSignature].([]byte)
+	if len(signature) == 0 || delivery.Redelivered {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.lastPrune) > MaxSignatureAge {
+		for seen, at := range g.seen {
+			if now.Sub(at) > 2*MaxSignatureAge {
+				delete(g.seen, seen)
+			}
+		}
+		g.lastPrune = now
+	}
+	if _, ok := g.seen[string(signature)]; ok {
+		return errors.New("message was already delivered")
+	}
+	g.seen[string(signature)] = now
+	return nil
+}
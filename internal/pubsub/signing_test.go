@@ -0,0 +1,187 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// recorder keeps the last message published on it.
+type recorder struct {
+	key string
+	msg amqp.Publishing
+}
+
+func (r *recorder) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	r.key = key
+	r.msg = msg
+	return nil
+}
+
+func newTestKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return public, private
+}
+
+func signed(t *testing.T, username string, private ed25519.PrivateKey, key string) *recorder {
+	t.Helper()
+	r := &recorder{}
+	err := NewSigner(r, username, private).PublishWithContext(context.Background(), "", key, false, false, amqp.Publishing{Body: []byte("{}")})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	return r
+}
+
+func TestKeyRingVerify(t *testing.T) {
+	alicePublic, alicePrivate := newTestKey(t)
+	_, bobPrivate := newTestKey(t)
+	ring := NewKeyRing()
+	ring.Add("alice", alicePublic)
+
+	tests := []struct {
+		name    string
+		r       *recorder
+		key     string
+		wantErr string
+	}{
+		{"valid", signed(t, "alice", alicePrivate, "chat.g1.all.alice"), "", ""},
+		{"other player's key", signed(t, "alice", alicePrivate, "chat.g1.all.bob"), "", "published as bob"},
+		{"unknown signer", signed(t, "bob", bobPrivate, "chat.g1.all.bob"), "", "no public key"},
+		{"forged signature", signed(t, "alice", bobPrivate, "chat.g1.all.alice"), "", "invalid signature"},
+		{"moved to another key", signed(t, "alice", alicePrivate, "chat.g1.all.alice"), "chat.g2.all.alice", "invalid signature"},
+		{"unsigned", &recorder{key: "chat.g1.all.alice"}, "", "not signed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.r.key
+			if tt.key != "" {
+				key = tt.key
+			}
+			sender, err := ring.Verify(key, tt.r.msg.Headers, tt.r.msg.Body)
+			if tt.wantErr == "" {
+				if err != nil || sender != "alice" {
+					t.Fatalf("Verify() = %q, %v, want alice", sender, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDirectVerify(t *testing.T) {
+	public, private := newTestKey(t)
+	ring := NewKeyRing()
+	ring.Add("alice", public)
+	r := signed(t, "alice", private, "army_reports.server1")
+	if _, err := ring.Verify(r.key, r.msg.Headers, r.msg.Body); err == nil {
+		t.Errorf("Verify accepted a key that doesn't name the sender")
+	}
+	sender, err := ring.Direct().Verify(r.key, r.msg.Headers, r.msg.Body)
+	if err != nil || sender != "alice" {
+		t.Errorf("Direct().Verify() = %q, %v, want alice", sender, err)
+	}
+}
+
+func TestTrustedKey(t *testing.T) {
+	public, private := newTestKey(t)
+	_, other := newTestKey(t)
+	trusted := TrustedKey(public)
+	r := signed(t, "server1", private, "keys.server1")
+	if sender, err := trusted.Verify(r.key, r.msg.Headers, r.msg.Body); err != nil || sender != "server1" {
+		t.Errorf("Verify() = %q, %v, want server1", sender, err)
+	}
+	r = signed(t, "server2", other, "keys.server2")
+	if _, err := trusted.Verify(r.key, r.msg.Headers, r.msg.Body); err == nil {
+		t.Errorf("Verify accepted a message signed with another key")
+	}
+	if _, err := TrustedKey(nil).Verify(r.key, r.msg.Headers, r.msg.Body); err == nil {
+		t.Errorf("an empty trusted key verified a message")
+	}
+}
+
+// signedAt is a message signed as if published at the given time.
+func signedAt(username string, private ed25519.PrivateKey, key string, at time.Time) amqp.Table {
+	timestamp := strconv.FormatInt(at.UnixNano(), 10)
+	return amqp.Table{
+		HeaderUser:      username,
+		HeaderTimestamp: timestamp,
+		HeaderSignature: ed25519.Sign(private, signedPayload(key, timestamp, []byte("{}"))),
+	}
+}
+
+func TestDurableQueuesAcceptOldMessages(t *testing.T) {
+	public, private := newTestKey(t)
+	ring := NewKeyRing()
+	ring.Add("alice", public)
+	old := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		verifier Verifier
+		key      string
+		sender   string
+	}{
+		{"key ring", ring, "war_results.alice", "alice"},
+		{"direct", ring.Direct(), "army_reports.server1", "alice"},
+		{"trusted key", TrustedKey(public), "bot_handoff.g1.server1", "server1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := signedAt(tt.sender, private, tt.key, old)
+			if _, err := forQueue(tt.verifier, Transient).Verify(tt.key, headers, []byte("{}")); err == nil || !strings.Contains(err.Error(), "old") {
+				t.Errorf("transient Verify() = %v, want an error about its age", err)
+			}
+			sender, err := forQueue(tt.verifier, Durable).Verify(tt.key, headers, []byte("{}"))
+			if err != nil || sender != tt.sender {
+				t.Errorf("durable Verify() = %q, %v, want %s", sender, err, tt.sender)
+			}
+			headers[HeaderSignature] = ed25519.Sign(private, []byte("something else"))
+			if _, err := forQueue(tt.verifier, Durable).Verify(tt.key, headers, []byte("{}")); err == nil {
+				t.Errorf("durable Verify() accepted a forged signature")
+			}
+		})
+	}
+}
+
+func TestReplayGuard(t *testing.T) {
+	_, private := newTestKey(t)
+	now := time.Now()
+	delivery := amqp.Delivery{Headers: signedAt("alice", private, "chat.g1.all.alice", now)}
+	other := amqp.Delivery{Headers: signedAt("alice", private, "chat.g1.all.alice", now.Add(time.Second))}
+	requeued := delivery
+	requeued.Redelivered = true
+
+	guard := newReplayGuard()
+	steps := []struct {
+		name     string
+		delivery amqp.Delivery
+		at       time.Time
+		wantErr  bool
+	}{
+		{"first delivery", delivery, now, false},
+		{"replayed", delivery, now.Add(time.Minute), true},
+		{"requeued", requeued, now.Add(time.Minute), false},
+		{"another message", other, now.Add(time.Minute), false},
+		{"replayed after it was forgotten", delivery, now.Add(5 * MaxSignatureAge), false},
+	}
+	for _, step := range steps {
+		err := guard.check(step.delivery, step.at)
+		if (err != nil) != step.wantErr {
+			t.Errorf("%s: check() = %v, want error %v", step.name, err, step.wantErr)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Muter silences keys that keep breaking a rate limit: a key that collects
+// strikes strikes within window is muted for duration.
+type Muter struct {
+	strikes  int
+	window   time.Duration
+	duration time.Duration
+	offences map[string][]time.Time
+	muted    map[string]time.Time
+	mu       sync.Mutex
+}
+
+type Mute struct {
+	Key   string
+	Until time.Time
+}
+
+func NewMuter(strikes int, window, duration time.Duration) *Muter {
+	return &Muter{
+		strikes:  strikes,
+		window:   window,
+		duration: duration,
+		offences: map[string][]time.Time{},
+		muted:    map[string]time.Time{},
+	}
+}
+
+// Strike records a violation and reports whether it got the key muted.
+func (m *Muter) Strike(key string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.muted[key]; ok {
+		return false
+	}
+
+	recent := []time.Time{}
+	for _, t := range m.offences[key] {
+		if now.Sub(t) < m.window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < m.strikes {
+		m.offences[key] = recent
+		return false
+	}
+
+	delete(m.offences, key)
+	m.muted[key] = now.Add(m.duration)
+	return true
+}
+
+func (m *Muter) Muted(key string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until, ok := m.muted[key]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(m.muted, key)
+		return false
+	}
+	return true
+}
+
+// Unmute lifts a mute early. It reports whether the key was muted.
+func (m *Muter) Unmute(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.muted[key]
+	delete(m.muted, key)
+	delete(m.offences, key)
+	return ok
+}
+
+// List returns the current mutes, sorted by key.
+func (m *Muter) List(now time.Time) []Mute {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mutes := []Mute{}
+	for key, until := range m.muted {
+		if now.Before(until) {
+			mutes = append(mutes, Mute{Key: key, Until: until})
+		}
+	}
+	sort.Slice(mutes, func(i, j int) bool {
+		return mutes[i].Key < mutes[j].Key
+	})
+	return mutes
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket: it holds up to burst tokens and gains one
+// token every interval. Each allowed event takes a token.
+type Bucket struct {
+	burst    float64
+	interval time.Duration
+	tokens   float64
+	last     time.Time
+	mu       sync.Mutex
+}
+
+func NewBucket(burst int, interval time.Duration) *Bucket {
+	return newBucketAt(burst, interval, time.Now())
+}
+
+func newBucketAt(burst int, interval time.Duration, now time.Time) *Bucket {
+	return &Bucket{
+		burst:    float64(burst),
+		interval: interval,
+		tokens:   float64(burst),
+		last:     now,
+	}
+}
+
+func (b *Bucket) Allow() bool {
+	return b.AllowAt(time.Now())
+}
+
+func (b *Bucket) AllowAt(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += float64(elapsed) / float64(b.interval)
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Limiter keeps a separate bucket per key, e.g. per username.
+type Limiter struct {
+	burst    int
+	interval time.Duration
+	buckets  map[string]*Bucket
+	mu       sync.Mutex
+}
+
+func NewLimiter(burst int, interval time.Duration) *Limiter {
+	return &Limiter{
+		burst:    burst,
+		interval: interval,
+		buckets:  map[string]*Bucket{},
+	}
+}
+
+func (l *Limiter) Allow(key string) bool {
+	return l.AllowAt(key, time.Now())
+}
+
+func (l *Limiter) AllowAt(key string, now time.Time) bool {
+	return l.bucket(key, now).AllowAt(now)
+}
+
+func (l *Limiter) bucket(key string, now time.Time) *Bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucketAt(l.burst, l.interval, now)
+		l.buckets[key] = b
+	}
+	return b
+}
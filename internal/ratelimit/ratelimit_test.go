@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestBucketBurst(t *testing.T) {
+	b := newBucketAt(3, time.Second, start)
+	for i := 0; i < 3; i++ {
+		if !b.AllowAt(start) {
+			t.Fatalf("event %d of the burst was refused", i+1)
+		}
+	}
+	if b.AllowAt(start) {
+		t.Errorf("event past the burst was allowed")
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	b := newBucketAt(2, time.Second, start)
+	b.AllowAt(start)
+	b.AllowAt(start)
+
+	tests := []struct {
+		after time.Duration
+		want  bool
+	}{
+		{500 * time.Millisecond, false},
+		{time.Second, true},
+		{time.Second, false},
+		{1500 * time.Millisecond, false},
+		{2 * time.Second, true},
+		// A long wait only refills up to the burst
+		{time.Hour, true},
+		{time.Hour, true},
+		{time.Hour, false},
+	}
+	for _, tt := range tests {
+		if got := b.AllowAt(start.Add(tt.after)); got != tt.want {
+			t.Errorf("AllowAt(+%v) = %v, want %v", tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestBucketClockGoingBack(t *testing.T) {
+	b := newBucketAt(1, time.Second, start)
+	b.AllowAt(start)
+	if b.AllowAt(start.Add(-time.Hour)) {
+		t.Errorf("an earlier time refilled the bucket")
+	}
+	if !b.AllowAt(start.Add(time.Second)) {
+		t.Errorf("bucket did not refill after the interval")
+	}
+}
+
+func TestLimiterKeysAreSeparate(t *testing.T) {
+	l := NewLimiter(1, time.Minute)
+	if !l.AllowAt("alice", start) {
+		t.Fatalf("first event from alice was refused")
+	}
+	if l.AllowAt("alice", start) {
+		t.Errorf("second event from alice was allowed")
+	}
+	if !l.AllowAt("bob", start) {
+		t.Errorf("bob was limited by alice's events")
+	}
+	if !l.AllowAt("alice", start.Add(time.Minute)) {
+		t.Errorf("alice's bucket did not refill")
+	}
+}
+
+func TestMuterStrikes(t *testing.T) {
+	m := NewMuter(3, time.Minute, 10*time.Minute)
+	if m.Strike("alice", start) || m.Strike("alice", start.Add(10*time.Second)) {
+		t.Fatalf("muted before the third strike")
+	}
+	if !m.Strike("alice", start.Add(20*time.Second)) {
+		t.Fatalf("third strike within the window did not mute")
+	}
+	if m.Strike("alice", start.Add(30*time.Second)) {
+		t.Errorf("a muted key was muted again")
+	}
+
+	tests := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{20 * time.Second, true},
+		{10*time.Minute + 19*time.Second, true},
+		{10*time.Minute + 20*time.Second, false},
+	}
+	for _, tt := range tests {
+		if got := m.Muted("alice", start.Add(tt.at)); got != tt.want {
+			t.Errorf("Muted(+%v) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+	if m.Muted("bob", start) {
+		t.Errorf("bob is muted without a strike")
+	}
+}
+
+func TestMuterWindow(t *testing.T) {
+	m := NewMuter(2, time.Minute, time.Hour)
+	m.Strike("alice", start)
+	// The first strike has left the window by now
+	if m.Strike("alice", start.Add(time.Minute)) {
+		t.Errorf("strikes a window apart muted")
+	}
+	if !m.Strike("alice", start.Add(90*time.Second)) {
+		t.Errorf("two strikes within the window did not mute")
+	}
+}
+
+func TestMuterUnmuteAndList(t *testing.T) {
+	m := NewMuter(1, time.Minute, time.Hour)
+	m.Strike("bob", start)
+	m.Strike("alice", start)
+
+	mutes := m.List(start)
+	if len(mutes) != 2 || mutes[0].Key != "alice" || mutes[1].Key != "bob" {
+		t.Fatalf("List() = %v, want alice and bob", mutes)
+	}
+	if !mutes[0].Until.Equal(start.Add(time.Hour)) {
+		t.Errorf("alice muted until %v, want %v", mutes[0].Until, start.Add(time.Hour))
+	}
+	if !m.Unmute("alice") {
+		t.Errorf("Unmute(alice) = false, want true")
+	}
+	if m.Unmute("alice") {
+		t.Errorf("second Unmute(alice) = true, want false")
+	}
+	if m.Muted("alice", start) {
+		t.Errorf("alice is still muted")
+	}
+	if mutes := m.List(start.Add(time.Hour)); len(mutes) != 0 {
+		t.Errorf("List() after the mutes ran out = %v, want none", mutes)
+	}
+}
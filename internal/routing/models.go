@@ -10,4 +10,161 @@ type GameLog struct {
 	CurrentTime time.Time
 	Message     string
 	Username    string
+	GameID      string
+}
+
+func (gl GameLog) Sender() string {
+	return gl.Username
+}
+
+// SignedMessage is a message as its sender signed it, so whoever received
+// it can pass it on and others can still check who sent it.
+type SignedMessage struct {
+	Key       string
+	Username  string
+	Timestamp string
+	Signature []byte
+	Body      []byte
+}
+
+type ChatMessage struct {
+	CurrentTime time.Time
+	GameID      string
+	Channel     string
+	From        string
+	To          string
+	Message     string
+}
+
+func (cm ChatMessage) Sender() string {
+	return cm.From
+}
+
+type LobbyAction string
+
+const (
+	LobbyList   LobbyAction = "list"
+	LobbyCreate LobbyAction = "create"
+	LobbyJoin   LobbyAction = "join"
+	LobbyLeave  LobbyAction = "leave"
+	// LobbyLeaderboard asks for the best rated players
+	LobbyLeaderboard LobbyAction = "leaderboard"
+)
+
+type LobbyRequest struct {
+	RequestID string
+	Action    LobbyAction
+	GameID    string
+	Username  string
+	// Limit caps the players of a leaderboard
+	Limit int
+}
+
+func (lr LobbyRequest) Sender() string {
+	return lr.Username
+}
+
+type GameInfo struct {
+	ID        string
+	Players   []string
+	Paused    bool
+	CreatedAt time.Time
+}
+
+type LobbyReply struct {
+	RequestID   string
+	OK          bool
+	Error       string
+	Games       []GameInfo
+	Leaderboard []PlayerStats
+}
+
+// PlayerStats sums up a player's record across every game.
+type PlayerStats struct {
+	Username string
+	// Rating is an Elo rating, updated after every war
+	Rating float64
+	Wars   int
+	Won    int
+	Lost   int
+	Drawn  int
+	// UnitsSpawned counts the units the player spawned
+	UnitsSpawned int
+	// UnitsLost counts the player's units killed in wars
+	UnitsLost int
+	// Territories counts the territories the player holds now
+	Territories int
+}
+
+type PresenceStatus string
+
+const (
+	PresenceJoined   PresenceStatus = "joined"
+	PresenceLeft     PresenceStatus = "left"
+	PresenceTimedOut PresenceStatus = "timed_out"
+)
+
+// TimeoutPolicy decides what happens to the units of a timed out player.
+type TimeoutPolicy string
+
+const (
+	// TimeoutFreeze keeps the units on the board until the player is back
+	TimeoutFreeze TimeoutPolicy = "freeze"
+	// TimeoutForfeit removes the player from the game
+	TimeoutForfeit TimeoutPolicy = "forfeit"
+	// TimeoutBot hands the units to a bot
+	TimeoutBot TimeoutPolicy = "bot"
+)
+
+type PresenceEvent struct {
+	CurrentTime time.Time
+	GameID      string
+	Username    string
+	Status      PresenceStatus
+	Policy      TimeoutPolicy
+}
+
+type AuthAction string
+
+const (
+	// AuthHello finds a server to log in with. It is the only auth request
+	// sent in the open, and carries nothing but the request and reply IDs
+	AuthHello    AuthAction = "hello"
+	AuthRegister AuthAction = "register"
+	AuthLogin    AuthAction = "login"
+)
+
+// AuthRequest logs a player in. The client picks its own Ed25519 key pair
+// for the login; the servers then only trust messages from the player that
+// are signed with it. The reply goes to the client's private
+// auth.reply.<ReplyTo> queue, or auth.hello.<ReplyTo> for a hello.
+type AuthRequest struct {
+	RequestID string
+	ReplyTo   string
+	Action    AuthAction
+	Username  string
+	Password  string
+	PublicKey []byte
+}
+
+// AuthReply carries the public keys of every logged in player, and the key
+// the answering server signs with. A reply to a hello only has the
+// server's ID and key.
+type AuthReply struct {
+	RequestID string
+	OK        bool
+	Error     string
+	Keys      map[string][]byte
+	ServerID  string
+	ServerKey []byte
+}
+
+type KeyAnnouncement struct {
+	ServerID  string
+	Username  string
+	PublicKey []byte
+}
+
+func (ka KeyAnnouncement) Sender() string {
+	return ka.ServerID
 }
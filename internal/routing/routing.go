@@ -1,16 +1,91 @@
 package routing
 
+import "strings"
+
 const (
 	ArmyMovesPrefix = "army_moves"
 
+	// Defenders send war.<game>.<attacker>.<defender> to the attacker, who
+	// answers on war_results.<game>.<defender>.<attacker>. Allies who
+	// joined the defense get the result on war_allies.<game>.<ally>.<attacker>
 	WarRecognitionsPrefix = "war"
+	WarResultsPrefix      = "war_results"
+	WarAlliesPrefix       = "war_allies"
 
 	PauseKey = "pause"
 
 	GameLogSlug = "game_logs"
+
+	DiplomacyPrefix = "diplomacy"
+
+	ChatPrefix = "chat"
+
+	// Clients send lobby.request.<username> and receive lobby.reply.<username>
+	LobbyPrefix = "lobby"
+
+	HeartbeatPrefix = "heartbeat"
+
+	// Clients report their army to the server they logged in with, straight
+	// to its private army_reports.<serverID> queue
+	ArmyReportsPrefix = "army_reports"
+
+	PresencePrefix = "presence"
+
+	BotHandoffPrefix = "bot_handoff"
+
+	// Clients find a server by sending auth.hello, then log in on the
+	// server's private auth.server.<serverID> queue. Servers answer on the
+	// client's private auth.hello.<replyTo> and auth.reply.<replyTo> queues
+	AuthPrefix = "auth"
+
+	// Servers announce the public keys of players on keys.<serverID>
+	KeysPrefix = "keys"
 )
 
-const (
+// Exchange names are variables so the config can point a deployment at
+// differently named exchanges. They must not change after connecting.
+var (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
+	ExchangePerilDLX    = "peril_dlx"
 )
+
+// Chat channels, used in chat routing keys: chat.<game>.all.<sender>,
+// chat.<game>.whisper.<recipient>.<sender> and
+// chat.<game>.ally.<recipient>.<sender>. Every key a player publishes on
+// ends with their username, which is checked against their signature.
+const (
+	ChatBroadcast = "all"
+	ChatWhisper   = "whisper"
+	ChatAlly      = "ally"
+)
+
+const ChatArchiveQueue = "chat_archive"
+
+// LobbyQueue is where the servers take turns answering lobby requests from.
+const LobbyQueue = "lobby_requests"
+
+// WarResultsQueue is where the servers record war results from.
+const WarResultsQueue = "war_results"
+
+// BotHandoffQueue holds the armies of timed out players until a bot picks
+// them up, in one queue per game: bot_handoffs.<game>.
+const BotHandoffQueue = "bot_handoffs"
+
+// SpectatorPrefix names the queues a server feeds its spectators from:
+// spectate.<serverID>.<feed>
+const SpectatorPrefix = "spectate"
+
+const (
+	RequestSlug = "request"
+	ReplySlug   = "reply"
+	HelloSlug   = "hello"
+	ServerSlug  = "server"
+)
+
+// Key joins segments into a routing key or queue name. Everything that
+// belongs to a game is namespaced by its ID, e.g.
+// Key(ArmyMovesPrefix, gameID, username) is "army_moves.<game>.<username>".
+func Key(parts ...string) string {
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,151 @@
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Client frames
+const (
+	Connect     = "CONNECT"
+	Stomp       = "STOMP"
+	Send        = "SEND"
+	Subscribe   = "SUBSCRIBE"
+	Unsubscribe = "UNSUBSCRIBE"
+	Ack         = "ACK"
+	Nack        = "NACK"
+	Disconnect  = "DISCONNECT"
+)
+
+// Server frames
+const (
+	Connected = "CONNECTED"
+	Message   = "MESSAGE"
+	Receipt   = "RECEIPT"
+	Error     = "ERROR"
+)
+
+// Frame is a STOMP 1.2 frame. Repeated headers keep their first value, as
+// the spec requires.
+type Frame struct {
+	Command string
+	Headers map[string]string
+	Body    []byte
+}
+
+func NewFrame(command string, headers map[string]string, body []byte) Frame {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	return Frame{
+		Command: command,
+		Headers: headers,
+		Body:    body,
+	}
+}
+
+var (
+	escaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	unescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+// Bytes encodes the frame, with headers sorted so the output is stable.
+// CONNECT and CONNECTED frames don't escape their headers.
+func (f Frame) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+
+	escape := f.Command != Connect && f.Command != Connected
+	names := make([]string, 0, len(f.Headers))
+	for name := range f.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := f.Headers[name]
+		if escape {
+			name = escaper.Replace(name)
+			value = escaper.Replace(value)
+		}
+		buf.WriteString(name + ":" + value + "\n")
+	}
+	if _, ok := f.Headers["content-length"]; !ok && len(f.Body) > 0 {
+		buf.WriteString("content-length:" + strconv.Itoa(len(f.Body)) + "\n")
+	}
+
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+// Parse decodes every frame in data. Heart-beat newlines between frames
+// are skipped.
+func Parse(data []byte) ([]Frame, error) {
+	frames := []Frame{}
+	for {
+		data = bytes.TrimLeft(data, "\r\n")
+		if len(data) == 0 {
+			return frames, nil
+		}
+		frame, rest, err := parseFrame(data)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+		data = rest
+	}
+}
+
+func parseFrame(data []byte) (Frame, []byte, error) {
+	end := bytes.Index(data, []byte("\n\n"))
+	if crlf := bytes.Index(data, []byte("\r\n\r\n")); crlf >= 0 && (end < 0 || crlf < end) {
+		end = crlf
+	}
+	if end < 0 {
+		return Frame{}, nil, errors.New("incomplete frame headers")
+	}
+	head := strings.ReplaceAll(string(data[:end]), "\r\n", "\n")
+	data = bytes.TrimPrefix(bytes.TrimPrefix(data[end:], []byte("\r\n\r\n")), []byte("\n\n"))
+
+	lines := strings.Split(head, "\n")
+	frame := NewFrame(lines[0], nil, nil)
+	escaped := frame.Command != Connect && frame.Command != Stomp
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return Frame{}, nil, fmt.Errorf("invalid header %q", line)
+		}
+		if escaped {
+			name = unescaper.Replace(name)
+			value = unescaper.Replace(value)
+		}
+		if _, seen := frame.Headers[name]; !seen {
+			frame.Headers[name] = value
+		}
+	}
+
+	if length, ok := frame.Headers["content-length"]; ok {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n >= len(data) {
+			return Frame{}, nil, fmt.Errorf("invalid content-length %q", length)
+		}
+		if data[n] != 0 {
+			return Frame{}, nil, errors.New("frame body is not followed by NULL")
+		}
+		frame.Body = data[:n]
+		return frame, data[n+1:], nil
+	}
+
+	n := bytes.IndexByte(data, 0)
+	if n < 0 {
+		return Frame{}, nil, errors.New("frame is not terminated by NULL")
+	}
+	frame.Body = data[:n]
+	return frame, data[n+1:], nil
+}
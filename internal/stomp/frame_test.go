@@ -0,0 +1,123 @@
+package stomp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []Frame
+		wantErr string
+	}{
+		{
+			name: "empty",
+			data: "",
+			want: []Frame{},
+		},
+		{
+			name: "heart-beats only",
+			data: "\n\r\n\n",
+			want: []Frame{},
+		},
+		{
+			name: "connect",
+			data: "CONNECT\naccept-version:1.2\nlogin:alice\npasscode:a:b\\c\n\n\x00",
+			// CONNECT headers are not unescaped
+			want: []Frame{NewFrame(Connect, map[string]string{"accept-version": "1.2", "login": "alice", "passcode": "a:b\\c"}, []byte{})},
+		},
+		{
+			name: "escaped headers",
+			data: "SEND\ndestination:/exchange/peril_topic/a\\cb\nnote:line\\none\\\\\n\nhi\x00",
+			want: []Frame{NewFrame(Send, map[string]string{"destination": "/exchange/peril_topic/a:b", "note": "line\none\\"}, []byte("hi"))},
+		},
+		{
+			name: "repeated header keeps the first value",
+			data: "SEND\nid:1\nid:2\n\n\x00",
+			want: []Frame{NewFrame(Send, map[string]string{"id": "1"}, []byte{})},
+		},
+		{
+			name: "content-length allows NULL in the body",
+			data: "SEND\ncontent-length:3\n\na\x00b\x00",
+			want: []Frame{NewFrame(Send, map[string]string{"content-length": "3"}, []byte("a\x00b"))},
+		},
+		{
+			name: "CRLF line endings",
+			data: "SUBSCRIBE\r\nid:1\r\n\r\n\x00",
+			want: []Frame{NewFrame(Subscribe, map[string]string{"id": "1"}, []byte{})},
+		},
+		{
+			name: "several frames with heart-beats between",
+			data: "SEND\nid:1\n\na\x00\nUNSUBSCRIBE\nid:1\n\n\x00\n",
+			want: []Frame{
+				NewFrame(Send, map[string]string{"id": "1"}, []byte("a")),
+				NewFrame(Unsubscribe, map[string]string{"id": "1"}, []byte{}),
+			},
+		},
+		{
+			name:    "incomplete headers",
+			data:    "SEND\nid:1\n",
+			wantErr: "incomplete frame headers",
+		},
+		{
+			name:    "header without a colon",
+			data:    "SEND\nid\n\n\x00",
+			wantErr: "invalid header",
+		},
+		{
+			name:    "missing NULL",
+			data:    "SEND\nid:1\n\nbody",
+			wantErr: "not terminated by NULL",
+		},
+		{
+			name:    "content-length past the data",
+			data:    "SEND\ncontent-length:10\n\nabc\x00",
+			wantErr: "invalid content-length",
+		},
+		{
+			name:    "negative content-length",
+			data:    "SEND\ncontent-length:-1\n\n\x00",
+			wantErr: "invalid content-length",
+		},
+		{
+			name:    "content-length too short",
+			data:    "SEND\ncontent-length:1\n\nabc\x00",
+			wantErr: "not followed by NULL",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse(%q) = %v, want error containing %q", tt.data, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.data, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBytesRoundTrip(t *testing.T) {
+	frame := NewFrame(Message, map[string]string{"destination": "/exchange/peril_topic/a:b", "note": "x\ny"}, []byte(`{"a":1}`))
+	frames, err := Parse(frame.Bytes())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("got %d frames, want 1", len(frames))
+	}
+	got := frames[0]
+	if got.Headers["destination"] != "/exchange/peril_topic/a:b" || got.Headers["note"] != "x\ny" || string(got.Body) != `{"a":1}` {
+		t.Errorf("round trip = %+v, want %+v", got, frame)
+	}
+}
@@ -23,9 +23,14 @@ cleanup() {
 # Setup trap for SIGINT
 trap 'cleanup' SIGINT
 
-# Start the specified number of instances of the program in the background
+# Start the specified number of instances of the program in the background.
+# If ADMIN_PORT is set, instance i serves the admin API on ADMIN_PORT+i
 for (( i=0; i<num_instances; i++ )); do
-  go run ./cmd/server &
+  args=()
+  if [ -n "$ADMIN_PORT" ]; then
+    args+=(-admin-addr "localhost:$((ADMIN_PORT + i))")
+  fi
+  go run ./cmd/server "${args[@]}" &
   pids+=($!)
 done
 